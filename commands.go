@@ -0,0 +1,210 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/todo-tracks/expiry"
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	// Exit statuses for the subcommands.
+	exitSuccess  = 0
+	exitFindings = 1
+	exitError    = 2
+)
+
+// Run the named subcommand with the given arguments, and return the exit status.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "check":
+		return runCheck(args)
+	case "file-issues":
+		return runFileIssues(args)
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n", name)
+	return exitError
+}
+
+// Read the branches to check, which are either the named branch or every branch.
+func readBranches(repository repo.Repository, branch string) []repo.Alias {
+	aliases := repository.ListBranches()
+	if branch == "" {
+		return aliases
+	}
+	for _, alias := range aliases {
+		if alias.Branch == branch {
+			return []repo.Alias{alias}
+		}
+	}
+	return nil
+}
+
+// Read the given branch, or if it is empty, the repository's default branch, which is
+// the one that ListBranches counts the others' ahead and behind against. A repository
+// without a default branch, such as a plain directory, must have only one branch.
+func readBranchOrDefault(repository repo.Repository, branch string) []repo.Alias {
+	if branch != "" {
+		return readBranches(repository, branch)
+	}
+	aliases := repository.ListBranches()
+	for _, alias := range aliases {
+		if alias.Base != "" {
+			return readBranches(repository, alias.Base)
+		}
+	}
+	if len(aliases) == 1 {
+		return aliases
+	}
+	return nil
+}
+
+// Report the expired TODOs in the repositories under the current directory.
+// The exit status is exitFindings if any were found.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	expired := flags.Bool("expired", false, "Report TODOs that are past their due date, reference an old version, or are guarded by a flag that is gone.")
+	branch := flags.String("branch", "", "Branch to check. If empty, every branch is checked.")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if !*expired {
+		fmt.Fprintln(os.Stderr, "Nothing to check; pass --expired to check for expired TODOs")
+		return exitError
+	}
+	repos, _, err := getLocalRepos()
+	if err != nil || len(repos) == 0 {
+		fmt.Fprintln(os.Stderr, "Unable to find any local repositories under the current directory")
+		return exitError
+	}
+	checker := expiry.Checker{Now: time.Now(), VersionFile: versionFile}
+	status := exitSuccess
+	for _, repositoryPtr := range repos {
+		repository := *repositoryPtr
+		aliases := readBranches(repository, *branch)
+		if len(aliases) == 0 {
+			fmt.Fprintf(os.Stderr, "%s: no branch named %q\n", repository.GetRepoPath(), *branch)
+			status = exitError
+			continue
+		}
+		// The same TODO is usually in many branches, so only report it once.
+		reported := make(map[string][]string)
+		for _, alias := range aliases {
+			todos := repository.LoadRevisionTodos(alias.Revision, todoRegex, excludePaths)
+			expiredTodos, err := checker.Check(repository, alias.Revision, todos)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s: %v\n", repository.GetRepoPath(), alias.Branch, err)
+				status = exitError
+				continue
+			}
+			for _, todo := range expiredTodos {
+				message := fmt.Sprintf("%s:%d: %s: %s",
+					todo.FileName, todo.LineNumber, todo.Explanation, strings.TrimSpace(todo.Contents))
+				reported[message] = append(reported[message], alias.Branch)
+			}
+		}
+		messages := make([]string, 0, len(reported))
+		for message := range reported {
+			messages = append(messages, message)
+		}
+		sort.Strings(messages)
+		for _, message := range messages {
+			fmt.Printf("%s: %s [%s]\n", repository.GetRepoPath(), message,
+				strings.Join(reported[message], ", "))
+		}
+		if len(messages) > 0 && status == exitSuccess {
+			status = exitFindings
+		}
+	}
+	return status
+}
+
+// File issues for the TODOs that do not reference one, using the first configured
+// issue tracker that can create issues.
+func runFileIssues(args []string) int {
+	flags := flag.NewFlagSet("file-issues", flag.ContinueOnError)
+	branch := flags.String("branch", "", "Branch whose TODOs should be filed. If empty, the default branch's are.")
+	dryRun := flags.Bool("dry_run", false, "Print the TODOs that would be filed, without filing them.")
+	limit := flags.Int("limit", 10, "Maximum number of issues to file in each repository.")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if issueMappingFile == "" {
+		fmt.Fprintln(os.Stderr, "The --issue_mapping_file flag is required, so that filed issues are remembered")
+		return exitError
+	}
+	linker, err := newIssueLinker()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if linker == nil || linker.Creator() == nil {
+		fmt.Fprintln(os.Stderr, "No configured issue tracker can create issues; pass --issue_tracker")
+		return exitError
+	}
+	repos, _, err := getLocalRepos()
+	if err != nil || len(repos) == 0 {
+		fmt.Fprintln(os.Stderr, "Unable to find any local repositories under the current directory")
+		return exitError
+	}
+	status := exitSuccess
+	for _, repositoryPtr := range repos {
+		repository := *repositoryPtr
+		aliases := readBranchOrDefault(repository, *branch)
+		if len(aliases) != 1 && *branch == "" {
+			fmt.Fprintf(os.Stderr, "%s: no default branch; pass --branch\n", repository.GetRepoPath())
+			status = exitError
+			continue
+		} else if len(aliases) != 1 {
+			fmt.Fprintf(os.Stderr, "%s: no branch named %q\n", repository.GetRepoPath(), *branch)
+			status = exitError
+			continue
+		}
+		filed := 0
+		for _, todo := range repository.LoadRevisionTodos(aliases[0].Revision, todoRegex, excludePaths) {
+			if filed >= *limit {
+				break
+			}
+			if len(linker.TodoLinks(repository.GetRepoId(), todo)) > 0 {
+				continue
+			}
+			filed++
+			if *dryRun {
+				fmt.Printf("%s: %s:%d: would file: %s\n", repository.GetRepoPath(),
+					todo.FileName, todo.LineNumber, strings.TrimSpace(todo.Contents))
+				continue
+			}
+			link, err := linker.FileIssue(repository, todo)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s:%d: %v\n", repository.GetRepoPath(),
+					todo.FileName, todo.LineNumber, err)
+				status = exitError
+				continue
+			}
+			fmt.Printf("%s: %s:%d: filed %s\n", repository.GetRepoPath(),
+				todo.FileName, todo.LineNumber, link.Url)
+		}
+	}
+	return status
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func TestReadBranchOrDefault(t *testing.T) {
+	mainAlias := repo.Alias{Branch: "main", Revision: "mainRevision", Type: repo.BranchRef, Base: "main"}
+	featureAlias := repo.Alias{Branch: "feature", Revision: "featureRevision", Type: repo.BranchRef, Ahead: 1, Base: "main"}
+	repository := repotest.MockRepository{Aliases: []repo.Alias{featureAlias, mainAlias}}
+	for _, test := range []struct {
+		branch   string
+		expected []repo.Alias
+	}{
+		{"", []repo.Alias{mainAlias}},
+		{"feature", []repo.Alias{featureAlias}},
+		{"master", nil},
+	} {
+		if aliases := readBranchOrDefault(repository, test.branch); len(aliases) != len(test.expected) ||
+			(len(aliases) == 1 && aliases[0] != test.expected[0]) {
+			t.Errorf("Expected the branch %q to be read as %v, but saw %v", test.branch, test.expected, aliases)
+		}
+	}
+
+	// A repository without a default branch can only be read if it has a single branch.
+	dirAlias := repo.Alias{Branch: repo.DirBranch, Revision: "dirRevision", Type: repo.BranchRef}
+	if aliases := readBranchOrDefault(repotest.MockRepository{Aliases: []repo.Alias{dirAlias}}, ""); len(aliases) != 1 || aliases[0] != dirAlias {
+		t.Errorf("Expected the only branch to be read, but saw %v", aliases)
+	}
+	noDefault := repotest.MockRepository{Aliases: []repo.Alias{dirAlias, featureAlias}}
+	noDefault.Aliases[1].Base = ""
+	if aliases := readBranchOrDefault(noDefault, ""); len(aliases) != 0 {
+		t.Errorf("Expected no default branch, but saw %v", aliases)
+	}
+}
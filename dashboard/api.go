@@ -0,0 +1,296 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/todo-tracks/expiry"
+	"github.com/google/todo-tracks/issues"
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	// Prefix of the paths of the versioned JSON API.
+	ApiPrefix = "/api/v1/"
+
+	jsonContentType = "application/json; charset=utf-8"
+)
+
+// An error returned by the versioned JSON API.
+type ApiError struct {
+	// The HTTP status code of the response.
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func (err ApiError) Error() string {
+	return err.Message
+}
+
+func newApiError(status int, err error) ApiError {
+	return ApiError{Status: status, Message: err.Error()}
+}
+
+// The body of every successful response from the versioned JSON API.
+type ApiResponse struct {
+	Data interface{} `json:"data"`
+	// The cursor for the next page of data, if the data is paginated and there are more pages.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// The body of every error response from the versioned JSON API.
+type ApiErrorResponse struct {
+	Error ApiError `json:"error"`
+}
+
+// A single endpoint of the versioned JSON API.
+type apiEndpoint struct {
+	method string
+	handle func(r *http.Request) (ApiResponse, error)
+}
+
+func writeApiJson(w http.ResponseWriter, status int, body interface{}) {
+	bodyJson, err := json.Marshal(body)
+	if err != nil {
+		status = http.StatusInternalServerError
+		bodyJson, _ = json.Marshal(ApiErrorResponse{
+			ApiError{status, fmt.Sprintf("Server error \"%s\"", err)}})
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.WriteHeader(status)
+	w.Write(bodyJson)
+}
+
+func writeApiError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(ApiError)
+	if !ok {
+		apiErr = ApiError{http.StatusInternalServerError, fmt.Sprintf("Server error \"%s\"", err)}
+	}
+	writeApiJson(w, apiErr.Status, ApiErrorResponse{apiErr})
+}
+
+// Write an error from one of the helpers shared with the versioned JSON API as plain
+// text, with the error's status if it is an ApiError.
+func writeError(w http.ResponseWriter, err error) {
+	if apiErr, ok := err.(ApiError); ok {
+		w.WriteHeader(apiErr.Status)
+		fmt.Fprint(w, apiErr.Message)
+		return
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+	fmt.Fprintf(w, "Server error \"%s\"", err)
+}
+
+// Write the given data as JSON, without the envelope of the versioned JSON API.
+func writeJson(w http.ResponseWriter, data interface{}) {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(dataJson)
+}
+
+func (endpoint apiEndpoint) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != endpoint.method {
+		w.Header().Set("Allow", endpoint.method)
+		writeApiError(w, ApiError{http.StatusMethodNotAllowed,
+			fmt.Sprintf("Unsupported method %s, expected %s", r.Method, endpoint.method)})
+		return
+	}
+	response, err := endpoint.handle(r)
+	if err != nil {
+		writeApiError(w, err)
+		return
+	}
+	writeApiJson(w, http.StatusOK, response)
+}
+
+// Convert an error from reading the URL parameters into an API error. Unknown repos
+// are reported as not found, and every other error as a bad request.
+func (db Dashboard) apiParamError(r *http.Request, err error) error {
+	if repoId := r.URL.Query().Get("repo"); repoId != "" && db.Repositories[repoId] == nil {
+		return newApiError(http.StatusNotFound, err)
+	}
+	return newApiError(http.StatusBadRequest, err)
+}
+
+func (db Dashboard) readApiTodoId(r *http.Request) (repo.Repository, repo.TodoId, error) {
+	repositoryPtr, revision, fileName, lineNumber, err := db.readRepoRevisionPathAndLineNumberParams(r)
+	if err != nil {
+		return nil, repo.TodoId{}, db.apiParamError(r, err)
+	}
+	return *repositoryPtr, repo.TodoId{
+		Revision:   revision,
+		FileName:   fileName,
+		LineNumber: lineNumber,
+	}, nil
+}
+
+func (db Dashboard) apiRepos(r *http.Request) (ApiResponse, error) {
+	return ApiResponse{Data: db.listRepoPaths()}, nil
+}
+
+func (db Dashboard) apiBranches(r *http.Request) (ApiResponse, error) {
+	repositoryPtr, err := db.readRepoParam(r)
+	if err != nil {
+		return ApiResponse{}, db.apiParamError(r, err)
+	}
+	aliases := append((*repositoryPtr).ListBranches(), (*repositoryPtr).ListUncommitted()...)
+	if aliases == nil {
+		aliases = make([]repo.Alias, 0)
+	}
+	return ApiResponse{Data: aliases}, nil
+}
+
+func (db Dashboard) apiTodos(r *http.Request) (ApiResponse, error) {
+	repositoryPtr, revision, err := db.readRepoAndRevisionParams(r)
+	if err != nil {
+		return ApiResponse{}, db.apiParamError(r, err)
+	}
+	query, err := parseTodoQuery(r.URL.Query())
+	if err != nil {
+		return ApiResponse{}, newApiError(http.StatusBadRequest, err)
+	}
+	repository := *repositoryPtr
+	todos, nextCursor := query.apply(repository,
+		repository.LoadRevisionTodos(revision, db.TodoRegex, db.ExcludePaths), time.Now())
+	return ApiResponse{Data: todos, NextCursor: nextCursor}, nil
+}
+
+func (db Dashboard) apiExpired(r *http.Request) (ApiResponse, error) {
+	repositoryPtr, revision, err := db.readRepoAndRevisionParams(r)
+	if err != nil {
+		return ApiResponse{}, db.apiParamError(r, err)
+	}
+	repository := *repositoryPtr
+	checker := expiry.Checker{Now: time.Now(), VersionFile: db.VersionFile}
+	expired, err := checker.Check(repository, revision,
+		repository.LoadRevisionTodos(revision, db.TodoRegex, db.ExcludePaths))
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: expired}, nil
+}
+
+func (db Dashboard) apiTodo(r *http.Request) (ApiResponse, error) {
+	repository, todoId, err := db.readApiTodoId(r)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: db.loadTodoDetails(repository, todoId)}, nil
+}
+
+func (db Dashboard) apiTodoStatus(r *http.Request) (ApiResponse, error) {
+	repository, todoId, err := db.readApiTodoId(r)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	status, err := repo.LoadTodoStatus(repository, todoId)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: status}, nil
+}
+
+func (db Dashboard) apiCompare(r *http.Request) (ApiResponse, error) {
+	diff, err := db.compareTodos(r)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: diff}, nil
+}
+
+func (db Dashboard) apiFileIssue(r *http.Request) (ApiResponse, error) {
+	if err := checkRequestedWith(r); err != nil {
+		return ApiResponse{}, newApiError(http.StatusForbidden, err)
+	}
+	if db.Issues == nil {
+		return ApiResponse{}, newApiError(http.StatusNotFound,
+			errors.New("No issue trackers are configured"))
+	}
+	repository, todoId, err := db.readApiTodoId(r)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	link, err := db.Issues.FileIssue(repository, readTodoLine(repository, todoId))
+	if err == issues.ErrAlreadyTracked {
+		return ApiResponse{}, newApiError(http.StatusConflict, err)
+	}
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: link}, nil
+}
+
+func (db Dashboard) apiSearch(r *http.Request) (ApiResponse, error) {
+	hits, err := db.searchTodos(r)
+	if err != nil {
+		return ApiResponse{}, err
+	}
+	return ApiResponse{Data: hits}, nil
+}
+
+func (db Dashboard) apiEndpoints() map[string]apiEndpoint {
+	return map[string]apiEndpoint{
+		"repos":      {"GET", db.apiRepos},
+		"branches":   {"GET", db.apiBranches},
+		"todos":      {"GET", db.apiTodos},
+		"expired":    {"GET", db.apiExpired},
+		"todo":       {"GET", db.apiTodo},
+		"todoStatus": {"GET", db.apiTodoStatus},
+		"compare":    {"GET", db.apiCompare},
+		"fileIssue":  {"POST", db.apiFileIssue},
+		"search":     {"GET", db.apiSearch},
+	}
+}
+
+// Get the handlers of the versioned JSON API, keyed by their paths. The OpenAPI
+// document describing the API is served from "openapi.json", and requests for
+// any other path under the API prefix get a not found error. Requests that name
+// revisions by anything but their full hashes are redirected, as described in
+// ResolveRevisions.
+func (db Dashboard) ApiHandlers() map[string]http.HandlerFunc {
+	handlers := make(map[string]http.HandlerFunc)
+	for name, endpoint := range db.apiEndpoints() {
+		handlers[ApiPrefix+name] = endpoint.serve
+	}
+	handlers[ApiPrefix+"todos"] = db.CacheByRevision(handlers[ApiPrefix+"todos"])
+	if db.TodoDetailsAreImmutable() {
+		handlers[ApiPrefix+"todo"] = db.CacheByRevision(handlers[ApiPrefix+"todo"])
+	}
+	for path, handler := range handlers {
+		handlers[path] = db.ResolveRevisions(handler)
+	}
+	handlers[ApiPrefix+"openapi.json"] = ServeOpenApi
+	handlers[ApiPrefix] = func(w http.ResponseWriter, r *http.Request) {
+		writeApiError(w, ApiError{http.StatusNotFound, fmt.Sprintf("Unknown API path %s", r.URL.Path)})
+	}
+	return handlers
+}
+
+// Serve the OpenAPI document describing the versioned JSON API.
+func ServeOpenApi(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write([]byte(OpenApiDocument))
+}
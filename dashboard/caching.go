@@ -0,0 +1,251 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// Changing this invalidates every ETag that has been handed out, and must be
+	// done whenever the format of a revision-addressed response changes.
+	etagVersion = "1"
+
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Suffix added to the ETags of gzip-compressed responses, so that they differ
+	// from the ETags of the uncompressed ones.
+	gzipEtagSuffix = "-gzip"
+)
+
+var fullRevisionRegexp = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// URL parameters that name revisions.
+var revisionParams = []string{"revision", "from", "to"}
+
+// URL parameters whose responses depend on the current time, and so are never immutable.
+var timeDependentParams = []string{"overdue", "minAgeDays", "maxAgeDays"}
+
+// A response writer that holds the response in memory, so that it can be inspected
+// before it is sent.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (response *bufferedResponse) Header() http.Header {
+	return response.header
+}
+
+func (response *bufferedResponse) WriteHeader(status int) {
+	response.status = status
+}
+
+func (response *bufferedResponse) Write(data []byte) (int, error) {
+	return response.body.Write(data)
+}
+
+// Send the buffered response to the given writer.
+func (response *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for key, values := range response.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(response.status)
+	w.Write(response.body.Bytes())
+}
+
+// Compute the ETag for a request that names a revision by its full hash, if its
+// response cannot change because the repository's revisions never do. The ETag covers the request's path and parameters, the
+// resolved repo, and the configuration that affects which TODOs are found.
+func (db Dashboard) immutableEtag(r *http.Request) (string, bool) {
+	query := r.URL.Query()
+	if !fullRevisionRegexp.MatchString(query.Get("revision")) {
+		return "", false
+	}
+	for _, param := range timeDependentParams {
+		if query.Get(param) != "" {
+			return "", false
+		}
+	}
+	repository, err := db.readRepoParam(r)
+	if err != nil || !(*repository).RevisionsAreImmutable() {
+		return "", false
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\x00%s\x00%s\x00%s\x00%s\x00%s",
+		etagVersion, (*repository).GetRepoId(), db.TodoRegex, db.ExcludePaths, db.VersionFile, r.URL.Path)
+	for _, key := range keys {
+		for _, value := range query[key] {
+			fmt.Fprintf(hash, "\x00%s=%s", key, value)
+		}
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, true
+}
+
+// Whether the details of a TODO at a given revision can never change. They can when
+// they include the issues referenced by the TODO, as the issues may be filed or closed.
+func (db Dashboard) TodoDetailsAreImmutable() bool {
+	return db.Issues == nil
+}
+
+// Report whether the If-None-Match header of the request matches the given ETag,
+// in either its uncompressed or its compressed form.
+func etagMatches(r *http.Request, etag string) bool {
+	compressedEtag := strings.TrimSuffix(etag, `"`) + gzipEtagSuffix + `"`
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag || candidate == compressedEtag {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap a handler for a revision-addressed resource, whose response is determined
+// by the request when the revision is given as a full commit hash. Successful
+// responses for such requests get a strong ETag and are marked as immutable, and
+// requests whose If-None-Match header matches the ETag get a 304 response without
+// calling the handler.
+func (db Dashboard) CacheByRevision(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		etag, ok := db.immutableEtag(r)
+		if !ok || (r.Method != "GET" && r.Method != "HEAD") {
+			handler(w, r)
+			return
+		}
+		if etagMatches(r, etag) {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", immutableCacheControl)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		response := newBufferedResponse()
+		handler(response, r)
+		if response.status == http.StatusOK {
+			response.header.Set("ETag", etag)
+			response.header.Set("Cache-Control", immutableCacheControl)
+		}
+		response.writeTo(w)
+	}
+}
+
+// Wrap a handler so that requests naming a revision by anything other than its full
+// commit hash, such as a branch name, a tag, or an abbreviated hash, are redirected
+// to the same URL with the full hash, whose response can then be cached. Revisions
+// that cannot be resolved are left for the handler to report.
+func (db Dashboard) ResolveRevisions(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		repository, err := db.readRepoParam(r)
+		if err != nil {
+			handler(w, r)
+			return
+		}
+		query := r.URL.Query()
+		resolved := false
+		for _, param := range revisionParams {
+			value := query.Get(param)
+			if value == "" || fullRevisionRegexp.MatchString(value) {
+				continue
+			}
+			revision, err := (*repository).ValidateRevision(value)
+			if err != nil {
+				handler(w, r)
+				return
+			}
+			if string(revision) != value {
+				query.Set(param, string(revision))
+				resolved = true
+			}
+		}
+		if !resolved {
+			handler(w, r)
+			return
+		}
+		canonicalUrl := *r.URL
+		canonicalUrl.RawQuery = query.Encode()
+		// The redirect is temporary, since the revision a name refers to can change,
+		// and keeps the method so that it also works for POST requests.
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, canonicalUrl.RequestURI(), http.StatusTemporaryRedirect)
+	}
+}
+
+func isCompressible(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/") || strings.Contains(contentType, "json") ||
+		strings.Contains(contentType, "xml")
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(strings.SplitN(encoding, ";", 2)[0])
+		if encoding == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap a handler so that its textual responses, such as JSON, are compressed with
+// gzip for the clients that accept it. The whole response is held in memory, so this
+// must not be used for streaming responses.
+func Compress(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler(w, r)
+			return
+		}
+		response := newBufferedResponse()
+		handler(response, r)
+		etag := response.header.Get("ETag")
+		if etag != "" && (response.status == http.StatusNotModified ||
+			isCompressible(response.header.Get("Content-Type"))) {
+			response.header.Set("ETag", strings.TrimSuffix(etag, `"`)+gzipEtagSuffix+`"`)
+		}
+		if response.status == http.StatusNotModified || response.body.Len() == 0 ||
+			!isCompressible(response.header.Get("Content-Type")) ||
+			response.header.Get("Content-Encoding") != "" {
+			response.writeTo(w)
+			return
+		}
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(response.body.Bytes())
+		writer.Close()
+		response.header.Set("Content-Encoding", "gzip")
+		response.header.Del("Content-Length")
+		response.body = compressed
+		response.writeTo(w)
+	}
+}
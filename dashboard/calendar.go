@@ -0,0 +1,157 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	calendarMediaType = "text/calendar; charset=utf-8"
+	// Maximum length, in octets, of a content line in an iCalendar file.
+	maxCalendarLineLength = 75
+)
+
+// A TODO with a due date, along with the branches it was found in.
+type calendarTodo struct {
+	todo     repo.Line
+	deadline time.Time
+	branches []string
+}
+
+func escapeCalendarText(text string) string {
+	return strings.NewReplacer(
+		"\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n", "\r", "").Replace(text)
+}
+
+// Write a single content line, folding it as required by RFC 5545.
+// Each continuation line starts with a space, which counts towards its length.
+func writeCalendarLine(buf *bytes.Buffer, line string) {
+	maxLength := maxCalendarLineLength
+	for len(line) > maxLength {
+		split := maxLength
+		// Avoid splitting a multi-byte UTF-8 sequence.
+		for split > 0 && line[split]&0xC0 == 0x80 {
+			split--
+		}
+		buf.WriteString(line[:split])
+		buf.WriteString("\r\n ")
+		line = line[split:]
+		maxLength = maxCalendarLineLength - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// Serve an iCalendar feed of the TODOs that have due dates.
+// The repo is taken from the URL parameters of the request. The optional "owner"
+// parameter restricts the feed to the TODOs of one owner, and the optional "branch"
+// parameter restricts it to a single branch. TODOs found in several branches are
+// only listed once.
+func (db Dashboard) ServeCalendar(w http.ResponseWriter, r *http.Request) {
+	repositoryPtr, err := db.readRepoParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Error loading repo: \"%s\"", err)
+		return
+	}
+	repository := *repositoryPtr
+	owner := r.URL.Query().Get("owner")
+	branch := r.URL.Query().Get("branch")
+	aliases := repository.ListBranches()
+	if branch != "" {
+		alias, err := db.readBranchParam(r, repository)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, err)
+			return
+		}
+		aliases = []repo.Alias{alias}
+	}
+
+	todosByKey := make(map[repo.Line]*calendarTodo)
+	for _, alias := range aliases {
+		for _, todo := range repository.LoadRevisionTodos(alias.Revision, db.TodoRegex, db.ExcludePaths) {
+			if owner != "" && repo.TodoOwner(todo.Contents) != owner {
+				continue
+			}
+			deadline, ok := repo.TodoDeadline(todo.Contents)
+			if !ok {
+				continue
+			}
+			// TODOs are located relative to the revision that last modified them,
+			// so the same TODO in different branches has the same key.
+			if entry, ok := todosByKey[todo]; ok {
+				entry.branches = append(entry.branches, alias.Branch)
+				continue
+			}
+			todosByKey[todo] = &calendarTodo{
+				todo:     todo,
+				deadline: deadline,
+				branches: []string{alias.Branch},
+			}
+		}
+	}
+	entries := make([]*calendarTodo, 0, len(todosByKey))
+	for _, entry := range todosByKey {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].deadline.Equal(entries[j].deadline) {
+			return entries[i].deadline.Before(entries[j].deadline)
+		}
+		if entries[i].todo.FileName != entries[j].todo.FileName {
+			return entries[i].todo.FileName < entries[j].todo.FileName
+		}
+		return entries[i].todo.LineNumber < entries[j].todo.LineNumber
+	})
+
+	var buf bytes.Buffer
+	now := time.Now().UTC().Format("20060102T150405Z")
+	writeCalendarLine(&buf, "BEGIN:VCALENDAR")
+	writeCalendarLine(&buf, "VERSION:2.0")
+	writeCalendarLine(&buf, "PRODID:-//Google//TODO Tracks//EN")
+	writeCalendarLine(&buf, "X-WR-CALNAME:"+escapeCalendarText(
+		"TODOs in "+repository.GetRepoPath()))
+	for _, entry := range entries {
+		todo := entry.todo
+		uid := fmt.Sprintf("%x", sha1.Sum([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s",
+			repository.GetRepoId(), todo.Revision, todo.FileName, todo.Contents))))
+		description := fmt.Sprintf("%s:%d\nBranches: %s",
+			todo.FileName, todo.LineNumber, strings.Join(entry.branches, ", "))
+		writeCalendarLine(&buf, "BEGIN:VEVENT")
+		writeCalendarLine(&buf, "UID:"+uid+"@todo-tracks")
+		writeCalendarLine(&buf, "DTSTAMP:"+now)
+		writeCalendarLine(&buf, "DTSTART;VALUE=DATE:"+entry.deadline.Format("20060102"))
+		writeCalendarLine(&buf, "DTEND;VALUE=DATE:"+entry.deadline.AddDate(0, 0, 1).Format("20060102"))
+		writeCalendarLine(&buf, "SUMMARY:"+escapeCalendarText(strings.TrimSpace(todo.Contents)))
+		writeCalendarLine(&buf, "DESCRIPTION:"+escapeCalendarText(description))
+		writeCalendarLine(&buf, "URL:"+todoDetailsUrl(r, repository.GetRepoId(), todo))
+		writeCalendarLine(&buf, "END:VEVENT")
+	}
+	writeCalendarLine(&buf, "END:VCALENDAR")
+	w.Header().Set("Content-Type", calendarMediaType)
+	w.Write(buf.Bytes())
+}
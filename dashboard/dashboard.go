@@ -17,27 +17,57 @@ limitations under the License.
 package dashboard
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"net/url"
+	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/todo-tracks/expiry"
+	"github.com/google/todo-tracks/issues"
+	"github.com/google/todo-tracks/metrics"
 	"github.com/google/todo-tracks/repo"
 	"github.com/google/todo-tracks/resources"
+	"github.com/google/todo-tracks/search"
 )
 
 const (
 	fileContentsResource = "file_contents.html"
+	defaultSearchHits    = 100
+
+	// Header that requests which change anything, such as filing an issue, must set,
+	// e.g. to "XMLHttpRequest". Browsers only let pages send custom headers to other
+	// origins that allow it, which this server never does, so the header shows that
+	// the request did not come from a form or a script on another site.
+	RequestedWithHeader = "X-Requested-With"
 )
 
 type Dashboard struct {
 	Repositories map[string]*repo.Repository
 	TodoRegex    string
 	ExcludePaths string
+	// Path, within each repository, of the file holding its current version.
+	VersionFile string
+	// If not nil, used to link the issues referenced in TODOs.
+	Issues *issues.Linker
+	// If not nil, used to search the TODOs in every repository and branch.
+	Search *search.Index
+}
+
+// The TODO details JSON, extended with the issues that the TODO references.
+type todoDetailsWithIssues struct {
+	repo.TodoDetails
+	Issues []issues.Link
+	// Whether or not an issue can be filed for the TODO through the dashboard.
+	CanFileIssue bool
 }
 
 func (db Dashboard) readRepoParam(r *http.Request) (*repo.Repository, error) {
@@ -74,6 +104,28 @@ func (db Dashboard) readRepoAndRevisionParams(r *http.Request) (*repo.Repository
 	return repository, revision, nil
 }
 
+// Read the repo, and the two revisions to compare from the "from" and "to" parameters.
+func (db Dashboard) readRepoAndComparedRevisionParams(r *http.Request) (*repo.Repository, repo.Revision, repo.Revision, error) {
+	repository, err := db.readRepoParam(r)
+	if err != nil {
+		return nil, repo.Revision(""), repo.Revision(""), err
+	}
+	revisions := make([]repo.Revision, 0, 2)
+	for _, param := range []string{"from", "to"} {
+		revisionParam := r.URL.Query().Get(param)
+		if revisionParam == "" {
+			return nil, repo.Revision(""), repo.Revision(""),
+				errors.New(fmt.Sprintf("Missing the %s parameter", param))
+		}
+		revision, err := (*repository).ValidateRevision(revisionParam)
+		if err != nil {
+			return nil, repo.Revision(""), repo.Revision(""), err
+		}
+		revisions = append(revisions, revision)
+	}
+	return repository, revisions[0], revisions[1], nil
+}
+
 func (db Dashboard) readRepoRevisionAndPathParams(r *http.Request) (*repo.Repository, repo.Revision, string, error) {
 	repository, revision, err := db.readRepoAndRevisionParams(r)
 	if err != nil {
@@ -127,6 +179,7 @@ func (db Dashboard) ServeAliasesJson(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	repository := *repositoryPtr
+	w.Header().Set("Content-Type", jsonContentType)
 	err = repo.WriteJson(w, repository)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -136,6 +189,9 @@ func (db Dashboard) ServeAliasesJson(w http.ResponseWriter, r *http.Request) {
 
 // Serve the JSON for a single revision.
 // The ID of the revision is taken from the URL parameters of the request.
+// The TODOs can be filtered, sorted, and paginated using the parameters described
+// in parseTodoQuery. If there are more TODOs after the returned page, then the cursor
+// for the next page is returned in the X-Next-Cursor header.
 func (db Dashboard) ServeRevisionJson(w http.ResponseWriter, r *http.Request) {
 	repositoryPtr, revision, err := db.readRepoAndRevisionParams(r)
 	if err != nil {
@@ -143,17 +199,92 @@ func (db Dashboard) ServeRevisionJson(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, err.Error())
 		return
 	}
+	query, err := parseTodoQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	repository := *repositoryPtr
+	todos, nextCursor := query.apply(repository,
+		repository.LoadRevisionTodos(revision, db.TodoRegex, db.ExcludePaths), time.Now())
+	todosJson, err := json.Marshal(todos)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	if nextCursor != "" {
+		w.Header().Set(NextCursorHeader, nextCursor)
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(todosJson)
+}
+
+// Serve the JSON for the expired TODOs in a single revision.
+// The ID of the revision is taken from the URL parameters of the request.
+func (db Dashboard) ServeExpiredJson(w http.ResponseWriter, r *http.Request) {
+	repositoryPtr, revision, err := db.readRepoAndRevisionParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
 	repository := *repositoryPtr
-	err = repo.WriteTodosJson(
-		w, repository, revision, db.TodoRegex, db.ExcludePaths)
+	todos := repository.LoadRevisionTodos(revision, db.TodoRegex, db.ExcludePaths)
+	checker := expiry.Checker{Now: time.Now(), VersionFile: db.VersionFile}
+	expired, err := checker.Check(repository, revision, todos)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	expiredJson, err := json.Marshal(expired)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(expiredJson)
+}
+
+// Search for the TODOs, in every repository and branch, that match the "q" parameter
+// of the request, returning at most as many hits as the optional "limit" parameter.
+// This is shared by ServeSearchJson and the versioned JSON API.
+func (db Dashboard) searchTodos(r *http.Request) ([]search.Hit, error) {
+	if db.Search == nil {
+		return nil, newApiError(http.StatusNotFound, errors.New("Search is not enabled"))
+	}
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" {
+		return nil, newApiError(http.StatusBadRequest, errors.New("Missing the q parameter"))
+	}
+	limit, err := parseNonNegativeParam(r.URL.Query(), "limit", defaultSearchHits)
+	if err != nil {
+		return nil, newApiError(http.StatusBadRequest, err)
+	}
+	if limit == 0 || limit > maxPageSize {
+		limit = maxPageSize
 	}
+	return db.Search.Search(query, limit), nil
+}
+
+// Serve the JSON for the TODOs, in every repository and branch, that match a search.
+// The search query is taken from the "q" parameter of the request, and the maximum
+// number of hits from the optional "limit" parameter.
+func (db Dashboard) ServeSearchJson(w http.ResponseWriter, r *http.Request) {
+	hits, err := db.searchTodos(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, hits)
 }
 
 // Serve the details JSON for a single TODO.
 // The revision, path, and line number are all taken from the URL parameters of the request.
+// If issue trackers are configured, the issues referenced by the TODO are also included.
 func (db Dashboard) ServeTodoJson(w http.ResponseWriter, r *http.Request) {
 	repositoryPtr, revision, fileName, lineNumber, err := db.readRepoRevisionPathAndLineNumberParams(r)
 	if err != nil {
@@ -167,7 +298,110 @@ func (db Dashboard) ServeTodoJson(w http.ResponseWriter, r *http.Request) {
 		FileName:   fileName,
 		LineNumber: lineNumber,
 	}
-	repo.WriteTodoDetailsJson(w, repository, todoId)
+	if db.Issues == nil {
+		w.Header().Set("Content-Type", jsonContentType)
+		repo.WriteTodoDetailsJson(w, repository, todoId)
+		return
+	}
+	detailsJson, err := json.Marshal(db.loadTodoDetails(repository, todoId))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(detailsJson)
+}
+
+// Load the details of a TODO, along with its issues if issue trackers are configured.
+func (db Dashboard) loadTodoDetails(repository repo.Repository, todoId repo.TodoId) todoDetailsWithIssues {
+	// TODO: Make the lines before and after a parameter.
+	details := todoDetailsWithIssues{
+		TodoDetails: *repo.LoadTodoDetails(repository, todoId, 5, 5),
+		Issues:      make([]issues.Link, 0),
+	}
+	if db.Issues != nil {
+		details.Issues = db.Issues.TodoLinks(repository.GetRepoId(), readTodoLine(repository, todoId))
+		details.CanFileIssue = len(details.Issues) == 0 &&
+			db.Issues.Mapping != nil && db.Issues.Creator() != nil
+	}
+	return details
+}
+
+// Read the TODO at the given location. The location should be relative to the
+// revision that last modified the TODO, so that it matches the lines loaded from blame.
+func readTodoLine(repository repo.Repository, todoId repo.TodoId) repo.Line {
+	contents := repository.ReadFileSnippetAtRevision(
+		todoId.Revision, todoId.FileName, todoId.LineNumber, todoId.LineNumber+1)
+	return repo.Line{
+		Revision:   todoId.Revision,
+		FileName:   todoId.FileName,
+		LineNumber: todoId.LineNumber,
+		Contents:   strings.TrimSuffix(contents, "\n"),
+	}
+}
+
+// Check that a request which changes something was sent by this server's own pages,
+// or by a client other than a browser, rather than forged by another site.
+func checkRequestedWith(r *http.Request) error {
+	if r.Header.Get(RequestedWithHeader) == "" {
+		return errors.New(fmt.Sprintf("The request must set the %s header", RequestedWithHeader))
+	}
+	return nil
+}
+
+// File an issue for a single TODO, and serve the JSON link to the new issue.
+// The revision, path, and line number are all taken from the URL parameters of the request,
+// which must be a POST with the RequestedWithHeader set. TODOs that already reference an
+// issue are rejected.
+func (db Dashboard) ServeFileIssueJson(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, "Issues can only be filed with a POST request")
+		return
+	}
+	if err := checkRequestedWith(r); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, err)
+		return
+	}
+	if db.Issues == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "No issue trackers are configured")
+		return
+	}
+	repositoryPtr, revision, fileName, lineNumber, err := db.readRepoRevisionPathAndLineNumberParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	repository := *repositoryPtr
+	todoId := repo.TodoId{
+		Revision:   revision,
+		FileName:   fileName,
+		LineNumber: lineNumber,
+	}
+	link, err := db.Issues.FileIssue(repository, readTodoLine(repository, todoId))
+	if err == issues.ErrAlreadyTracked {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	linkJson, err := json.Marshal(link)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(linkJson)
 }
 
 // Serve the status details JSON for a single TODO.
@@ -185,7 +419,38 @@ func (db Dashboard) ServeTodoStatusJson(w http.ResponseWriter, r *http.Request)
 		FileName:   fileName,
 		LineNumber: lineNumber,
 	}
-	repo.WriteTodoStatusDetailsJson(w, repository, todoId)
+	var statusJson bytes.Buffer
+	if err := repo.WriteTodoStatusDetailsJson(&statusJson, repository, todoId); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", jsonContentType)
+	w.Write(statusJson.Bytes())
+}
+
+// Compare the TODOs of the revisions in the "from" and "to" URL parameters.
+// This is shared by ServeCompareJson and the versioned JSON API.
+func (db Dashboard) compareTodos(r *http.Request) (repo.TodoDiff, error) {
+	repositoryPtr, from, to, err := db.readRepoAndComparedRevisionParams(r)
+	if err != nil {
+		return repo.TodoDiff{}, db.apiParamError(r, err)
+	}
+	repository := *repositoryPtr
+	return repo.DiffTodos(
+		repository.LoadRevisionTodos(from, db.TodoRegex, db.ExcludePaths),
+		repository.LoadRevisionTodos(to, db.TodoRegex, db.ExcludePaths)), nil
+}
+
+// Serve the JSON for the changes to the TODOs between two revisions, such as two
+// release tags. The revisions are taken from the "from" and "to" URL parameters.
+func (db Dashboard) ServeCompareJson(w http.ResponseWriter, r *http.Request) {
+	diff, err := db.compareTodos(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJson(w, diff)
 }
 
 // Serve the redirect for browsing a file.
@@ -231,6 +496,7 @@ func (db Dashboard) ServeFileContents(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
 	}
 	repositoryPtr, revision, fileName, lineNumber, err := db.readRepoRevisionPathAndLineNumberParams(r)
 	if err != nil {
@@ -240,18 +506,34 @@ func (db Dashboard) ServeFileContents(w http.ResponseWriter, r *http.Request) {
 	}
 	repository := *repositoryPtr
 	contents := repository.ReadFileSnippetAtRevision(revision, fileName, 1, -1)
-	err = htmlTemplate.Execute(w, fileContents{
+	// Render to a buffer first, so that a failure does not leave a partial page.
+	var page bytes.Buffer
+	err = htmlTemplate.Execute(&page, fileContents{
 		LineNumber: lineNumber,
 		Contents:   contents})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.Bytes())
 }
 
 type repoPath struct {
 	Path   string
 	RepoId string
+	// Set for submodules that are checked out within another repository.
+	Superproject *superprojectLink `json:",omitempty"`
+}
+
+// The link from a submodule to the repository it is checked out in.
+type superprojectLink struct {
+	RepoId string
+	// The path of the submodule within the superproject.
+	Path string
+	// The revision of the submodule that the superproject's checked-out revision pins.
+	Revision repo.Revision
 }
 type sortByPath []repoPath
 
@@ -259,17 +541,105 @@ func (rs sortByPath) Len() int           { return len(rs) }
 func (rs sortByPath) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
 func (rs sortByPath) Less(i, j int) bool { return rs[i].Path < rs[j].Path }
 
-func (db Dashboard) ServeReposJson(w http.ResponseWriter, r *http.Request) {
+// The submodules of a repository's checked-out revision.
+type headSubmodules struct {
+	head       repo.Revision
+	submodules []repo.Submodule
+}
+
+// The submodules of each repository's checked-out revision, keyed by repo ID, so that
+// they are only read again once the repository checks out another revision.
+var headSubmodulesCache sync.Map
+
+// Read the submodules of a repository's checked-out revision, if it has one.
+func readHeadSubmodules(repoId string, repository repo.Repository) []repo.Submodule {
+	head, err := repository.ValidateRevision("HEAD")
+	if err != nil {
+		return nil
+	}
+	if cached, ok := headSubmodulesCache.Load(repoId); ok && cached.(headSubmodules).head == head {
+		return cached.(headSubmodules).submodules
+	}
+	submodules := repository.ReadRevisionContents(head).Submodules
+	headSubmodulesCache.Store(repoId, headSubmodules{head, submodules})
+	return submodules
+}
+
+// List the repositories sorted by path, linking each submodule to its superproject.
+func (db Dashboard) listRepoPaths() []repoPath {
+	repoIds := make(map[string]string)
+	for repoId, repository := range db.Repositories {
+		repoIds[(*repository).GetRepoPath()] = repoId
+	}
+	superprojects := make(map[string]*superprojectLink)
+	for repoId, repository := range db.Repositories {
+		// Only a repository that another one is checked out within can be a superproject.
+		superprojectPath := (*repository).GetRepoPath() + string(filepath.Separator)
+		hasNestedRepo := false
+		for path := range repoIds {
+			hasNestedRepo = hasNestedRepo || strings.HasPrefix(path, superprojectPath)
+		}
+		if !hasNestedRepo {
+			continue
+		}
+		for _, submodule := range readHeadSubmodules(repoId, *repository) {
+			submodulePath := filepath.Join((*repository).GetRepoPath(), filepath.FromSlash(submodule.Path))
+			if submoduleId, ok := repoIds[submodulePath]; ok {
+				superprojects[submoduleId] = &superprojectLink{repoId, submodule.Path, submodule.Revision}
+			}
+		}
+	}
 	repoPaths := make([]repoPath, 0)
 	for repoId, repository := range db.Repositories {
-		repoPaths = append(repoPaths, repoPath{(*repository).GetRepoPath(), repoId})
+		repoPaths = append(repoPaths, repoPath{(*repository).GetRepoPath(), repoId, superprojects[repoId]})
 	}
 	sort.Sort(sortByPath(repoPaths))
+	return repoPaths
+}
+
+func (db Dashboard) ServeReposJson(w http.ResponseWriter, r *http.Request) {
+	repoPaths := db.listRepoPaths()
 
 	reposJson, err := json.Marshal(repoPaths)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
 	}
+	w.Header().Set("Content-Type", jsonContentType)
 	w.Write(reposJson)
 }
+
+// Serve the metrics for the server and the TODOs in all of the repositories' branches,
+// using the Prometheus text exposition format. Only the branches that have already been
+// scanned are counted, so that scrapes never wait for a scan.
+func (db Dashboard) ServeMetrics(w http.ResponseWriter, r *http.Request) {
+	todoCounts := metrics.NewGaugeVec(
+		"todos_count",
+		"Number of TODOs in each branch, by category and owner.",
+		"repo", "branch", "category", "owner")
+	todoAges := metrics.NewHistogramVec(
+		"todos_age_days",
+		"Age of the TODOs in each branch, based on when they were last modified.",
+		metrics.DefaultAgeBuckets, "repo", "branch")
+	now := time.Now()
+	for _, repositoryPtr := range db.Repositories {
+		repository := *repositoryPtr
+		repoPath := repository.GetRepoPath()
+		for _, alias := range repository.ListBranches() {
+			todos, ok := repository.LoadCachedRevisionTodos(alias.Revision)
+			if !ok {
+				continue
+			}
+			for _, todo := range todos {
+				todoCounts.Add(1, repoPath, alias.Branch,
+					repo.TodoCategory(todo.Contents), repo.TodoOwner(todo.Contents))
+				timestamp := repository.ReadRevisionMetadata(todo.Revision).Timestamp
+				age := now.Sub(time.Unix(timestamp, 0)).Hours() / 24
+				todoAges.Observe(age, repoPath, alias.Branch)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", metrics.TextContentType)
+	metrics.WriteRegistered(w, todoCounts, todoAges)
+}
@@ -17,17 +17,28 @@ limitations under the License.
 package dashboard_test
 
 import (
+	"bufio"
+	"compress/gzip"
 	"encoding/json"
+	"encoding/xml"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/todo-tracks/dashboard"
+	"github.com/google/todo-tracks/expiry"
+	"github.com/google/todo-tracks/issues"
+	"github.com/google/todo-tracks/issues/issuestest"
 	"github.com/google/todo-tracks/repo"
 	"github.com/google/todo-tracks/repo/repotest"
+	"github.com/google/todo-tracks/search"
 )
 
 const (
@@ -43,7 +54,7 @@ var mockRepo repo.Repository
 var mockRepos map[string]*repo.Repository
 
 func init() {
-	mockAlias = repo.Alias{"branch", repo.Revision("revision")}
+	mockAlias = repo.Alias{Branch: "branch", Revision: repo.Revision("revision")}
 	mockTodo = repo.Line{
 		Revision:   repo.Revision(TestRevision),
 		FileName:   TestFileName,
@@ -70,7 +81,7 @@ func TestServeAliasesJsonNoRepo(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeAliasesJson(rw, request)
 	if rw.Code != http.StatusOK {
 		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
@@ -83,7 +94,7 @@ func TestServeAliasesJsonNoRepo(t *testing.T) {
 		t.Error(err)
 	}
 	if len(returnedAliases) != 1 || returnedAliases[0] != mockAlias {
-		t.Errorf("Expected a singleton slice of %s, but saw %s", mockAlias, returnedAliases)
+		t.Errorf("Expected a singleton slice of %v, but saw %v", mockAlias, returnedAliases)
 	}
 }
 
@@ -93,7 +104,7 @@ func TestServeAliasesJson(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeAliasesJson(rw, request)
 	if rw.Code != http.StatusOK {
 		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
@@ -106,7 +117,7 @@ func TestServeAliasesJson(t *testing.T) {
 		t.Error(err)
 	}
 	if len(returnedAliases) != 1 || returnedAliases[0] != mockAlias {
-		t.Errorf("Expected a singleton slice of %s, but saw %s", mockAlias, returnedAliases)
+		t.Errorf("Expected a singleton slice of %v, but saw %v", mockAlias, returnedAliases)
 	}
 }
 
@@ -116,7 +127,7 @@ func TestServeRevisionJsonNoRepo(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeRevisionJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -131,7 +142,7 @@ func TestServeRevisionJsonNoId(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeRevisionJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -147,7 +158,7 @@ func TestServeRevisionJson(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeRevisionJson(rw, request)
 	if rw.Code != http.StatusOK {
 		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
@@ -170,7 +181,7 @@ func TestServeTodoJsonNoRepo(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -185,7 +196,7 @@ func TestServeTodoJsonNoRevision(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -201,7 +212,7 @@ func TestServeTodoJsonNoFileName(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -218,7 +229,7 @@ func TestServeTodoJsonNoLineNumber(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -236,7 +247,7 @@ func TestServeTodoJsonInvalidLineNumber(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusBadRequest {
 		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
@@ -254,7 +265,7 @@ func TestServeTodoJson(t *testing.T) {
 		t.Error(err)
 	}
 	rw := httptest.NewRecorder()
-	db := dashboard.Dashboard{mockRepos, "", ""}
+	db := dashboard.Dashboard{Repositories: mockRepos}
 	db.ServeTodoJson(rw, request)
 	if rw.Code != http.StatusOK {
 		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
@@ -272,3 +283,1100 @@ func TestServeTodoJson(t *testing.T) {
 		t.Errorf("Expected %v, but saw %v", mockTodo, returnedTodo)
 	}
 }
+
+func TestServeMetrics(t *testing.T) {
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases: []repo.Alias{
+			{Branch: "master", Revision: repo.Revision(TestRevision)},
+			{Branch: "unscanned", Revision: repo.Revision("unscannedRevision")},
+		},
+		RevisionTodos: map[string][]repo.Line{
+			TestRevision: {
+				mockTodo,
+				{Revision: repo.Revision(TestRevision), FileName: TestFileName,
+					LineNumber: 7, Contents: "// FIXME(alice): fix this"},
+			},
+		},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	request, err := http.NewRequest("GET", "/metrics", strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: repos}
+	db.ServeMetrics(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	body := rw.Body.String()
+	for _, expected := range []string{
+		`todos_count{repo="~/repo/path",branch="master",category="TODO",owner=""} 1`,
+		`todos_count{repo="~/repo/path",branch="master",category="FIXME",owner="alice"} 1`,
+		`todos_age_days_count{repo="~/repo/path",branch="master"} 2`,
+		"# TYPE todos_git_commands_total counter",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected the metrics to contain '%s', but saw '%s'", expected, body)
+		}
+	}
+	if strings.Contains(body, `branch="unscanned"`) {
+		t.Errorf("Expected the branches that were not scanned yet to be left out, but saw '%s'", body)
+	}
+}
+
+func TestServeFeed(t *testing.T) {
+	addedTodo := repo.Line{
+		Revision:   repo.Revision("newRevision"),
+		FileName:   "newFile",
+		LineNumber: 3,
+		Contents:   "TODO: new work",
+	}
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases: []repo.Alias{{Branch: "master", Revision: repo.Revision("newRevision")}},
+		RevisionTodos: map[string][]repo.Line{
+			TestRevision:  {mockTodo},
+			"newRevision": {addedTodo},
+		},
+		History: map[string][]repo.Revision{
+			"newRevision": {repo.Revision("newRevision"), repo.Revision(TestRevision)},
+		},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	params := url.Values{}
+	params.Add("repo", repository.GetRepoId())
+	params.Add("branch", "master")
+	params.Add("commits", "1")
+	request, err := http.NewRequest("GET", "/feed?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: repos}
+	db.ServeFeed(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	var feed struct {
+		Entries []struct {
+			Title   string `xml:"title"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	err = xml.Unmarshal(rw.Body.Bytes(), &feed)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(feed.Entries) != 2 ||
+		!strings.HasPrefix(feed.Entries[0].Title, "TODO added") ||
+		!strings.Contains(feed.Entries[0].Content, addedTodo.Contents) ||
+		!strings.HasPrefix(feed.Entries[1].Title, "TODO resolved") ||
+		!strings.Contains(feed.Entries[1].Content, mockTodo.Contents) {
+		t.Errorf("Expected an added and a resolved entry, but saw %v", feed.Entries)
+	}
+}
+
+func TestServeFeedUnknownBranch(t *testing.T) {
+	params := url.Values{}
+	params.Add("repo", mockRepo.GetRepoId())
+	params.Add("branch", "missing")
+	request, err := http.NewRequest("GET", "/feed?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: mockRepos}
+	db.ServeFeed(rw, request)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
+	}
+}
+
+func TestServeFeedTooManyCommits(t *testing.T) {
+	params := url.Values{}
+	params.Add("repo", mockRepo.GetRepoId())
+	params.Add("branch", mockAlias.Branch)
+	params.Add("commits", "51")
+	request, err := http.NewRequest("GET", "/feed?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: mockRepos}
+	db.ServeFeed(rw, request)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
+	}
+}
+
+var overdueTodo = repo.Line{
+	Revision:   repo.Revision(TestRevision),
+	FileName:   TestFileName,
+	LineNumber: 10,
+	Contents:   "// TODO(alice, 2001-02-03): long overdue",
+}
+
+func newDeadlineRepos() map[string]*repo.Repository {
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases: []repo.Alias{
+			{Branch: "master", Revision: repo.Revision(TestRevision)},
+			{Branch: "release", Revision: repo.Revision(TestRevision)},
+		},
+		RevisionTodos: map[string][]repo.Line{
+			TestRevision: {mockTodo, overdueTodo},
+		},
+	}
+	return map[string]*repo.Repository{repository.GetRepoId(): &repository}
+}
+
+func TestServeRevisionJsonOverdue(t *testing.T) {
+	params := url.Values{}
+	params.Add("repo", mockRepo.GetRepoId())
+	params.Add("revision", TestRevision)
+	params.Add("overdue", "true")
+	request, err := http.NewRequest("GET", "/revision?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: newDeadlineRepos()}
+	db.ServeRevisionJson(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	var returnedTodos []repo.Line
+	err = json.Unmarshal(rw.Body.Bytes(), &returnedTodos)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(returnedTodos) != 1 || returnedTodos[0] != overdueTodo {
+		t.Errorf("Expected a singleton slice of %v, but saw %v", overdueTodo, returnedTodos)
+	}
+}
+
+func TestServeCalendar(t *testing.T) {
+	params := url.Values{}
+	params.Add("repo", mockRepo.GetRepoId())
+	params.Add("owner", "alice")
+	request, err := http.NewRequest("GET", "/calendar.ics?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: newDeadlineRepos()}
+	db.ServeCalendar(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	body := rw.Body.String()
+	if strings.Count(body, "BEGIN:VEVENT") != 1 ||
+		!strings.Contains(body, "DTSTART;VALUE=DATE:20010203\r\n") ||
+		!strings.Contains(body, "Branches: master\\, release") {
+		t.Errorf("Expected a single event for the overdue TODO, but saw '%s'", body)
+	}
+}
+
+func TestServeCalendarFoldsLongLines(t *testing.T) {
+	longTodo := overdueTodo
+	longTodo.Contents = "// TODO(alice, 2001-02-03): " + strings.Repeat("fold this é ", 30)
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases:       []repo.Alias{{Branch: "master", Revision: repo.Revision(TestRevision)}},
+		RevisionTodos: map[string][]repo.Line{TestRevision: {longTodo}},
+	}
+	db := dashboard.Dashboard{Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository}}
+	request, err := http.NewRequest("GET", "/calendar.ics?repo="+repository.GetRepoId(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeCalendar(rw, request)
+	body := rw.Body.String()
+	if !strings.Contains(body, "\r\n ") {
+		t.Fatalf("Expected the long summary to be folded, but saw '%s'", body)
+	}
+	// RFC 5545 limits every line, including the leading space of a continuation, to 75 octets.
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\r\n"), "\r\n") {
+		if len(line) > 75 || !utf8.ValidString(line) {
+			t.Errorf("Expected a valid line of at most 75 octets, but saw %d octets in %q", len(line), line)
+		}
+	}
+	unfolded := strings.ReplaceAll(body, "\r\n ", "")
+	if !strings.Contains(unfolded, strings.Repeat("fold this é ", 5)) {
+		t.Errorf("Expected the folded lines to join back into the summary, but saw '%s'", unfolded)
+	}
+}
+
+func TestServeExpiredJson(t *testing.T) {
+	params := url.Values{}
+	params.Add("repo", mockRepo.GetRepoId())
+	params.Add("revision", TestRevision)
+	request, err := http.NewRequest("GET", "/expired?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{Repositories: newDeadlineRepos()}
+	db.ServeExpiredJson(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	var returnedTodos []expiry.ExpiredTodo
+	err = json.Unmarshal(rw.Body.Bytes(), &returnedTodos)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(returnedTodos) != 1 || returnedTodos[0].Line != overdueTodo ||
+		returnedTodos[0].Reason != expiry.ReasonDeadline {
+		t.Errorf("Expected the overdue TODO %v, but saw %v", overdueTodo, returnedTodos)
+	}
+}
+
+func TestServeTodoJsonWithIssues(t *testing.T) {
+	fake := issuestest.NewFakeGitHub()
+	defer fake.Close()
+	fake.SetState("12", "closed")
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{TestRevision: {mockTodo}},
+		Files:         map[string]string{TestFileName: "package main\n// TODO(#12): fix\n"},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	params := url.Values{}
+	params.Add("repo", repository.GetRepoId())
+	params.Add("revision", TestRevision)
+	params.Add("fileName", TestFileName)
+	params.Add("lineNumber", "2")
+	request, err := http.NewRequest("GET", "/todo?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Error(err)
+	}
+	rw := httptest.NewRecorder()
+	db := dashboard.Dashboard{
+		Repositories: repos,
+		Issues: &issues.Linker{
+			Providers:   []issues.Provider{fake.Provider()},
+			FetchStates: true,
+		},
+	}
+	db.ServeTodoJson(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Errorf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+		return
+	}
+	var returnedTodo struct {
+		Id     repo.TodoId
+		Issues []issues.Link
+	}
+	err = json.Unmarshal(rw.Body.Bytes(), &returnedTodo)
+	if err != nil {
+		t.Error(err)
+	}
+	if returnedTodo.Id.LineNumber != 2 || len(returnedTodo.Issues) != 1 ||
+		returnedTodo.Issues[0].Id != "12" || returnedTodo.Issues[0].State != issues.StateClosed {
+		t.Errorf("Expected a single closed issue, but saw %v", returnedTodo)
+	}
+}
+
+func TestServeFileIssueJson(t *testing.T) {
+	fake := issuestest.NewFakeGitHub()
+	defer fake.Close()
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{TestRevision: {mockTodo}},
+		Files:         map[string]string{TestFileName: "package main\n// TODO: fix\n"},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	mapping, err := issues.LoadMapping("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dashboard.Dashboard{
+		Repositories: repos,
+		Issues: &issues.Linker{
+			Providers: []issues.Provider{fake.Provider()},
+			Mapping:   mapping,
+		},
+	}
+	params := url.Values{}
+	params.Add("repo", repository.GetRepoId())
+	params.Add("revision", TestRevision)
+	params.Add("fileName", TestFileName)
+	params.Add("lineNumber", "2")
+	fileIssue := func(method string) *httptest.ResponseRecorder {
+		request, err := http.NewRequest(method, "/fileIssue?"+params.Encode(), strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set(dashboard.RequestedWithHeader, "XMLHttpRequest")
+		rw := httptest.NewRecorder()
+		db.ServeFileIssueJson(rw, request)
+		return rw
+	}
+
+	if rw := fileIssue("GET"); rw.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusMethodNotAllowed, rw.Code)
+	}
+	// A form on another site can POST, but cannot set the header.
+	forged, err := http.NewRequest("POST", "/fileIssue?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	forgedRw := httptest.NewRecorder()
+	db.ServeFileIssueJson(forgedRw, forged)
+	if forgedRw.Code != http.StatusForbidden || len(fake.Created()) != 0 {
+		t.Errorf("Expected a request without the %s header to be rejected, but saw %d, with %d issues created",
+			dashboard.RequestedWithHeader, forgedRw.Code, len(fake.Created()))
+	}
+	rw := fileIssue("POST")
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+	}
+	var link issues.Link
+	if err := json.Unmarshal(rw.Body.Bytes(), &link); err != nil {
+		t.Fatal(err)
+	}
+	if link.Id != "1" || len(fake.Created()) != 1 {
+		t.Errorf("Expected a single issue to be created, but saw %v", link)
+	}
+	if rw := fileIssue("POST"); rw.Code != http.StatusConflict {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusConflict, rw.Code)
+	}
+
+	request, err := http.NewRequest("GET", "/todo?"+params.Encode(), strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw = httptest.NewRecorder()
+	db.ServeTodoJson(rw, request)
+	var returnedTodo struct {
+		Issues       []issues.Link
+		CanFileIssue bool
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &returnedTodo); err != nil {
+		t.Fatal(err)
+	}
+	if len(returnedTodo.Issues) != 1 || returnedTodo.Issues[0].Url != link.Url || returnedTodo.CanFileIssue {
+		t.Errorf("Expected the TODO to be tracked by %s, but saw %v", link.Url, returnedTodo)
+	}
+}
+
+func TestServeRevisionJsonFiltered(t *testing.T) {
+	now := time.Now().Unix()
+	todos := []repo.Line{
+		{Revision: "old", FileName: "ui/app.js", LineNumber: 1, Contents: "// TODO(alice): old js"},
+		{Revision: "new", FileName: "ui/app.js", LineNumber: 9, Contents: "// FIXME(alice): new js"},
+		{Revision: "new", FileName: "ui/lib/util.js", LineNumber: 3, Contents: "// TODO(alice): nested js"},
+		{Revision: "old", FileName: "main.go", LineNumber: 5, Contents: "// TODO(bob): go"},
+	}
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{TestRevision: todos},
+		Metadata: map[string]repo.RevisionMetadata{
+			"old": {Revision: "old", AuthorName: "Alice", Timestamp: now - 100*24*60*60},
+			"new": {Revision: "new", AuthorName: "Bob", Timestamp: now - 24*60*60},
+		},
+	}
+	db := dashboard.Dashboard{
+		Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository},
+	}
+	serve := func(params url.Values) ([]repo.Line, string) {
+		params.Set("repo", repository.GetRepoId())
+		params.Set("revision", TestRevision)
+		request, err := http.NewRequest("GET", "/revision?"+params.Encode(), strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		db.ServeRevisionJson(rw, request)
+		if rw.Code != http.StatusOK {
+			t.Fatalf("Expected a response code of %d, but saw %d, with a body of '%s'",
+				http.StatusOK, rw.Code, rw.Body.String())
+		}
+		var returnedTodos []repo.Line
+		if err := json.Unmarshal(rw.Body.Bytes(), &returnedTodos); err != nil {
+			t.Fatal(err)
+		}
+		return returnedTodos, rw.Header().Get(dashboard.NextCursorHeader)
+	}
+
+	returnedTodos, _ := serve(url.Values{"path": {"ui/*.js"}, "owner": {"alice"}, "category": {"todo"}})
+	if len(returnedTodos) != 1 || returnedTodos[0] != todos[0] {
+		t.Errorf("Expected only %v, but saw %v", todos[0], returnedTodos)
+	}
+	returnedTodos, _ = serve(url.Values{"author": {"bob"}, "q": {"JS"}, "sort": {"path"}})
+	if len(returnedTodos) != 2 || returnedTodos[0] != todos[1] || returnedTodos[1] != todos[2] {
+		t.Errorf("Expected %v, but saw %v", todos[1:3], returnedTodos)
+	}
+	returnedTodos, _ = serve(url.Values{"minAgeDays": {"30"}, "regex": {"go$"}})
+	if len(returnedTodos) != 1 || returnedTodos[0] != todos[3] {
+		t.Errorf("Expected only %v, but saw %v", todos[3], returnedTodos)
+	}
+
+	// Page through every TODO, oldest first.
+	var paged []repo.Line
+	params := url.Values{"sort": {"oldest"}, "limit": {"3"}}
+	for {
+		page, cursor := serve(params)
+		paged = append(paged, page...)
+		if cursor == "" {
+			break
+		}
+		params.Set("cursor", cursor)
+	}
+	expected := []repo.Line{todos[3], todos[0], todos[1], todos[2]}
+	if len(paged) != len(expected) {
+		t.Fatalf("Expected %v, but saw %v", expected, paged)
+	}
+	for i := range expected {
+		if paged[i] != expected[i] {
+			t.Errorf("Expected %v at %d, but saw %v", expected[i], i, paged[i])
+		}
+	}
+}
+
+func TestServeRevisionJsonBadQuery(t *testing.T) {
+	for _, query := range []string{"sort=sideways", "limit=-1", "cursor=bogus", "regex=%28", "minAgeDays=x"} {
+		request, err := http.NewRequest("GET", "/revision?repo=repoID&revision="+TestRevision+"&"+query,
+			strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		db := dashboard.Dashboard{Repositories: mockRepos}
+		db.ServeRevisionJson(rw, request)
+		if rw.Code != http.StatusBadRequest {
+			t.Errorf("Expected a response code of %d for %q, but saw %d",
+				http.StatusBadRequest, query, rw.Code)
+		}
+	}
+}
+
+func TestServeSearchJson(t *testing.T) {
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases:       []repo.Alias{{Branch: "master", Revision: TestRevision}},
+		RevisionTodos: map[string][]repo.Line{TestRevision: {mockTodo}},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	db := dashboard.Dashboard{Repositories: repos, Search: search.NewIndex(repos, "", "")}
+	request, err := http.NewRequest("GET", "/search?q=test", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeSearchJson(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+	}
+	var hits []search.Hit
+	if err := json.Unmarshal(rw.Body.Bytes(), &hits); err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Line != mockTodo || len(hits[0].Branches) != 1 ||
+		hits[0].Branches[0] != "master" {
+		t.Errorf("Expected a single hit for %v in master, but saw %v", mockTodo, hits)
+	}
+
+	request, err = http.NewRequest("GET", "/search?q=", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw = httptest.NewRecorder()
+	db.ServeSearchJson(rw, request)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
+	}
+}
+
+// Check the given JSON value against a schema from the OpenAPI document. Only the
+// subset of JSON Schema used by the document is supported.
+func checkSchema(t *testing.T, doc map[string]interface{}, schema map[string]interface{},
+	value interface{}, location string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/components/schemas/")
+		components := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		checkSchema(t, doc, components[name].(map[string]interface{}), value, location)
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || allowed == value
+		}
+		if !found {
+			t.Errorf("%s: %v is not one of %v", location, value, enum)
+		}
+	}
+	switch schema["type"] {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			t.Errorf("%s: expected an object, but saw %v", location, value)
+			return
+		}
+		for _, required := range schema["required"].([]interface{}) {
+			if _, ok := object[required.(string)]; !ok {
+				t.Errorf("%s: missing the required property %s", location, required)
+			}
+		}
+		properties := schema["properties"].(map[string]interface{})
+		for name, propertyValue := range object {
+			property, ok := properties[name]
+			if !ok {
+				t.Errorf("%s: undocumented property %s", location, name)
+				continue
+			}
+			checkSchema(t, doc, property.(map[string]interface{}), propertyValue, location+"."+name)
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			t.Errorf("%s: expected an array, but saw %v", location, value)
+			return
+		}
+		for i, item := range array {
+			checkSchema(t, doc, schema["items"].(map[string]interface{}), item,
+				location+"["+strconv.Itoa(i)+"]")
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			t.Errorf("%s: expected a string, but saw %v", location, value)
+		}
+	case "integer":
+		if number, ok := value.(float64); !ok || number != float64(int64(number)) {
+			t.Errorf("%s: expected an integer, but saw %v", location, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			t.Errorf("%s: expected a boolean, but saw %v", location, value)
+		}
+	}
+}
+
+// Check that a response from the API has the JSON content type and matches the given schema.
+func checkApiResponse(t *testing.T, doc map[string]interface{}, schema map[string]interface{},
+	rw *httptest.ResponseRecorder, location string) {
+	if contentType := rw.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("%s: expected a JSON content type, but saw %q", location, contentType)
+	}
+	var body interface{}
+	if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil {
+		t.Errorf("%s: invalid JSON %q: %v", location, rw.Body.String(), err)
+		return
+	}
+	checkSchema(t, doc, schema, body, location)
+}
+
+func newApiMux(t *testing.T) (*http.ServeMux, map[string]interface{}, func()) {
+	fake := issuestest.NewFakeGitHub()
+	now := time.Now().Unix()
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases:       []repo.Alias{{Branch: "master", Revision: TestRevision, Type: repo.BranchRef}},
+		RevisionTodos: map[string][]repo.Line{TestRevision: {mockTodo, overdueTodo}},
+		Files:         map[string]string{TestFileName: "package main\n// TODO: test this\n"},
+		Metadata: map[string]repo.RevisionMetadata{
+			TestRevision: {Revision: TestRevision, Timestamp: now, AuthorName: "Alice"},
+		},
+	}
+	repos := map[string]*repo.Repository{repository.GetRepoId(): &repository}
+	mapping, err := issues.LoadMapping("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := dashboard.Dashboard{
+		Repositories: repos,
+		Issues: &issues.Linker{
+			Providers: []issues.Provider{fake.Provider()},
+			Mapping:   mapping,
+		},
+		Search: search.NewIndex(repos, "", ""),
+	}
+	mux := http.NewServeMux()
+	for path, handler := range db.ApiHandlers() {
+		mux.HandleFunc(path, handler)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(dashboard.OpenApiDocument), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return mux, doc, fake.Close
+}
+
+func TestApiMatchesOpenApiDocument(t *testing.T) {
+	mux, doc, closeFake := newApiMux(t)
+	defer closeFake()
+	paramValues := map[string]string{
+		"repo":       "repoID",
+		"revision":   TestRevision,
+		"from":       TestRevision,
+		"to":         TestRevision,
+		"fileName":   TestFileName,
+		"lineNumber": "2",
+		"q":          "test",
+		"limit":      "1",
+
+		dashboard.RequestedWithHeader: "XMLHttpRequest",
+	}
+	paths := doc["paths"].(map[string]interface{})
+	for path, pathItem := range paths {
+		for method, operationValue := range pathItem.(map[string]interface{}) {
+			operation := operationValue.(map[string]interface{})
+			params := url.Values{}
+			headers := http.Header{}
+			for _, paramValue := range operation["parameters"].([]interface{}) {
+				name := paramValue.(map[string]interface{})["name"].(string)
+				if value, ok := paramValue.(map[string]interface{})["required"].(bool); ok && value ||
+					name == "repo" || name == "limit" {
+					if paramValue.(map[string]interface{})["in"] == "header" {
+						headers.Set(name, paramValues[name])
+					} else {
+						params.Set(name, paramValues[name])
+					}
+				}
+			}
+			location := strings.ToUpper(method) + " " + path
+			request, err := http.NewRequest(strings.ToUpper(method),
+				strings.TrimSuffix(dashboard.ApiPrefix, "/")+path+"?"+params.Encode(), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			request.Header = headers
+			rw := httptest.NewRecorder()
+			mux.ServeHTTP(rw, request)
+			if rw.Code != http.StatusOK {
+				t.Errorf("%s: expected a response code of %d, but saw %d, with a body of '%s'",
+					location, http.StatusOK, rw.Code, rw.Body.String())
+				continue
+			}
+			schema := operation["responses"].(map[string]interface{})["200"].(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"].(map[string]interface{})
+			checkApiResponse(t, doc, schema, rw, location)
+		}
+	}
+
+	request, err := http.NewRequest("GET", dashboard.ApiPrefix+"openapi.json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	mux.ServeHTTP(rw, request)
+	if rw.Code != http.StatusOK || rw.Body.String() != dashboard.OpenApiDocument {
+		t.Errorf("Expected the OpenAPI document to be served, but saw %d", rw.Code)
+	}
+}
+
+func TestApiErrors(t *testing.T) {
+	mux, doc, closeFake := newApiMux(t)
+	defer closeFake()
+	errorSchema := map[string]interface{}{"$ref": "#/components/schemas/Error"}
+	for _, test := range []struct {
+		method, path string
+		status       int
+	}{
+		{"GET", "branches?repo=unknown", http.StatusNotFound},
+		{"GET", "todos?repo=repoID", http.StatusBadRequest},
+		{"GET", "todos?repo=repoID&revision=" + TestRevision + "&sort=sideways", http.StatusBadRequest},
+		{"GET", "todo?repo=repoID&revision=" + TestRevision + "&fileName=missing&lineNumber=1",
+			http.StatusBadRequest},
+		{"GET", "fileIssue", http.StatusMethodNotAllowed},
+		{"POST", "fileIssue?repo=repoID&revision=" + TestRevision + "&fileName=" + TestFileName + "&lineNumber=2",
+			http.StatusForbidden},
+		{"GET", "search", http.StatusBadRequest},
+		{"GET", "nothing", http.StatusNotFound},
+	} {
+		location := test.method + " " + test.path
+		request, err := http.NewRequest(test.method, dashboard.ApiPrefix+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, request)
+		if rw.Code != test.status {
+			t.Errorf("%s: expected a response code of %d, but saw %d", location, test.status, rw.Code)
+		}
+		checkApiResponse(t, doc, errorSchema, rw, location)
+		var body dashboard.ApiErrorResponse
+		if err := json.Unmarshal(rw.Body.Bytes(), &body); err != nil || body.Error.Status != rw.Code ||
+			body.Error.Message == "" {
+			t.Errorf("%s: expected an error envelope, but saw '%s'", location, rw.Body.String())
+		}
+	}
+}
+
+func TestCacheByRevision(t *testing.T) {
+	const fullRevision = "0123456789abcdef0123456789abcdef01234567"
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{fullRevision: {mockTodo}, TestRevision: {mockTodo}},
+	}
+	db := dashboard.Dashboard{Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository}}
+	calls := 0
+	handler := dashboard.Compress(db.CacheByRevision(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		db.ServeRevisionJson(w, r)
+	}))
+	serve := func(revision string, header http.Header) *httptest.ResponseRecorder {
+		request, err := http.NewRequest("GET", "/revision?repo=repoID&revision="+revision, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header = header
+		rw := httptest.NewRecorder()
+		handler(rw, request)
+		return rw
+	}
+
+	rw := serve(fullRevision, http.Header{})
+	etag := rw.Header().Get("ETag")
+	if rw.Code != http.StatusOK || etag == "" ||
+		!strings.Contains(rw.Header().Get("Cache-Control"), "immutable") {
+		t.Fatalf("Expected an immutable response with an ETag, but saw %d with headers %v", rw.Code, rw.Header())
+	}
+	rw = serve(fullRevision, http.Header{"If-None-Match": {etag}})
+	if rw.Code != http.StatusNotModified || calls != 1 {
+		t.Errorf("Expected a 304 response without calling the handler, but saw %d after %d calls",
+			rw.Code, calls)
+	}
+
+	rw = serve(fullRevision, http.Header{"Accept-Encoding": {"gzip, deflate"}})
+	gzipEtag := rw.Header().Get("ETag")
+	if rw.Header().Get("Content-Encoding") != "gzip" || gzipEtag == etag || gzipEtag == "" {
+		t.Fatalf("Expected a compressed response with its own ETag, but saw headers %v", rw.Header())
+	}
+	reader, err := gzip.NewReader(rw.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var returnedTodos []repo.Line
+	if err := json.NewDecoder(reader).Decode(&returnedTodos); err != nil {
+		t.Fatal(err)
+	}
+	if len(returnedTodos) != 1 || returnedTodos[0] != mockTodo {
+		t.Errorf("Expected a singleton slice of %v, but saw %v", mockTodo, returnedTodos)
+	}
+	rw = serve(fullRevision, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {gzipEtag}})
+	if rw.Code != http.StatusNotModified || rw.Header().Get("ETag") != gzipEtag {
+		t.Errorf("Expected a 304 response for the compressed ETag, but saw %d with headers %v",
+			rw.Code, rw.Header())
+	}
+
+	// Revisions that are not full hashes could refer to different commits over time.
+	rw = serve(TestRevision, http.Header{})
+	if rw.Code != http.StatusOK || rw.Header().Get("ETag") != "" || rw.Header().Get("Cache-Control") != "" {
+		t.Errorf("Expected an uncached response, but saw %d with headers %v", rw.Code, rw.Header())
+	}
+
+	// Neither could the full hashes of repositories whose revisions can change.
+	repository = repotest.MockRepository{
+		RevisionTodos:    map[string][]repo.Line{fullRevision: {mockTodo}},
+		MutableRevisions: true,
+	}
+	rw = serve(fullRevision, http.Header{})
+	if rw.Code != http.StatusOK || rw.Header().Get("ETag") != "" || rw.Header().Get("Cache-Control") != "" {
+		t.Errorf("Expected an uncached response for mutable revisions, but saw %d with headers %v",
+			rw.Code, rw.Header())
+	}
+}
+
+func TestServeRevisionStream(t *testing.T) {
+	otherTodo := repo.Line{
+		Revision:   repo.Revision(TestRevision),
+		FileName:   "otherFile",
+		LineNumber: 1,
+		Contents:   "FIXME: other",
+	}
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{TestRevision: {mockTodo, otherTodo}},
+	}
+	db := dashboard.Dashboard{Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository}}
+	request, err := http.NewRequest("GET",
+		"/revisionStream?repo=repoID&category=FIXME&revision="+TestRevision, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeRevisionStream(rw, request)
+	if rw.Code != http.StatusOK || rw.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, but saw %d with headers %v", rw.Code, rw.Header())
+	}
+
+	var names []string
+	var events []repo.ScanProgress
+	for _, block := range strings.Split(strings.TrimSpace(rw.Body.String()), "\n\n") {
+		lines := strings.Split(block, "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "event: ") || !strings.HasPrefix(lines[1], "data: ") {
+			t.Fatalf("Malformed event '%s'", block)
+		}
+		var progress repo.ScanProgress
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(lines[1], "data: ")), &progress); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, strings.TrimPrefix(lines[0], "event: "))
+		events = append(events, progress)
+	}
+	if len(events) != 3 || names[0] != "progress" || names[1] != "progress" || names[2] != "done" {
+		t.Fatalf("Expected two progress events and a done event, but saw %v", names)
+	}
+	if len(events[0].Todos) != 0 || events[0].FileName != TestFileName || events[0].FilesDone != 1 {
+		t.Errorf("Expected the first file's TODO to be filtered out, but saw %v", events[0])
+	}
+	if len(events[1].Todos) != 1 || events[1].Todos[0] != otherTodo || events[1].FilesDone != 2 ||
+		events[1].FilesTotal != 2 {
+		t.Errorf("Expected the second file's TODO, but saw %v", events[1])
+	}
+}
+
+// A mock repository that streams the TODOs of a revision in two files, waiting to be
+// released after the first one, and counts the scans.
+type slowStreamRepository struct {
+	repotest.MockRepository
+	scans   *int32
+	release chan bool
+}
+
+func (repository slowStreamRepository) StreamRevisionTodos(
+	revision repo.Revision, todoRegex, excludePaths string) <-chan repo.ScanProgress {
+	atomic.AddInt32(repository.scans, 1)
+	progress := make(chan repo.ScanProgress)
+	go func() {
+		defer close(progress)
+		progress <- repo.ScanProgress{FileName: "first", FilesDone: 1, FilesTotal: 2}
+		<-repository.release
+		progress <- repo.ScanProgress{FileName: "second", FilesDone: 2, FilesTotal: 2}
+	}()
+	return progress
+}
+
+func TestServeRevisionStreamSharesScans(t *testing.T) {
+	var scans int32
+	slow := slowStreamRepository{
+		MockRepository: repotest.MockRepository{
+			RevisionTodos: map[string][]repo.Line{"sharedRevision": {}},
+		},
+		scans:   &scans,
+		release: make(chan bool),
+	}
+	var repository repo.Repository = slow
+	db := dashboard.Dashboard{Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository}}
+	server := httptest.NewServer(http.HandlerFunc(db.ServeRevisionStream))
+	defer server.Close()
+
+	// Open a stream, and read the names of its events as they arrive.
+	openStream := func() <-chan string {
+		response, err := http.Get(server.URL + "/revisionStream?repo=repoID&revision=sharedRevision")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make(chan string, 10)
+		go func() {
+			defer response.Body.Close()
+			defer close(names)
+			scanner := bufio.NewScanner(response.Body)
+			for scanner.Scan() {
+				if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok {
+					names <- name
+				}
+			}
+		}()
+		return names
+	}
+	first := openStream()
+	if name := <-first; name != "progress" {
+		t.Fatalf("Expected a progress event, but saw %q", name)
+	}
+	// The second stream should be sent the progress made so far, without a new scan.
+	second := openStream()
+	if name := <-second; name != "progress" {
+		t.Fatalf("Expected the second stream to start with the progress so far, but saw %q", name)
+	}
+	close(slow.release)
+	for i, names := range []<-chan string{first, second} {
+		var rest []string
+		for name := range names {
+			rest = append(rest, name)
+		}
+		if len(rest) != 2 || rest[0] != "progress" || rest[1] != "done" {
+			t.Errorf("Expected stream %d to finish with a progress and a done event, but saw %v", i, rest)
+		}
+	}
+	if scans := atomic.LoadInt32(&scans); scans != 1 {
+		t.Errorf("Expected the streams to share a single scan, but saw %d", scans)
+	}
+}
+
+func TestServeCompareJson(t *testing.T) {
+	kept := repo.Line{Revision: "v1", FileName: "kept.go", LineNumber: 1, Contents: "TODO: keep"}
+	fixed := repo.Line{Revision: "v1", FileName: "fixed.go", LineNumber: 2, Contents: "TODO: fix"}
+	added := repo.Line{Revision: "v2", FileName: "added.go", LineNumber: 3, Contents: "TODO: add"}
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{
+			"v1": {kept, fixed},
+			"v2": {kept, added},
+		},
+	}
+	db := dashboard.Dashboard{
+		Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository},
+	}
+	request, err := http.NewRequest("GET", "/compare?from=v1&to=v2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeCompareJson(rw, request)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusOK, rw.Code, rw.Body.String())
+	}
+	var diff repo.TodoDiff
+	if err := json.Unmarshal(rw.Body.Bytes(), &diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != added ||
+		len(diff.Removed) != 1 || diff.Removed[0] != fixed || len(diff.Moved) != 0 {
+		t.Errorf("Expected %v to be added and %v to be removed, but saw %v", added, fixed, diff)
+	}
+
+	request, err = http.NewRequest("GET", "/compare?from=v1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw = httptest.NewRecorder()
+	db.ServeCompareJson(rw, request)
+	if rw.Code != http.StatusBadRequest {
+		t.Errorf("Expected a response code of %d, but saw %d", http.StatusBadRequest, rw.Code)
+	}
+}
+
+func TestResolveRevisions(t *testing.T) {
+	fullRevision := strings.Repeat("ab", 20)
+	var repository repo.Repository = repotest.MockRepository{
+		RevisionTodos: map[string][]repo.Line{fullRevision: {mockTodo}},
+		Refs:          map[string]repo.Revision{"master": repo.Revision(fullRevision)},
+	}
+	db := dashboard.Dashboard{
+		Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository},
+	}
+	handler := db.ResolveRevisions(db.ServeRevisionJson)
+	for _, test := range []struct {
+		revision string
+		status   int
+		location string
+	}{
+		{"master", http.StatusTemporaryRedirect, "/revision?q=test&revision=" + fullRevision},
+		{fullRevision, http.StatusOK, ""},
+		{"unknown", http.StatusBadRequest, ""},
+	} {
+		request, err := http.NewRequest("GET", "/revision?q=test&revision="+test.revision, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		handler(rw, request)
+		if rw.Code != test.status || rw.Header().Get("Location") != test.location {
+			t.Errorf("%s: expected a response code of %d redirecting to '%s', but saw %d redirecting to '%s'",
+				test.revision, test.status, test.location, rw.Code, rw.Header().Get("Location"))
+		}
+	}
+}
+
+func TestServeAliasesJsonUncommitted(t *testing.T) {
+	worktree := repo.Alias{Branch: "WORKTREE", Revision: repo.WorktreeRevision,
+		Type: repo.UncommittedRef, TodoCount: -1}
+	var repository repo.Repository = repotest.MockRepository{
+		Aliases:     []repo.Alias{mockAlias},
+		Uncommitted: []repo.Alias{worktree},
+	}
+	db := dashboard.Dashboard{
+		Repositories: map[string]*repo.Repository{repository.GetRepoId(): &repository},
+	}
+	request, err := http.NewRequest("GET", "/aliases", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeAliasesJson(rw, request)
+	var returnedAliases []repo.Alias
+	if err := json.Unmarshal(rw.Body.Bytes(), &returnedAliases); err != nil {
+		t.Fatal(err)
+	}
+	if len(returnedAliases) != 2 || returnedAliases[0] != mockAlias || returnedAliases[1] != worktree {
+		t.Errorf("Expected the branches followed by %v, but saw %v", worktree, returnedAliases)
+	}
+}
+
+func TestServeReposJsonSubmodules(t *testing.T) {
+	pinnedRevision := repo.Revision("pinnedRevision")
+	var superproject repo.Repository = repotest.MockRepository{
+		Refs:       map[string]repo.Revision{"HEAD": TestRevision},
+		Submodules: []repo.Submodule{{Path: "third_party/lib", Revision: pinnedRevision}},
+		Path:       filepath.Join("src", "project"),
+	}
+	var submodule repo.Repository = repotest.MockRepository{
+		Path: filepath.Join("src", "project", "third_party", "lib"),
+	}
+	db := dashboard.Dashboard{
+		Repositories: map[string]*repo.Repository{"super": &superproject, "sub": &submodule},
+	}
+	request, err := http.NewRequest("GET", "/repos", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	db.ServeReposJson(rw, request)
+	var returnedRepos []struct {
+		Path         string
+		RepoId       string
+		Superproject *struct {
+			RepoId   string
+			Path     string
+			Revision repo.Revision
+		}
+	}
+	if err := json.Unmarshal(rw.Body.Bytes(), &returnedRepos); err != nil {
+		t.Fatal(err)
+	}
+	if len(returnedRepos) != 2 || returnedRepos[0].RepoId != "super" || returnedRepos[0].Superproject != nil {
+		t.Fatalf("Expected the superproject to be listed first without a link, but saw %s", rw.Body.String())
+	}
+	link := returnedRepos[1].Superproject
+	if returnedRepos[1].RepoId != "sub" || link == nil || link.RepoId != "super" ||
+		link.Path != "third_party/lib" || link.Revision != pinnedRevision {
+		t.Errorf("Expected the submodule to be linked to the superproject, but saw %s", rw.Body.String())
+	}
+
+	// The submodules are only read again once the superproject checks out another revision.
+	readLink := func() string {
+		rw := httptest.NewRecorder()
+		db.ServeReposJson(rw, request)
+		returnedRepos = nil
+		if err := json.Unmarshal(rw.Body.Bytes(), &returnedRepos); err != nil {
+			t.Fatal(err)
+		}
+		if len(returnedRepos) != 2 || returnedRepos[1].Superproject == nil {
+			return ""
+		}
+		return returnedRepos[1].Superproject.Path
+	}
+	superproject = repotest.MockRepository{
+		Refs: map[string]repo.Revision{"HEAD": TestRevision},
+		Path: filepath.Join("src", "project"),
+	}
+	if path := readLink(); path != "third_party/lib" {
+		t.Errorf("Expected the submodules of the same HEAD to be reused, but saw the link %q", path)
+	}
+	superproject = repotest.MockRepository{
+		Refs: map[string]repo.Revision{"HEAD": pinnedRevision},
+		Path: filepath.Join("src", "project"),
+	}
+	if path := readLink(); path != "" {
+		t.Errorf("Expected the submodules of the new HEAD to be read, but saw the link %q", path)
+	}
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	atomNamespace = "http://www.w3.org/2005/Atom"
+	atomMediaType = "application/atom+xml"
+	// Number of first-parent commits examined when building a feed. Every one of them
+	// that was not scanned before is scanned for the feed, so this is kept small.
+	defaultFeedCommits = 10
+	maxFeedCommits     = 50
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name  string `xml:"name"`
+	Email string `xml:"email,omitempty"`
+}
+
+type atomEntry struct {
+	Id      string     `xml:"id"`
+	Title   string     `xml:"title"`
+	Updated string     `xml:"updated"`
+	Author  atomPerson `xml:"author"`
+	Link    atomLink   `xml:"link"`
+	Content string     `xml:"content"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+func atomTimestamp(timestamp int64) string {
+	return time.Unix(timestamp, 0).UTC().Format(time.RFC3339)
+}
+
+func shortRevision(revision repo.Revision) string {
+	if len(revision) > 8 {
+		return string(revision[:8])
+	}
+	return string(revision)
+}
+
+// Get the scheme and host that the given request was sent to, e.g. "http://localhost:8080".
+func requestBaseUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// Build the absolute URL of the details page for the given TODO. The TODO's
+// location is relative to the revision that last modified it, so that is the
+// revision used in the URL.
+func todoDetailsUrl(r *http.Request, repoId string, todo repo.Line) string {
+	params := fmt.Sprintf("repo=%s&revision=%s&fn=%s&ln=%d",
+		url.QueryEscape(repoId), url.QueryEscape(string(todo.Revision)),
+		url.QueryEscape(todo.FileName), todo.LineNumber)
+	return requestBaseUrl(r) + "/ui/todo_details.html#?" + params
+}
+
+func (db Dashboard) readBranchParam(r *http.Request, repository repo.Repository) (repo.Alias, error) {
+	branchParam := r.URL.Query().Get("branch")
+	if branchParam == "" {
+		return repo.Alias{}, errors.New("Missing the branch parameter")
+	}
+	for _, alias := range repository.ListBranches() {
+		if alias.Branch == branchParam {
+			return alias, nil
+		}
+	}
+	return repo.Alias{}, errors.New(fmt.Sprintf("Unknown branch '%s'", branchParam))
+}
+
+// Build the feed entries for the TODOs added and removed by a single commit.
+func (db Dashboard) commitFeedEntries(
+	r *http.Request, repository repo.Repository, parent, revision repo.Revision) []atomEntry {
+	var parentTodos []repo.Line
+	if parent != "" {
+		parentTodos = repository.LoadRevisionTodos(parent, db.TodoRegex, db.ExcludePaths)
+	}
+	todos := repository.LoadRevisionTodos(revision, db.TodoRegex, db.ExcludePaths)
+	diff := repo.DiffTodos(parentTodos, todos)
+	if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+		return nil
+	}
+	metadata := repository.ReadRevisionMetadata(revision)
+	author := atomPerson{Name: metadata.AuthorName, Email: metadata.AuthorEmail}
+	repoId := repository.GetRepoId()
+	entries := make([]atomEntry, 0)
+	for _, todo := range diff.Added {
+		entries = append(entries, atomEntry{
+			Id: fmt.Sprintf("tag:todo-tracks,2014:%s/%s/added/%s:%d",
+				repoId, revision, todo.FileName, todo.LineNumber),
+			Title: fmt.Sprintf("TODO added by %s in commit %s",
+				metadata.AuthorName, shortRevision(revision)),
+			Updated: atomTimestamp(metadata.Timestamp),
+			Author:  author,
+			Link:    atomLink{Href: todoDetailsUrl(r, repoId, todo)},
+			Content: fmt.Sprintf("%s:%d: %s", todo.FileName, todo.LineNumber, todo.Contents),
+		})
+	}
+	for _, todo := range diff.Removed {
+		entries = append(entries, atomEntry{
+			Id: fmt.Sprintf("tag:todo-tracks,2014:%s/%s/removed/%s:%d",
+				repoId, revision, todo.FileName, todo.LineNumber),
+			Title: fmt.Sprintf("TODO resolved by %s in commit %s",
+				metadata.AuthorName, shortRevision(revision)),
+			Updated: atomTimestamp(metadata.Timestamp),
+			Author:  author,
+			Link:    atomLink{Href: todoDetailsUrl(r, repoId, todo)},
+			Content: fmt.Sprintf("%s:%d: %s", todo.FileName, todo.LineNumber, todo.Contents),
+		})
+	}
+	return entries
+}
+
+// Serve an Atom feed of the TODOs added and resolved in the recent history of a branch.
+// The repo and branch are taken from the URL parameters of the request, and the number
+// of first-parent commits to examine can be set with the optional "commits" parameter.
+func (db Dashboard) ServeFeed(w http.ResponseWriter, r *http.Request) {
+	repositoryPtr, err := db.readRepoParam(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Error loading repo: \"%s\"", err)
+		return
+	}
+	repository := *repositoryPtr
+	alias, err := db.readBranchParam(r, repository)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	commits := defaultFeedCommits
+	if commitsParam := r.URL.Query().Get("commits"); commitsParam != "" {
+		commits, err = strconv.Atoi(commitsParam)
+		if err != nil || commits < 1 || commits > maxFeedCommits {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid value for the commits parameter: %s", commitsParam)
+			return
+		}
+	}
+
+	// Read one extra commit so that the oldest reported commit can be compared to its parent.
+	history := repository.ReadFirstParentHistory(alias.Revision, commits+1)
+	entries := make([]atomEntry, 0)
+	for i, revision := range history {
+		var parent repo.Revision
+		if i+1 < len(history) {
+			parent = history[i+1]
+		} else if len(history) > commits {
+			break
+		}
+		entries = append(entries, db.commitFeedEntries(r, repository, parent, revision)...)
+	}
+	feed := atomFeed{
+		Xmlns: atomNamespace,
+		Id: fmt.Sprintf("tag:todo-tracks,2014:%s/%s",
+			repository.GetRepoId(), alias.Branch),
+		Title: fmt.Sprintf("TODOs in %s of %s", alias.Branch, repository.GetRepoPath()),
+		Updated: atomTimestamp(
+			repository.ReadRevisionMetadata(alias.Revision).Timestamp),
+		Link:    atomLink{Href: requestBaseUrl(r) + r.URL.RequestURI(), Rel: "self"},
+		Entries: entries,
+	}
+	feedXml, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", atomMediaType)
+	w.Write([]byte(xml.Header))
+	w.Write(feedXml)
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	// Response header holding the cursor for the next page of TODOs, if there is one.
+	NextCursorHeader = "X-Next-Cursor"
+
+	maxPageSize  = 1000
+	cursorPrefix = "offset:"
+)
+
+// The orders in which the TODOs of a revision can be sorted.
+const (
+	sortByPathOrder   = "path"
+	sortByOldestOrder = "oldest"
+	sortByNewestOrder = "newest"
+	sortByAuthorOrder = "author"
+)
+
+// The filters, sort order, and page requested for the TODOs of a revision.
+type todoQuery struct {
+	// Either a prefix of the TODO's path, or a glob matched against the whole path.
+	path string
+	// A case-insensitive substring of the name or email of the TODO's author.
+	author   string
+	owner    string
+	category string
+	// A case-insensitive substring of the TODO's contents.
+	text      string
+	textRegex *regexp.Regexp
+	// The age range of the TODOs, in days. Negative values mean unbounded.
+	minAgeDays int
+	maxAgeDays int
+	overdue    bool
+	order      string
+	// The maximum number of TODOs to return, or 0 for all of them.
+	limit  int
+	offset int
+}
+
+func parseNonNegativeParam(query url.Values, name string, defaultValue int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid %s \"%s\"", name, value))
+	}
+	return parsed, nil
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(decoded), cursorPrefix) {
+		offset, err := strconv.Atoi(strings.TrimPrefix(string(decoded), cursorPrefix))
+		if err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("Invalid cursor \"%s\"", cursor))
+}
+
+// Parse the TODO query from the given URL parameters:
+//
+//	path:       a path prefix, or a glob (e.g. "ui/*.js") if it contains any of "*?["
+//	author:     a substring of the author's name or email
+//	owner:      the owner named in the TODO, e.g. "alice" for "TODO(alice)"
+//	category:   the TODO's marker, e.g. "FIXME"
+//	q:          a substring of the TODO's contents
+//	regex:      a regular expression, using the re2 syntax, matched against the contents
+//	minAgeDays: the minimum age of the TODO, in days
+//	maxAgeDays: the maximum age of the TODO, in days
+//	overdue:    "true" to only include TODOs whose due date has passed
+//	sort:       one of "path" (the default), "oldest", "newest", or "author"
+//	limit:      the maximum number of TODOs per page
+//	cursor:     the cursor for the page, as returned in the X-Next-Cursor header
+func parseTodoQuery(query url.Values) (todoQuery, error) {
+	q := todoQuery{
+		path:     query.Get("path"),
+		author:   strings.ToLower(query.Get("author")),
+		owner:    query.Get("owner"),
+		category: strings.ToUpper(query.Get("category")),
+		text:     strings.ToLower(query.Get("q")),
+		overdue:  query.Get("overdue") == "true",
+		order:    query.Get("sort"),
+	}
+	if strings.ContainsAny(q.path, "*?[") {
+		if _, err := path.Match(q.path, ""); err != nil {
+			return q, errors.New(fmt.Sprintf("Invalid path glob \"%s\"", q.path))
+		}
+	}
+	if regex := query.Get("regex"); regex != "" {
+		var err error
+		if q.textRegex, err = regexp.Compile(regex); err != nil {
+			return q, errors.New(fmt.Sprintf("Invalid regex \"%s\": %s", regex, err))
+		}
+	}
+	var err error
+	if q.minAgeDays, err = parseNonNegativeParam(query, "minAgeDays", -1); err != nil {
+		return q, err
+	}
+	if q.maxAgeDays, err = parseNonNegativeParam(query, "maxAgeDays", -1); err != nil {
+		return q, err
+	}
+	switch q.order {
+	case "":
+		q.order = sortByPathOrder
+	case sortByPathOrder, sortByOldestOrder, sortByNewestOrder, sortByAuthorOrder:
+	default:
+		return q, errors.New(fmt.Sprintf("Invalid sort order \"%s\"", q.order))
+	}
+	if q.limit, err = parseNonNegativeParam(query, "limit", 0); err != nil {
+		return q, err
+	}
+	if q.limit > maxPageSize {
+		q.limit = maxPageSize
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if q.offset, err = decodeCursor(cursor); err != nil {
+			return q, err
+		}
+		if q.limit == 0 {
+			q.limit = maxPageSize
+		}
+	}
+	return q, nil
+}
+
+// Whether or not the query needs the metadata of the revisions that last modified the TODOs.
+func (q todoQuery) needsMetadata() bool {
+	return q.author != "" || q.minAgeDays >= 0 || q.maxAgeDays >= 0 || q.order != sortByPathOrder
+}
+
+func (q todoQuery) matchesPath(fileName string) bool {
+	if strings.ContainsAny(q.path, "*?[") {
+		matched, _ := path.Match(q.path, fileName)
+		return matched
+	}
+	return strings.HasPrefix(fileName, q.path)
+}
+
+func (q todoQuery) matchesContents(contents string) bool {
+	if q.owner != "" && repo.TodoOwner(contents) != q.owner {
+		return false
+	}
+	if q.category != "" && repo.TodoCategory(contents) != q.category {
+		return false
+	}
+	if q.text != "" && !strings.Contains(strings.ToLower(contents), q.text) {
+		return false
+	}
+	return q.textRegex == nil || q.textRegex.MatchString(contents)
+}
+
+func (q todoQuery) matchesMetadata(metadata repo.RevisionMetadata, now time.Time) bool {
+	if q.author != "" &&
+		!strings.Contains(strings.ToLower(metadata.AuthorName), q.author) &&
+		!strings.Contains(strings.ToLower(metadata.AuthorEmail), q.author) {
+		return false
+	}
+	ageDays := int(now.Sub(time.Unix(metadata.Timestamp, 0)).Hours() / 24)
+	if q.minAgeDays >= 0 && ageDays < q.minAgeDays {
+		return false
+	}
+	return q.maxAgeDays < 0 || ageDays <= q.maxAgeDays
+}
+
+// Report whether the given TODO passes the query's filters. The metadata read for the
+// TODO is added to the given map, so that it can be reused for other TODOs.
+func (q todoQuery) matches(repository repo.Repository, todo repo.Line,
+	metadata map[repo.Revision]repo.RevisionMetadata, now time.Time) bool {
+	if q.overdue && !repo.IsTodoOverdue(todo.Contents, now) {
+		return false
+	}
+	if !q.matchesPath(todo.FileName) || !q.matchesContents(todo.Contents) {
+		return false
+	}
+	if !q.needsMetadata() {
+		return true
+	}
+	if _, ok := metadata[todo.Revision]; !ok {
+		metadata[todo.Revision] = repository.ReadRevisionMetadata(todo.Revision)
+	}
+	return q.matchesMetadata(metadata[todo.Revision], now)
+}
+
+// Filter and sort the given TODOs, and return the requested page of them,
+// along with the cursor for the next page, or "" if this is the last page.
+func (q todoQuery) apply(repository repo.Repository, todos []repo.Line, now time.Time) ([]repo.Line, string) {
+	metadata := make(map[repo.Revision]repo.RevisionMetadata)
+	matched := make([]repo.Line, 0)
+	for _, todo := range todos {
+		if q.matches(repository, todo, metadata, now) {
+			matched = append(matched, todo)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		switch q.order {
+		case sortByOldestOrder, sortByNewestOrder:
+			aTime, bTime := metadata[a.Revision].Timestamp, metadata[b.Revision].Timestamp
+			if aTime != bTime {
+				return (aTime < bTime) == (q.order == sortByOldestOrder)
+			}
+		case sortByAuthorOrder:
+			aAuthor, bAuthor := metadata[a.Revision].AuthorName, metadata[b.Revision].AuthorName
+			if aAuthor != bAuthor {
+				return aAuthor < bAuthor
+			}
+		}
+		if a.FileName != b.FileName {
+			return a.FileName < b.FileName
+		}
+		return a.LineNumber < b.LineNumber
+	})
+
+	if q.limit == 0 {
+		return matched, ""
+	}
+	if q.offset >= len(matched) {
+		return make([]repo.Line, 0), ""
+	}
+	end := q.offset + q.limit
+	if end >= len(matched) {
+		return matched[q.offset:], ""
+	}
+	return matched[q.offset:end], encodeCursor(end)
+}
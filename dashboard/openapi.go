@@ -0,0 +1,1102 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+// The OpenAPI document describing the versioned JSON API, served from /api/v1/openapi.json.
+// Every path is relative to the API prefix. This must be kept in sync with the API handlers;
+// the contract tests check the handlers' responses against it.
+const OpenApiDocument = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "TODO Tracks",
+    "version": "1"
+  },
+  "servers": [
+    {
+      "url": "/api/v1"
+    }
+  ],
+  "paths": {
+    "/repos": {
+      "get": {
+        "summary": "List the repos.",
+        "parameters": [],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "type": "array",
+                      "items": {
+                        "$ref": "#/components/schemas/Repo"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/branches": {
+      "get": {
+        "summary": "List the branches and other tracked refs of a repo, followed by the pseudo-revisions for its uncommitted changes.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "type": "array",
+                      "items": {
+                        "$ref": "#/components/schemas/Alias"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/todos": {
+      "get": {
+        "summary": "List the TODOs in a revision.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "revision",
+            "in": "query",
+            "required": true,
+            "description": "The revision, as a full commit hash. Requests naming it by a branch, a tag, an abbreviated hash, or an expression such as HEAD~3 are redirected to the full hash.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "path",
+            "in": "query",
+            "required": false,
+            "description": "A path prefix, or a glob such as \"ui/*.js\".",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "author",
+            "in": "query",
+            "required": false,
+            "description": "Part of the name or email of the TODO's author.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "owner",
+            "in": "query",
+            "required": false,
+            "description": "The owner named in the TODO.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "category",
+            "in": "query",
+            "required": false,
+            "description": "The TODO's marker, e.g. \"FIXME\".",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "q",
+            "in": "query",
+            "required": false,
+            "description": "A case-insensitive substring of the TODO's text.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "regex",
+            "in": "query",
+            "required": false,
+            "description": "A regular expression, using the re2 syntax, matched against the TODO's text.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "minAgeDays",
+            "in": "query",
+            "required": false,
+            "description": "The minimum age of the TODO, in days.",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "name": "maxAgeDays",
+            "in": "query",
+            "required": false,
+            "description": "The maximum age of the TODO, in days.",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "name": "overdue",
+            "in": "query",
+            "required": false,
+            "description": "Only include TODOs whose due date has passed.",
+            "schema": {
+              "type": "boolean"
+            }
+          },
+          {
+            "name": "sort",
+            "in": "query",
+            "required": false,
+            "description": "The sort order.",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "path",
+                "oldest",
+                "newest",
+                "author"
+              ]
+            }
+          },
+          {
+            "name": "limit",
+            "in": "query",
+            "required": false,
+            "description": "The maximum number of TODOs to return.",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "name": "cursor",
+            "in": "query",
+            "required": false,
+            "description": "The cursor for the page, from the nextCursor of the previous page.",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "type": "array",
+                      "items": {
+                        "$ref": "#/components/schemas/Todo"
+                      }
+                    },
+                    "nextCursor": {
+                      "type": "string"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/expired": {
+      "get": {
+        "summary": "List the expired TODOs in a revision.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "revision",
+            "in": "query",
+            "required": true,
+            "description": "The revision, as a full commit hash. Requests naming it by a branch, a tag, an abbreviated hash, or an expression such as HEAD~3 are redirected to the full hash.",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "type": "array",
+                      "items": {
+                        "$ref": "#/components/schemas/ExpiredTodo"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/todo": {
+      "get": {
+        "summary": "Get the details of a TODO.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "revision",
+            "in": "query",
+            "required": true,
+            "description": "The revision, as a full commit hash. Requests naming it by a branch, a tag, an abbreviated hash, or an expression such as HEAD~3 are redirected to the full hash.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "fileName",
+            "in": "query",
+            "required": true,
+            "description": "The path of the file containing the TODO.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "lineNumber",
+            "in": "query",
+            "required": true,
+            "description": "The line number of the TODO.",
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "$ref": "#/components/schemas/TodoDetails"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/todoStatus": {
+      "get": {
+        "summary": "Get the branches that a TODO is present in, or was removed from.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "revision",
+            "in": "query",
+            "required": true,
+            "description": "The revision, as a full commit hash. Requests naming it by a branch, a tag, an abbreviated hash, or an expression such as HEAD~3 are redirected to the full hash.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "fileName",
+            "in": "query",
+            "required": true,
+            "description": "The path of the file containing the TODO.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "lineNumber",
+            "in": "query",
+            "required": true,
+            "description": "The line number of the TODO.",
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "$ref": "#/components/schemas/TodoStatus"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/compare": {
+      "get": {
+        "summary": "Compare the TODOs of two revisions, such as two release tags.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "from",
+            "in": "query",
+            "required": true,
+            "description": "The old revision, named as for the revision parameter of /todos.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "to",
+            "in": "query",
+            "required": true,
+            "description": "The new revision, named as for the revision parameter of /todos.",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "$ref": "#/components/schemas/TodoDiff"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/fileIssue": {
+      "post": {
+        "summary": "File an issue for a TODO that does not reference one.",
+        "parameters": [
+          {
+            "name": "repo",
+            "in": "query",
+            "required": false,
+            "description": "The ID of the repo. May be omitted if there is only one repo.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "revision",
+            "in": "query",
+            "required": true,
+            "description": "The revision, as a full commit hash. Requests naming it by a branch, a tag, an abbreviated hash, or an expression such as HEAD~3 are redirected to the full hash.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "fileName",
+            "in": "query",
+            "required": true,
+            "description": "The path of the file containing the TODO.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "lineNumber",
+            "in": "query",
+            "required": true,
+            "description": "The line number of the TODO.",
+            "schema": {
+              "type": "integer"
+            }
+          },
+          {
+            "name": "X-Requested-With",
+            "in": "header",
+            "required": true,
+            "description": "Any value, such as XMLHttpRequest. Requests without it are rejected, so that other sites cannot file issues through a visitor's browser.",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "$ref": "#/components/schemas/IssueLink"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    },
+    "/search": {
+      "get": {
+        "summary": "Search the TODOs in every repo and branch.",
+        "parameters": [
+          {
+            "name": "q",
+            "in": "query",
+            "required": true,
+            "description": "The words to search for.",
+            "schema": {
+              "type": "string"
+            }
+          },
+          {
+            "name": "limit",
+            "in": "query",
+            "required": false,
+            "description": "The maximum number of hits to return.",
+            "schema": {
+              "type": "integer"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "required": [
+                    "data"
+                  ],
+                  "properties": {
+                    "data": {
+                      "type": "array",
+                      "items": {
+                        "$ref": "#/components/schemas/SearchHit"
+                      }
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "default": {
+            "description": "Error",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "$ref": "#/components/schemas/Error"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "required": [
+          "error"
+        ],
+        "properties": {
+          "error": {
+            "type": "object",
+            "required": [
+              "status",
+              "message"
+            ],
+            "properties": {
+              "status": {
+                "type": "integer"
+              },
+              "message": {
+                "type": "string"
+              }
+            }
+          }
+        }
+      },
+      "Repo": {
+        "type": "object",
+        "required": [
+          "Path",
+          "RepoId"
+        ],
+        "properties": {
+          "Path": {
+            "type": "string"
+          },
+          "RepoId": {
+            "type": "string"
+          },
+          "Superproject": {
+            "$ref": "#/components/schemas/Superproject"
+          }
+        }
+      },
+      "Superproject": {
+        "type": "object",
+        "description": "The repository that a submodule is checked out in, and the revision of the submodule that its checked-out revision pins.",
+        "required": [
+          "RepoId",
+          "Path",
+          "Revision"
+        ],
+        "properties": {
+          "RepoId": {
+            "type": "string"
+          },
+          "Path": {
+            "type": "string"
+          },
+          "Revision": {
+            "type": "string"
+          }
+        }
+      },
+      "Alias": {
+        "type": "object",
+        "required": [
+          "Branch",
+          "Revision",
+          "Type",
+          "LastModified",
+          "LastModifiedBy",
+          "Ahead",
+          "Behind",
+          "Base",
+          "TodoCount"
+        ],
+        "properties": {
+          "Branch": {
+            "type": "string"
+          },
+          "Revision": {
+            "type": "string"
+          },
+          "Type": {
+            "type": "string",
+            "enum": [
+              "branch",
+              "remote",
+              "tag",
+              "ref",
+              "uncommitted"
+            ]
+          },
+          "LastModified": {
+            "type": "integer",
+            "description": "The commit time of the branch's last commit, in seconds since the epoch."
+          },
+          "LastModifiedBy": {
+            "type": "string",
+            "description": "The author of the branch's last commit."
+          },
+          "Ahead": {
+            "type": "integer",
+            "description": "The number of commits in the branch that are not in the default branch."
+          },
+          "Behind": {
+            "type": "integer",
+            "description": "The number of commits in the default branch that are not in the branch."
+          },
+          "Base": {
+            "type": "string",
+            "description": "The default branch that Ahead and Behind are counted against, which is the branch that origin/HEAD points to, or else main or master, or else the checked-out branch. Empty if there is none."
+          },
+          "TodoCount": {
+            "type": "integer",
+            "description": "The number of TODOs in the branch, or -1 if it has not been scanned yet."
+          }
+        }
+      },
+      "Todo": {
+        "type": "object",
+        "required": [
+          "Revision",
+          "FileName",
+          "LineNumber",
+          "Contents"
+        ],
+        "properties": {
+          "Revision": {
+            "type": "string"
+          },
+          "FileName": {
+            "type": "string"
+          },
+          "LineNumber": {
+            "type": "integer"
+          },
+          "Contents": {
+            "type": "string"
+          }
+        }
+      },
+      "TodoId": {
+        "type": "object",
+        "required": [
+          "Revision",
+          "FileName",
+          "LineNumber"
+        ],
+        "properties": {
+          "Revision": {
+            "type": "string"
+          },
+          "FileName": {
+            "type": "string"
+          },
+          "LineNumber": {
+            "type": "integer"
+          }
+        }
+      },
+      "RevisionMetadata": {
+        "type": "object",
+        "required": [
+          "Revision",
+          "Timestamp",
+          "Subject",
+          "AuthorName",
+          "AuthorEmail"
+        ],
+        "properties": {
+          "Revision": {
+            "type": "string"
+          },
+          "Timestamp": {
+            "type": "integer"
+          },
+          "Subject": {
+            "type": "string"
+          },
+          "AuthorName": {
+            "type": "string"
+          },
+          "AuthorEmail": {
+            "type": "string"
+          }
+        }
+      },
+      "IssueLink": {
+        "type": "object",
+        "required": [
+          "Provider",
+          "Text",
+          "Id",
+          "Url"
+        ],
+        "properties": {
+          "Provider": {
+            "type": "string"
+          },
+          "Text": {
+            "type": "string"
+          },
+          "Id": {
+            "type": "string"
+          },
+          "Url": {
+            "type": "string"
+          },
+          "State": {
+            "type": "string",
+            "enum": [
+              "open",
+              "closed"
+            ]
+          }
+        }
+      },
+      "TodoDetails": {
+        "type": "object",
+        "required": [
+          "Id",
+          "RevisionMetadata",
+          "Context",
+          "Issues",
+          "CanFileIssue"
+        ],
+        "properties": {
+          "Id": {
+            "$ref": "#/components/schemas/TodoId"
+          },
+          "RevisionMetadata": {
+            "$ref": "#/components/schemas/RevisionMetadata"
+          },
+          "Context": {
+            "type": "string"
+          },
+          "Issues": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/IssueLink"
+            }
+          },
+          "CanFileIssue": {
+            "type": "boolean"
+          }
+        }
+      },
+      "TodoStatus": {
+        "type": "object",
+        "required": [
+          "BranchesMissing",
+          "BranchesPresent",
+          "BranchesRemoved"
+        ],
+        "properties": {
+          "BranchesMissing": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Alias"
+            }
+          },
+          "BranchesPresent": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Alias"
+            }
+          },
+          "BranchesRemoved": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Alias"
+            }
+          }
+        }
+      },
+      "TodoDiff": {
+        "type": "object",
+        "required": [
+          "Added",
+          "Removed",
+          "Moved"
+        ],
+        "properties": {
+          "Added": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Todo"
+            }
+          },
+          "Removed": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/Todo"
+            }
+          },
+          "Moved": {
+            "type": "array",
+            "items": {
+              "$ref": "#/components/schemas/TodoMove"
+            }
+          }
+        }
+      },
+      "TodoMove": {
+        "type": "object",
+        "required": [
+          "From",
+          "To"
+        ],
+        "properties": {
+          "From": {
+            "$ref": "#/components/schemas/Todo"
+          },
+          "To": {
+            "$ref": "#/components/schemas/Todo"
+          }
+        }
+      },
+      "ExpiredTodo": {
+        "type": "object",
+        "required": [
+          "Revision",
+          "FileName",
+          "LineNumber",
+          "Contents",
+          "Reason",
+          "Explanation"
+        ],
+        "properties": {
+          "Revision": {
+            "type": "string"
+          },
+          "FileName": {
+            "type": "string"
+          },
+          "LineNumber": {
+            "type": "integer"
+          },
+          "Contents": {
+            "type": "string"
+          },
+          "Reason": {
+            "type": "string",
+            "enum": [
+              "deadline",
+              "version",
+              "flag"
+            ]
+          },
+          "Explanation": {
+            "type": "string"
+          }
+        }
+      },
+      "SearchHit": {
+        "type": "object",
+        "required": [
+          "RepoId",
+          "RepoPath",
+          "Revision",
+          "FileName",
+          "LineNumber",
+          "Contents",
+          "Branches"
+        ],
+        "properties": {
+          "RepoId": {
+            "type": "string"
+          },
+          "RepoPath": {
+            "type": "string"
+          },
+          "Revision": {
+            "type": "string"
+          },
+          "FileName": {
+            "type": "string"
+          },
+          "LineNumber": {
+            "type": "integer"
+          },
+          "Contents": {
+            "type": "string"
+          },
+          "Branches": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,166 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+)
+
+// A scan of a revision, which every stream of the revision that is requested while
+// the scan is in progress shares, so that the revision is only scanned once.
+type revisionScan struct {
+	mutex sync.Mutex
+	// The progress reported so far, and whether the scan has finished.
+	progress []repo.ScanProgress
+	done     bool
+	// Closed, and replaced, whenever more progress is reported.
+	changed chan bool
+}
+
+// The scans in progress, keyed by repo ID, revision, and the options of the scan.
+var revisionScans sync.Map
+
+// Scan a revision, or join the scan of it that is already in progress.
+func (db Dashboard) joinRevisionScan(repository repo.Repository, revision repo.Revision) *revisionScan {
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s",
+		repository.GetRepoId(), revision, db.TodoRegex, db.ExcludePaths)
+	scan := &revisionScan{changed: make(chan bool)}
+	if existing, loaded := revisionScans.LoadOrStore(key, scan); loaded {
+		return existing.(*revisionScan)
+	}
+	// The scan runs on its own, so that it finishes, and its result is cached, even if
+	// every client goes away.
+	go func() {
+		for progress := range repository.StreamRevisionTodos(revision, db.TodoRegex, db.ExcludePaths) {
+			scan.report(&progress)
+		}
+		revisionScans.Delete(key)
+		scan.report(nil)
+	}()
+	return scan
+}
+
+// Record more progress, or the end of the scan if the progress is nil.
+func (scan *revisionScan) report(progress *repo.ScanProgress) {
+	scan.mutex.Lock()
+	defer scan.mutex.Unlock()
+	if progress != nil {
+		scan.progress = append(scan.progress, *progress)
+	} else {
+		scan.done = true
+	}
+	close(scan.changed)
+	scan.changed = make(chan bool)
+}
+
+// Get the progress reported after the first skipped reports, whether the scan has
+// finished, and a channel that is closed when more progress is reported.
+func (scan *revisionScan) read(skipped int) ([]repo.ScanProgress, bool, <-chan bool) {
+	scan.mutex.Lock()
+	defer scan.mutex.Unlock()
+	return scan.progress[skipped:], scan.done, scan.changed
+}
+
+// Write a single Server-Sent Event with the given name and JSON-encoded data.
+func writeEvent(w http.ResponseWriter, name string, data interface{}) error {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, dataJson); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Serve the TODOs of a single revision as a stream of Server-Sent Events, so that
+// they can be shown while the revision is still being scanned.
+// The ID of the revision is taken from the URL parameters of the request. The TODOs
+// can be filtered using the parameters described in parseTodoQuery, but they are
+// neither sorted nor paginated.
+//
+// A "progress" event, holding a repo.ScanProgress, is sent after each file is
+// scanned, and a "done" event is sent once every file has been scanned. Streams of a
+// revision that is already being scanned share that scan, and start with the progress
+// it has made so far.
+func (db Dashboard) ServeRevisionStream(w http.ResponseWriter, r *http.Request) {
+	repositoryPtr, revision, err := db.readRepoAndRevisionParams(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	query, err := parseTodoQuery(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	repository := *repositoryPtr
+	w.Header().Set("Content-Type", eventStreamContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	now := time.Now()
+	metadata := make(map[repo.Revision]repo.RevisionMetadata)
+	scan := db.joinRevisionScan(repository, revision)
+	sent := 0
+	filesTotal := 0
+	for {
+		reports, done, changed := scan.read(sent)
+		for _, progress := range reports {
+			sent++
+			filesTotal = progress.FilesTotal
+			matched := make([]repo.Line, 0)
+			for _, todo := range progress.Todos {
+				if query.matches(repository, todo, metadata, now) {
+					matched = append(matched, todo)
+				}
+			}
+			progress.Todos = matched
+			if err := writeEvent(w, "progress", progress); err != nil {
+				return
+			}
+		}
+		if done {
+			break
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	writeEvent(w, "done", repo.ScanProgress{
+		Todos:      make([]repo.Line, 0),
+		FilesDone:  filesTotal,
+		FilesTotal: filesTotal,
+	})
+}
@@ -0,0 +1,213 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package expiry finds TODOs that have outlived the condition they were waiting on.
+//
+// A TODO is considered expired if any of the following hold:
+//
+//   - It has a due date (see repo.TodoDeadline) that has passed.
+//   - It references a version, e.g. "TODO: remove in v1.2", "TODO: drop after version 3",
+//     or "TODO: keep until v8", that is lower than the current version read from a
+//     configured version file.
+//   - It is guarded by a flag, e.g. "TODO: remove when flag use_new_parser is gone",
+//     and that flag no longer appears anywhere else in the tree.
+package expiry
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+type Reason string
+
+const (
+	ReasonDeadline Reason = "deadline"
+	ReasonVersion  Reason = "version"
+	ReasonFlag     Reason = "flag"
+)
+
+type ExpiredTodo struct {
+	repo.Line
+	Reason      Reason
+	Explanation string
+}
+
+// Matches the references to versions in TODOs. In order of the alternatives, these
+// are a version with at least two components ("v1.2"), a version or release named
+// as such ("version 3"), and a version that something lasts until ("until v8"). A
+// bare "v" followed by a single number, as in "the v2 API", is not a reference.
+var versionReferenceRegexp = regexp.MustCompile(`(?i)(?:` +
+	`\bv([[:digit:]]+(?:\.[[:digit:]]+)+)|` +
+	`\b(?:version|release)\s+v?([[:digit:]]+(?:\.[[:digit:]]+)*)|` +
+	`\b(?:until|before)\s+v([[:digit:]]+(?:\.[[:digit:]]+)*))\b`)
+var versionRegexp = regexp.MustCompile(`[[:digit:]]+(?:\.[[:digit:]]+)*`)
+var flagGuardRegexp = regexp.MustCompile(
+	"(?i)\\bflag\\s+[\"'`]?([[:word:].-]+?)[\"'`]?\\s+is\\s+(?:gone|removed|deleted)\\b")
+
+// Checker decides which TODOs in a revision have expired.
+type Checker struct {
+	Now time.Time
+	// Path, within the revision being checked, of a file that holds the current
+	// version. The first version number in the file is used. If empty, or if the
+	// revision has no version in the file, TODOs are not checked against the current
+	// version.
+	VersionFile string
+}
+
+// The repositories and revisions whose version file could not be read, keyed by repo
+// ID and revision, so that the problem is only logged once for each, rather than on
+// every check.
+var versionFileErrors sync.Map
+
+type version []int
+
+func parseVersion(versionString string) version {
+	parsed := make(version, 0)
+	for _, part := range strings.Split(versionString, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		parsed = append(parsed, number)
+	}
+	return parsed
+}
+
+// Report whether v is lower than other, treating missing components as zeros.
+func (v version) less(other version) bool {
+	for i := 0; i < len(v) || i < len(other); i++ {
+		var a, b int
+		if i < len(v) {
+			a = v[i]
+		}
+		if i < len(other) {
+			b = other[i]
+		}
+		if a != b {
+			return a < b
+		}
+	}
+	return false
+}
+
+// Read the current version from the version file at the given revision.
+func (checker Checker) readCurrentVersion(
+	repository repo.Repository, revision repo.Revision) (string, error) {
+	if err := repository.ValidatePathAtRevision(revision, checker.VersionFile); err != nil {
+		return "", err
+	}
+	contents := repository.ReadFileSnippetAtRevision(revision, checker.VersionFile, 1, -1)
+	current := versionRegexp.FindString(contents)
+	if current == "" {
+		return "", fmt.Errorf("No version found in %s", checker.VersionFile)
+	}
+	return current, nil
+}
+
+// Return the flags guarding the given TODOs that no longer appear in the revision,
+// outside of the TODOs that mention them.
+func goneFlags(
+	repository repo.Repository, revision repo.Revision, flags map[string]bool) (map[string]bool, error) {
+	words := make([]string, 0)
+	gone := make(map[string]bool)
+	for flag := range flags {
+		words = append(words, flag)
+		gone[flag] = true
+	}
+	lines, err := repository.GrepRevision(revision, words)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range lines {
+		if flagGuardRegexp.MatchString(line) {
+			continue
+		}
+		for flag := range gone {
+			if strings.Contains(line, flag) {
+				delete(gone, flag)
+			}
+		}
+	}
+	return gone, nil
+}
+
+// Return the TODOs from the given revision that have expired. A TODO that has
+// expired for more than one reason is reported once per reason.
+func (checker Checker) Check(
+	repository repo.Repository, revision repo.Revision, todos []repo.Line) ([]ExpiredTodo, error) {
+	expired := make([]ExpiredTodo, 0)
+	var current version
+	var currentString string
+	if checker.VersionFile != "" {
+		var err error
+		currentString, err = checker.readCurrentVersion(repository, revision)
+		if err != nil {
+			key := repository.GetRepoId() + "\x00" + string(revision)
+			if _, logged := versionFileErrors.LoadOrStore(key, true); !logged {
+				log.Printf("Not checking TODOs against the current version of %s at %s: %v",
+					repository.GetRepoPath(), revision, err)
+			}
+		} else {
+			current = parseVersion(currentString)
+		}
+	}
+	flags := make(map[string]bool)
+	for _, todo := range todos {
+		if match := flagGuardRegexp.FindStringSubmatch(todo.Contents); match != nil {
+			flags[match[1]] = true
+		}
+	}
+	var gone map[string]bool
+	if len(flags) > 0 {
+		var err error
+		gone, err = goneFlags(repository, revision, flags)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, todo := range todos {
+		if repo.IsTodoOverdue(todo.Contents, checker.Now) {
+			deadline, _ := repo.TodoDeadline(todo.Contents)
+			expired = append(expired, ExpiredTodo{todo, ReasonDeadline,
+				fmt.Sprintf("Due on %s", deadline.Format("2006-01-02"))})
+		}
+		if current != nil {
+			for _, match := range versionReferenceRegexp.FindAllStringSubmatch(todo.Contents, -1) {
+				// Only the group of the alternative that matched is non-empty.
+				referencedString := match[1] + match[2] + match[3]
+				if referenced := parseVersion(referencedString); referenced.less(current) {
+					expired = append(expired, ExpiredTodo{todo, ReasonVersion,
+						fmt.Sprintf("Version %s is older than the current version %s",
+							referencedString, currentString)})
+					break
+				}
+			}
+		}
+		if match := flagGuardRegexp.FindStringSubmatch(todo.Contents); match != nil && gone[match[1]] {
+			expired = append(expired, ExpiredTodo{todo, ReasonFlag,
+				fmt.Sprintf("Flag %s no longer appears in the tree", match[1])})
+		}
+	}
+	return expired, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package expiry_test
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/todo-tracks/expiry"
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+const (
+	TestRevision = "testRevision"
+)
+
+func newTodo(lineNumber int, contents string) repo.Line {
+	return repo.Line{
+		Revision:   repo.Revision(TestRevision),
+		FileName:   "main.go",
+		LineNumber: lineNumber,
+		Contents:   contents,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	todos := []repo.Line{
+		newTodo(1, "// TODO(alice, 2001-02-03): overdue"),
+		newTodo(2, "// TODO(alice, 2999-02-03): not yet due"),
+		newTodo(3, "// TODO: remove in v1.2"),
+		newTodo(4, "// TODO: remove in version 1.4.2"),
+		newTodo(5, "// TODO: remove when flag use_old_parser is gone"),
+		newTodo(6, "// TODO: remove when flag use_new_parser is removed"),
+		newTodo(7, "// TODO: stop serving the v1 API"),
+		newTodo(8, "// TODO: keep until v1.3"),
+	}
+	repository := repotest.MockRepository{
+		Files: map[string]string{
+			"VERSION": "v1.4.2\n",
+			"main.go": "if *use_new_parser {\n",
+		},
+	}
+	checker := expiry.Checker{
+		Now:         time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC),
+		VersionFile: "VERSION",
+	}
+	expired, err := checker.Check(repository, repo.Revision(TestRevision), todos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		lineNumber int
+		reason     expiry.Reason
+	}{
+		{1, expiry.ReasonDeadline},
+		{3, expiry.ReasonVersion},
+		{5, expiry.ReasonFlag},
+		{8, expiry.ReasonVersion},
+	}
+	if len(expired) != len(expected) {
+		t.Fatalf("Expected %d expired TODOs, but saw %v", len(expected), expired)
+	}
+	for i, todo := range expired {
+		if todo.LineNumber != expected[i].lineNumber || todo.Reason != expected[i].reason {
+			t.Errorf("Expected line %d to expire because of its %s, but saw %v",
+				expected[i].lineNumber, expected[i].reason, todo)
+		}
+	}
+}
+
+func TestCheckMissingVersionFile(t *testing.T) {
+	todos := []repo.Line{newTodo(1, "// TODO: remove in v1.2")}
+	for _, files := range []map[string]string{
+		{},
+		{"VERSION": "unreleased\n"},
+	} {
+		repository := repotest.MockRepository{Files: files}
+		checker := expiry.Checker{Now: time.Now(), VersionFile: "VERSION"}
+		expired, err := checker.Check(repository, repo.Revision(TestRevision), todos)
+		if err != nil || len(expired) != 0 {
+			t.Errorf("Expected the version check to be skipped for the files %v, but saw %v, %v",
+				files, expired, err)
+		}
+	}
+}
+
+func TestCheckLogsMissingVersionFileOnce(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	todos := []repo.Line{newTodo(1, "// TODO: remove in v1.2")}
+	repository := repotest.MockRepository{}
+	checker := expiry.Checker{Now: time.Now(), VersionFile: "VERSION"}
+	for _, revision := range []repo.Revision{"firstRevision", "firstRevision", "secondRevision"} {
+		if _, err := checker.Check(repository, revision, todos); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if lines := strings.Count(logged.String(), "\n"); lines != 2 {
+		t.Errorf("Expected the missing version file to be logged once per revision, but saw '%s'", logged.String())
+	}
+}
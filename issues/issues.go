@@ -0,0 +1,332 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package issues links the issue references in TODOs (e.g. "#123" or "b/456")
+// to the issue trackers that they refer to.
+package issues
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+type State string
+
+// ErrAlreadyTracked is returned when filing an issue for a TODO that already has one.
+var ErrAlreadyTracked = errors.New("The TODO is already tracked by an issue")
+
+const (
+	StateUnknown State = ""
+	StateOpen    State = "open"
+	StateClosed  State = "closed"
+
+	// How long a fetched issue state is reused before it is fetched again.
+	stateCacheDuration = 10 * time.Minute
+)
+
+// A reference to an issue found in the text of a TODO.
+type Reference struct {
+	// The text of the reference, e.g. "#123".
+	Text string
+	// The ID of the issue within its tracker, e.g. "123".
+	Id string
+}
+
+// Provider is an issue tracker that issue references can point to.
+type Provider interface {
+	// Get a short name for the tracker, e.g. "github".
+	Name() string
+	// Find the references to this tracker's issues in the given text.
+	FindReferences(text string) []Reference
+	// Get the URL of the web page for the given issue.
+	IssueUrl(id string) string
+	// Fetch whether the given issue is open or closed.
+	FetchState(id string) (State, error)
+}
+
+// Creator is a Provider that can also create new issues.
+type Creator interface {
+	Provider
+	// Create an issue with the given title and body, and return a reference to it.
+	CreateIssue(title, body string) (Reference, error)
+}
+
+// A reference that has been resolved to a link, as served in the TODO JSON.
+type Link struct {
+	Provider string
+	Text     string
+	Id       string
+	Url      string
+	State    State `json:",omitempty"`
+}
+
+// Find the references that match the given regular expression. The issue ID is
+// the submatch named "id" if there is one, or the first submatch otherwise. The
+// text of the reference is the submatch named "text" if there is one, or the
+// whole match otherwise.
+func findReferences(regex *regexp.Regexp, text string) []Reference {
+	idIndex := regex.SubexpIndex("id")
+	if idIndex < 0 {
+		idIndex = 1
+	}
+	textIndex := regex.SubexpIndex("text")
+	if textIndex < 0 {
+		textIndex = 0
+	}
+	references := make([]Reference, 0)
+	for _, match := range regex.FindAllStringSubmatch(text, -1) {
+		references = append(references, Reference{
+			Text: match[textIndex],
+			Id:   match[idIndex],
+		})
+	}
+	return references
+}
+
+// Create a request that accepts JSON, and sends the given body encoded as JSON if it is not nil.
+func newJsonRequest(method, url string, body interface{}) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyJson, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		bodyReader = bytes.NewReader(bodyJson)
+	}
+	request, err := http.NewRequest(method, url, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return request, nil
+}
+
+// Issue the given request and decode its JSON response into result.
+func fetchJson(client *http.Client, request *http.Request, result interface{}) error {
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("Unexpected response status for %s: %s", request.URL, response.Status)
+	}
+	return json.NewDecoder(response.Body).Decode(result)
+}
+
+type cachedState struct {
+	state   State
+	fetched time.Time
+}
+
+// Linker resolves the issue references in TODOs using a list of providers.
+type Linker struct {
+	Providers []Provider
+	// Whether or not to fetch the state of each referenced issue.
+	FetchStates bool
+	// If not nil, records the issues filed for TODOs that had no references.
+	Mapping *Mapping
+
+	states sync.Map
+	// Held while filing an issue, from checking that the TODO is not tracked yet until
+	// the new issue is recorded, so that an issue is never filed twice for one TODO.
+	filing sync.Mutex
+}
+
+func (linker *Linker) fetchState(provider Provider, id string) State {
+	key := provider.Name() + "\x00" + id
+	if cached, ok := linker.states.Load(key); ok {
+		if time.Since(cached.(cachedState).fetched) < stateCacheDuration {
+			return cached.(cachedState).state
+		}
+	}
+	state, err := provider.FetchState(id)
+	if err != nil {
+		// Treat errors as an unknown state, rather than failing the whole request.
+		return StateUnknown
+	}
+	linker.states.Store(key, cachedState{state, time.Now()})
+	return state
+}
+
+// Find and resolve all of the issue references in the given text.
+func (linker *Linker) Links(text string) []Link {
+	links := make([]Link, 0)
+	seen := make(map[string]bool)
+	for _, provider := range linker.Providers {
+		for _, reference := range provider.FindReferences(text) {
+			key := provider.Name() + "\x00" + reference.Id
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			link := Link{
+				Provider: provider.Name(),
+				Text:     reference.Text,
+				Id:       reference.Id,
+				Url:      provider.IssueUrl(reference.Id),
+			}
+			if linker.FetchStates {
+				link.State = linker.fetchState(provider, reference.Id)
+			}
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// Get the links for a TODO: the references in its text, or the issue filed for it
+// if its text has none.
+func (linker *Linker) TodoLinks(repoId string, todo repo.Line) []Link {
+	links := linker.Links(todo.Contents)
+	if len(links) > 0 || linker.Mapping == nil {
+		return links
+	}
+	if link, ok := linker.Mapping.Lookup(repoId, todo); ok {
+		for _, provider := range linker.Providers {
+			if linker.FetchStates && provider.Name() == link.Provider {
+				link.State = linker.fetchState(provider, link.Id)
+				break
+			}
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// Get the first provider that can create issues, or nil if there is none.
+func (linker *Linker) Creator() Creator {
+	for _, provider := range linker.Providers {
+		if creator, ok := provider.(Creator); ok {
+			return creator
+		}
+	}
+	return nil
+}
+
+// Number of lines of context, on each side of a TODO, included in the issues filed for it.
+const issueContextLines = 5
+
+// Build the title and body of the issue filed for the given TODO.
+func describeTodo(repository repo.Repository, todo repo.Line) (string, string) {
+	title := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(todo.Contents), "/#*-;%!"))
+	if len(title) > 100 {
+		// Avoid splitting a multi-byte UTF-8 sequence.
+		split := 97
+		for split > 0 && !utf8.RuneStart(title[split]) {
+			split--
+		}
+		title = title[:split] + "..."
+	}
+	startLine := todo.LineNumber - issueContextLines
+	if startLine < 1 {
+		startLine = 1
+	}
+	context := repository.ReadFileSnippetAtRevision(
+		todo.Revision, todo.FileName, startLine, todo.LineNumber+issueContextLines+1)
+	metadata := repository.ReadRevisionMetadata(todo.Revision)
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "%s\n\n", strings.TrimSpace(todo.Contents))
+	fmt.Fprintf(&body, "Found in %s:%d of %s\n", todo.FileName, todo.LineNumber, repository.GetRepoPath())
+	fmt.Fprintf(&body, "Added by %s <%s> in %s\n", metadata.AuthorName, metadata.AuthorEmail, todo.Revision)
+	if browseUrl := repository.GetBrowseUrl(todo.Revision, todo.FileName, todo.LineNumber); browseUrl != "" {
+		fmt.Fprintf(&body, "%s\n", browseUrl)
+	}
+	fmt.Fprintf(&body, "\n```\n%s\n```\n", strings.TrimRight(context, "\n"))
+	return title, body.String()
+}
+
+// File an issue for the given TODO using the first provider that can create issues,
+// and record it so that the TODO is linked to the issue from then on.
+func (linker *Linker) FileIssue(repository repo.Repository, todo repo.Line) (Link, error) {
+	if linker.Mapping == nil {
+		return Link{}, errors.New("No issue mapping is configured")
+	}
+	creator := linker.Creator()
+	if creator == nil {
+		return Link{}, errors.New("None of the configured issue trackers can create issues")
+	}
+	linker.filing.Lock()
+	defer linker.filing.Unlock()
+	if len(linker.TodoLinks(repository.GetRepoId(), todo)) > 0 {
+		return Link{}, ErrAlreadyTracked
+	}
+	title, body := describeTodo(repository, todo)
+	reference, err := creator.CreateIssue(title, body)
+	if err != nil {
+		return Link{}, err
+	}
+	link := Link{
+		Provider: creator.Name(),
+		Text:     reference.Text,
+		Id:       reference.Id,
+		Url:      creator.IssueUrl(reference.Id),
+		State:    StateOpen,
+	}
+	if err := linker.Mapping.Record(repository.GetRepoId(), todo, link); err != nil {
+		return link, errors.New(fmt.Sprintf("Filed %s, but failed to record it: %s", link.Url, err))
+	}
+	return link, nil
+}
+
+// Create a provider from a specification of one of the forms:
+//
+//	github:<owner>/<repo>
+//	github:https://<enterprise-host>/<owner>/<repo>
+//	gitlab:https://<host>/<group>/<project>
+//	jira:https://<host>[?project=<key-for-new-issues>][&keys=<key>,...]
+//	template:<regex> <url-template>
+//
+// For the template form, the first submatch of the regular expression is the
+// issue ID, and every "{id}" in the URL template is replaced by that ID.
+// The token, if not empty, is used to authenticate requests to the tracker's API.
+func ParseProvider(spec, token string) (Provider, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return nil, errors.New(fmt.Sprintf("Invalid issue tracker specification: %q", spec))
+	}
+	kind, config := parts[0], parts[1]
+	switch kind {
+	case "github":
+		return NewGitHubProvider(config, token)
+	case "gitlab":
+		return NewGitLabProvider(config, token)
+	case "jira":
+		return NewJiraProvider(config, token), nil
+	case "template":
+		templateParts := strings.SplitN(config, " ", 2)
+		if len(templateParts) != 2 {
+			return nil, errors.New(fmt.Sprintf(
+				"Invalid template issue tracker, expected '<regex> <url-template>': %q", config))
+		}
+		return NewTemplateProvider(templateParts[0], templateParts[1])
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown issue tracker kind %q", kind))
+}
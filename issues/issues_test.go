@@ -0,0 +1,210 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issues_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/todo-tracks/issues"
+	"github.com/google/todo-tracks/issues/issuestest"
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func TestLinksWithStates(t *testing.T) {
+	fake := issuestest.NewFakeGitHub()
+	defer fake.Close()
+	fake.SetState("12", "closed")
+	fake.SetState("34", "open")
+	template, err := issues.NewTemplateProvider(`\bb/([0-9]+)`, "https://b.example.com/{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	linker := &issues.Linker{
+		Providers:   []issues.Provider{fake.Provider(), template},
+		FetchStates: true,
+	}
+	links := linker.Links("// TODO(#12): blocked on #34, #12, b/56, and a#78")
+	expected := []issues.Link{
+		{"github", "#12", "12", fake.Server.URL + "/owner/repo/issues/12", issues.StateClosed},
+		{"github", "#34", "34", fake.Server.URL + "/owner/repo/issues/34", issues.StateOpen},
+		{"template", "b/56", "56", "https://b.example.com/56", issues.StateUnknown},
+	}
+	if len(links) != len(expected) {
+		t.Fatalf("Expected %v, but saw %v", expected, links)
+	}
+	for i := range links {
+		if links[i] != expected[i] {
+			t.Errorf("Expected %v, but saw %v", expected[i], links[i])
+		}
+	}
+}
+
+func TestJiraAndGitLabStates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rest/api/2/issue/PROJ-1":
+			w.Write([]byte(`{"fields":{"status":{"statusCategory":{"key":"done"}}}}`))
+		case "/api/v4/projects/group%2Fproject/issues/2", "/api/v4/projects/group/project/issues/2":
+			w.Write([]byte(`{"state":"opened"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	jira := issues.NewJiraProvider(server.URL, "")
+	if state, err := jira.FetchState("PROJ-1"); err != nil || state != issues.StateClosed {
+		t.Errorf("Expected PROJ-1 to be closed, but saw %q, %v", state, err)
+	}
+	if references := jira.FindReferences("TODO(PROJ-1): x"); len(references) != 1 ||
+		references[0].Id != "PROJ-1" {
+		t.Errorf("Expected a reference to PROJ-1, but saw %v", references)
+	}
+	for _, test := range []struct {
+		serverUrl, text string
+		expected        []string
+	}{
+		{server.URL, "TODO(alice, PROJ-1): read UTF-8, not PROJ-2", []string{"PROJ-1"}},
+		{server.URL, "TODO: check the SHA-1 of PROJ-2", []string{}},
+		{server.URL + "?project=PROJ&keys=OPS", "TODO: see PROJ-2 and OPS-3, not UTF-8", []string{"PROJ-2", "OPS-3"}},
+	} {
+		ids := make([]string, 0)
+		for _, reference := range issues.NewJiraProvider(test.serverUrl, "").FindReferences(test.text) {
+			ids = append(ids, reference.Id)
+		}
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Errorf("Expected the references %v in %q, but saw %v", test.expected, test.text, ids)
+		}
+	}
+	gitlab, err := issues.NewGitLabProvider(server.URL+"/group/project", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := gitlab.FetchState("2"); err != nil || state != issues.StateOpen {
+		t.Errorf("Expected #2 to be open, but saw %q, %v", state, err)
+	}
+	if url := gitlab.IssueUrl("2"); url != server.URL+"/group/project/-/issues/2" {
+		t.Errorf("Unexpected issue URL %s", url)
+	}
+}
+
+func TestParseProvider(t *testing.T) {
+	for _, spec := range []string{
+		"github:google/todo-tracks",
+		"github:https://ghe.example.com/team/repo",
+		"gitlab:https://gitlab.com/group/project",
+		"jira:https://jira.example.com",
+		"template:b/([0-9]+) https://b.example.com/{id}",
+	} {
+		if _, err := issues.ParseProvider(spec, ""); err != nil {
+			t.Errorf("Unexpected error for %q: %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"github:", "github:norepo", "bugzilla:x", "template:b/[0-9]+ x"} {
+		if _, err := issues.ParseProvider(spec, ""); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestFileIssue(t *testing.T) {
+	fake := issuestest.NewFakeGitHub()
+	defer fake.Close()
+	mappingPath := filepath.Join(t.TempDir(), "mapping.json")
+	mapping, err := issues.LoadMapping(mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linker := &issues.Linker{Providers: []issues.Provider{fake.Provider()}, Mapping: mapping}
+	repository := repotest.MockRepository{
+		Files: map[string]string{"main.go": "package main\n\n// TODO: handle errors\nfunc main() {}\n"},
+	}
+	todo := repo.Line{
+		Revision:   repo.Revision("revision"),
+		FileName:   "main.go",
+		LineNumber: 3,
+		Contents:   "// TODO: handle errors",
+	}
+
+	link, err := linker.FileIssue(repository, todo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := fake.Created()
+	if link.Id != "1" || len(created) != 1 || created[0].Title != "TODO: handle errors" ||
+		!strings.Contains(created[0].Body, "main.go:3") ||
+		!strings.Contains(created[0].Body, "func main() {}") {
+		t.Errorf("Unexpected issue %v created for %v", created, link)
+	}
+	if _, err := linker.FileIssue(repository, todo); err != issues.ErrAlreadyTracked {
+		t.Errorf("Expected filing the same TODO twice to fail, but saw %v", err)
+	}
+
+	// Of several requests to file an issue for the same TODO at once, only one should.
+	otherTodo := todo
+	otherTodo.LineNumber = 4
+	otherTodo.Contents = "func main() {}"
+	errs := make(chan error)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := linker.FileIssue(repository, otherTodo)
+			errs <- err
+		}()
+	}
+	filed := 0
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err == nil {
+			filed++
+		} else if err != issues.ErrAlreadyTracked {
+			t.Error(err)
+		}
+	}
+	if filed != 1 || len(fake.Created()) != 2 {
+		t.Errorf("Expected one more issue to be filed, but saw %d filed and %v created", filed, fake.Created())
+	}
+
+	// The mapping should survive being reloaded, and apply when the TODO moves.
+	reloaded, err := issues.LoadMapping(mappingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linker = &issues.Linker{Providers: []issues.Provider{fake.Provider()}, Mapping: reloaded}
+	todo.LineNumber = 7
+	links := linker.TodoLinks(repository.GetRepoId(), todo)
+	if len(links) != 1 || links[0].Url != link.Url {
+		t.Errorf("Expected the TODO to be linked to %s, but saw %v", link.Url, links)
+	}
+
+	// Long titles are truncated without splitting a multi-byte character.
+	longTodo := todo
+	longTodo.LineNumber = 1
+	longTodo.Contents = "// TODO: " + strings.Repeat("é", 60)
+	if _, err := linker.FileIssue(repository, longTodo); err != nil {
+		t.Fatal(err)
+	}
+	created = fake.Created()
+	title := created[len(created)-1].Title
+	if !utf8.ValidString(title) || len(title) > 100 || !strings.HasSuffix(title, "...") {
+		t.Errorf("Expected a valid title truncated to at most 100 bytes, but saw %q", title)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package issuestest provides a local fake of the GitHub issues API for tests.
+package issuestest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/todo-tracks/issues"
+)
+
+const (
+	FakeRepo = "owner/repo"
+)
+
+// FakeGitHub serves the subset of the GitHub API used by issues.GitHubProvider.
+type FakeGitHub struct {
+	Server *httptest.Server
+
+	mutex  sync.Mutex
+	states map[string]string
+	// The issues created through the fake, in order.
+	created []CreatedIssue
+}
+
+// An issue created through the fake. Its ID is its index in the list of created issues, plus one.
+type CreatedIssue struct {
+	Title string
+	Body  string
+}
+
+func NewFakeGitHub() *FakeGitHub {
+	fake := &FakeGitHub{states: make(map[string]string)}
+	fake.Server = httptest.NewServer(http.HandlerFunc(fake.serve))
+	return fake
+}
+
+func (fake *FakeGitHub) Close() {
+	fake.Server.Close()
+}
+
+// Set the state ("open" or "closed") of the given issue.
+func (fake *FakeGitHub) SetState(id, state string) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.states[id] = state
+}
+
+// Get the issues created through the fake so far.
+func (fake *FakeGitHub) Created() []CreatedIssue {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([]CreatedIssue(nil), fake.created...)
+}
+
+// Create a provider that talks to this fake.
+func (fake *FakeGitHub) Provider() *issues.GitHubProvider {
+	return &issues.GitHubProvider{
+		Repo:   FakeRepo,
+		WebUrl: fake.Server.URL,
+		ApiUrl: fake.Server.URL,
+		Client: fake.Server.Client(),
+	}
+}
+
+func (fake *FakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	issuesPrefix := "/repos/" + FakeRepo + "/issues/"
+	if r.Method == "POST" && r.URL.Path == strings.TrimSuffix(issuesPrefix, "/") {
+		var issue CreatedIssue
+		if err := json.NewDecoder(r.Body).Decode(&issue); err != nil || issue.Title == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		fake.created = append(fake.created, issue)
+		id := strconv.Itoa(len(fake.created))
+		fake.states[id] = "open"
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"number": len(fake.created)})
+		return
+	}
+	if r.Method != "GET" || !strings.HasPrefix(r.URL.Path, issuesPrefix) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, issuesPrefix)
+	state, ok := fake.states[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"state": state})
+}
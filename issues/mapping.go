@@ -0,0 +1,103 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issues
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+// The record of an issue that was filed for a TODO.
+//
+// TODOs are identified by the revision that last modified them and their contents,
+// so the record still applies when the TODO's line number or file changes.
+type TrackedTodo struct {
+	RepoId   string
+	Revision repo.Revision
+	Contents string
+	Link     Link
+}
+
+// Mapping holds the issues filed for TODOs, optionally persisted to a JSON file.
+type Mapping struct {
+	// Path of the file the mapping is saved to. If empty, the mapping is only kept in memory.
+	Path string
+
+	mutex   sync.Mutex
+	entries []TrackedTodo
+}
+
+// Load the mapping saved at the given path. A missing file is treated as an empty mapping.
+func LoadMapping(path string) (*Mapping, error) {
+	mapping := &Mapping{Path: path}
+	if path == "" {
+		return mapping, nil
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return mapping, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contents, &mapping.entries); err != nil {
+		return nil, err
+	}
+	return mapping, nil
+}
+
+// Look up the issue filed for the given TODO, if there is one.
+func (mapping *Mapping) Lookup(repoId string, todo repo.Line) (Link, bool) {
+	mapping.mutex.Lock()
+	defer mapping.mutex.Unlock()
+	for _, entry := range mapping.entries {
+		if entry.RepoId == repoId && entry.Revision == todo.Revision && entry.Contents == todo.Contents {
+			return entry.Link, true
+		}
+	}
+	return Link{}, false
+}
+
+// Record that the given issue was filed for the given TODO, and save the mapping.
+func (mapping *Mapping) Record(repoId string, todo repo.Line, link Link) error {
+	mapping.mutex.Lock()
+	defer mapping.mutex.Unlock()
+	// The state of the issue changes over time, so it is not recorded.
+	link.State = StateUnknown
+	mapping.entries = append(mapping.entries, TrackedTodo{
+		RepoId:   repoId,
+		Revision: todo.Revision,
+		Contents: todo.Contents,
+		Link:     link,
+	})
+	if mapping.Path == "" {
+		return nil
+	}
+	contents, err := json.MarshalIndent(mapping.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first, so that a failed write does not lose the mapping.
+	tempPath := mapping.Path + ".tmp"
+	if err := os.WriteFile(tempPath, contents, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, mapping.Path)
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package issues
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	gitHubWebUrl  = "https://github.com"
+	gitHubApiUrl  = "https://api.github.com"
+	clientTimeout = 10 * time.Second
+)
+
+var gitHubReferenceRegexp = regexp.MustCompile(`(?:^|[^[:word:]/])(?P<text>#(?P<id>[[:digit:]]+))\b`)
+
+// Matches a Jira issue key that is given between the parentheses after a TODO's
+// marker, e.g. "TODO(PROJ-123)" or "FIXME(alice, PROJ-123)". Elsewhere in a TODO,
+// text that looks like an issue key is as likely to be something else, like "UTF-8".
+var jiraAnnotationReferenceRegexp = regexp.MustCompile(
+	`(?i:\b(?:TODO|FIXME|XXX|HACK|BUG))\([^)]*?\b(?P<text>(?P<id>[A-Z][A-Z0-9]+-[[:digit:]]+))\b[^)]*\)`)
+
+func newClient() *http.Client {
+	return &http.Client{Timeout: clientTimeout}
+}
+
+// Split a URL such as "https://host/group/project" into "https://host" and "group/project".
+func splitProjectUrl(projectUrl string) (string, string, error) {
+	parsed, err := url.Parse(projectUrl)
+	if err != nil {
+		return "", "", err
+	}
+	project := strings.Trim(parsed.Path, "/")
+	if parsed.Scheme == "" || parsed.Host == "" || project == "" {
+		return "", "", errors.New(fmt.Sprintf("Invalid project URL %q", projectUrl))
+	}
+	return parsed.Scheme + "://" + parsed.Host, strings.TrimSuffix(project, ".git"), nil
+}
+
+// GitHubProvider links references like "#123" to the issues of a GitHub repository.
+type GitHubProvider struct {
+	// The repository, e.g. "google/todo-tracks".
+	Repo   string
+	WebUrl string
+	ApiUrl string
+	Token  string
+	Client *http.Client
+}
+
+func (provider *GitHubProvider) CreateIssue(title, body string) (Reference, error) {
+	request, err := provider.newRequest("POST", fmt.Sprintf("/repos/%s/issues", provider.Repo),
+		map[string]string{"title": title, "body": body})
+	if err != nil {
+		return Reference{}, err
+	}
+	var issue struct {
+		Number int `json:"number"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return Reference{}, err
+	}
+	id := strconv.Itoa(issue.Number)
+	return Reference{Text: "#" + id, Id: id}, nil
+}
+
+// Create a provider for either "<owner>/<repo>" on github.com, or the full URL
+// of a repository hosted on GitHub Enterprise.
+func NewGitHubProvider(repo, token string) (*GitHubProvider, error) {
+	provider := &GitHubProvider{
+		Repo:   repo,
+		WebUrl: gitHubWebUrl,
+		ApiUrl: gitHubApiUrl,
+		Token:  token,
+		Client: newClient(),
+	}
+	if strings.Contains(repo, "://") {
+		webUrl, project, err := splitProjectUrl(repo)
+		if err != nil {
+			return nil, err
+		}
+		provider.Repo = project
+		provider.WebUrl = webUrl
+		provider.ApiUrl = webUrl + "/api/v3"
+	}
+	if strings.Count(provider.Repo, "/") != 1 {
+		return nil, errors.New(fmt.Sprintf("Invalid GitHub repository %q", repo))
+	}
+	return provider, nil
+}
+
+func (provider *GitHubProvider) Name() string {
+	return "github"
+}
+
+func (provider *GitHubProvider) FindReferences(text string) []Reference {
+	return findReferences(gitHubReferenceRegexp, text)
+}
+
+func (provider *GitHubProvider) IssueUrl(id string) string {
+	return fmt.Sprintf("%s/%s/issues/%s", provider.WebUrl, provider.Repo, id)
+}
+
+func (provider *GitHubProvider) newRequest(method, path string, body interface{}) (*http.Request, error) {
+	request, err := newJsonRequest(method, provider.ApiUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Token != "" {
+		request.Header.Set("Authorization", "token "+provider.Token)
+	}
+	return request, nil
+}
+
+func (provider *GitHubProvider) FetchState(id string) (State, error) {
+	request, err := provider.newRequest(
+		"GET", fmt.Sprintf("/repos/%s/issues/%s", provider.Repo, url.PathEscape(id)), nil)
+	if err != nil {
+		return StateUnknown, err
+	}
+	var issue struct {
+		State string `json:"state"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return StateUnknown, err
+	}
+	if issue.State == "closed" {
+		return StateClosed, nil
+	}
+	return StateOpen, nil
+}
+
+// GitLabProvider links references like "#123" to the issues of a GitLab project.
+type GitLabProvider struct {
+	BaseUrl string
+	// The project path, e.g. "group/project".
+	Project string
+	Token   string
+	Client  *http.Client
+}
+
+// Create a provider for the project at the given URL, e.g. "https://gitlab.com/group/project".
+func NewGitLabProvider(projectUrl, token string) (*GitLabProvider, error) {
+	baseUrl, project, err := splitProjectUrl(projectUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &GitLabProvider{
+		BaseUrl: baseUrl,
+		Project: project,
+		Token:   token,
+		Client:  newClient(),
+	}, nil
+}
+
+func (provider *GitLabProvider) Name() string {
+	return "gitlab"
+}
+
+func (provider *GitLabProvider) FindReferences(text string) []Reference {
+	return findReferences(gitHubReferenceRegexp, text)
+}
+
+func (provider *GitLabProvider) IssueUrl(id string) string {
+	return fmt.Sprintf("%s/%s/-/issues/%s", provider.BaseUrl, provider.Project, id)
+}
+
+func (provider *GitLabProvider) newRequest(method, path string, body interface{}) (*http.Request, error) {
+	request, err := newJsonRequest(method, fmt.Sprintf("%s/api/v4/projects/%s%s",
+		provider.BaseUrl, url.PathEscape(provider.Project), path), body)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Token != "" {
+		request.Header.Set("PRIVATE-TOKEN", provider.Token)
+	}
+	return request, nil
+}
+
+func (provider *GitLabProvider) FetchState(id string) (State, error) {
+	request, err := provider.newRequest("GET", "/issues/"+url.PathEscape(id), nil)
+	if err != nil {
+		return StateUnknown, err
+	}
+	var issue struct {
+		State string `json:"state"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return StateUnknown, err
+	}
+	if issue.State == "closed" {
+		return StateClosed, nil
+	}
+	return StateOpen, nil
+}
+
+func (provider *GitLabProvider) CreateIssue(title, body string) (Reference, error) {
+	request, err := provider.newRequest("POST", "/issues",
+		map[string]string{"title": title, "description": body})
+	if err != nil {
+		return Reference{}, err
+	}
+	var issue struct {
+		Iid int `json:"iid"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return Reference{}, err
+	}
+	id := strconv.Itoa(issue.Iid)
+	return Reference{Text: "#" + id, Id: id}, nil
+}
+
+// JiraProvider links references like "PROJ-123" to the issues of a Jira server.
+type JiraProvider struct {
+	BaseUrl string
+	// The key of the project in which new issues are created, e.g. "PROJ".
+	Project string
+	Token   string
+	Client  *http.Client
+
+	// Matches the references to the issues of the projects whose keys were given, or
+	// if none were, the references in the parentheses after a TODO's marker.
+	referenceRegexp *regexp.Regexp
+}
+
+// Create a provider for the Jira server at the given URL. The key of the project
+// used for new issues may be given with a "project" query parameter, and the keys of
+// any other projects that TODOs reference with a comma-separated "keys" parameter,
+// e.g. "https://jira.example.com?project=PROJ&keys=OPS,INFRA".
+func NewJiraProvider(serverUrl, token string) *JiraProvider {
+	project := ""
+	keys := make([]string, 0)
+	if parsed, err := url.Parse(serverUrl); err == nil && parsed.RawQuery != "" {
+		query := parsed.Query()
+		project = query.Get("project")
+		if project != "" {
+			keys = append(keys, project)
+		}
+		for _, key := range strings.Split(query.Get("keys"), ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				keys = append(keys, key)
+			}
+		}
+		parsed.RawQuery = ""
+		serverUrl = parsed.String()
+	}
+	referenceRegexp := jiraAnnotationReferenceRegexp
+	if len(keys) > 0 {
+		quotedKeys := make([]string, 0)
+		for _, key := range keys {
+			quotedKeys = append(quotedKeys, regexp.QuoteMeta(key))
+		}
+		referenceRegexp = regexp.MustCompile(
+			`\b((?:` + strings.Join(quotedKeys, "|") + `)-[[:digit:]]+)\b`)
+	}
+	return &JiraProvider{
+		BaseUrl:         strings.TrimSuffix(serverUrl, "/"),
+		Project:         project,
+		Token:           token,
+		Client:          newClient(),
+		referenceRegexp: referenceRegexp,
+	}
+}
+
+func (provider *JiraProvider) Name() string {
+	return "jira"
+}
+
+func (provider *JiraProvider) FindReferences(text string) []Reference {
+	return findReferences(provider.referenceRegexp, text)
+}
+
+func (provider *JiraProvider) IssueUrl(id string) string {
+	return fmt.Sprintf("%s/browse/%s", provider.BaseUrl, id)
+}
+
+func (provider *JiraProvider) newRequest(method, path string, body interface{}) (*http.Request, error) {
+	request, err := newJsonRequest(method, provider.BaseUrl+"/rest/api/2"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+provider.Token)
+	}
+	return request, nil
+}
+
+func (provider *JiraProvider) FetchState(id string) (State, error) {
+	request, err := provider.newRequest(
+		"GET", "/issue/"+url.PathEscape(id)+"?fields=status", nil)
+	if err != nil {
+		return StateUnknown, err
+	}
+	var issue struct {
+		Fields struct {
+			Status struct {
+				StatusCategory struct {
+					Key string `json:"key"`
+				} `json:"statusCategory"`
+			} `json:"status"`
+		} `json:"fields"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return StateUnknown, err
+	}
+	if issue.Fields.Status.StatusCategory.Key == "done" {
+		return StateClosed, nil
+	}
+	return StateOpen, nil
+}
+
+func (provider *JiraProvider) CreateIssue(title, body string) (Reference, error) {
+	if provider.Project == "" {
+		return Reference{}, errors.New("No Jira project is configured for new issues")
+	}
+	fields := map[string]interface{}{
+		"project":     map[string]string{"key": provider.Project},
+		"summary":     title,
+		"description": body,
+		"issuetype":   map[string]string{"name": "Task"},
+	}
+	request, err := provider.newRequest("POST", "/issue", map[string]interface{}{"fields": fields})
+	if err != nil {
+		return Reference{}, err
+	}
+	var issue struct {
+		Key string `json:"key"`
+	}
+	if err := fetchJson(provider.Client, request, &issue); err != nil {
+		return Reference{}, err
+	}
+	return Reference{Text: issue.Key, Id: issue.Key}, nil
+}
+
+// TemplateProvider links the references matched by a regular expression to
+// URLs built from a template, e.g. "b/([0-9]+)" and "https://b.example.com/{id}".
+// It cannot fetch issue states.
+type TemplateProvider struct {
+	Regex       *regexp.Regexp
+	UrlTemplate string
+}
+
+func NewTemplateProvider(regex, urlTemplate string) (*TemplateProvider, error) {
+	compiled, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.NumSubexp() < 1 {
+		return nil, errors.New(fmt.Sprintf(
+			"The issue regex %q must have a submatch for the issue ID", regex))
+	}
+	return &TemplateProvider{compiled, urlTemplate}, nil
+}
+
+func (provider *TemplateProvider) Name() string {
+	return "template"
+}
+
+func (provider *TemplateProvider) FindReferences(text string) []Reference {
+	return findReferences(provider.Regex, text)
+}
+
+func (provider *TemplateProvider) IssueUrl(id string) string {
+	return strings.Replace(provider.UrlTemplate, "{id}", url.PathEscape(id), -1)
+}
+
+func (provider *TemplateProvider) FetchState(id string) (State, error) {
+	return StateUnknown, nil
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -24,19 +25,58 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/google/todo-tracks/dashboard"
+	"github.com/google/todo-tracks/issues"
+	"github.com/google/todo-tracks/metrics"
+	"github.com/google/todo-tracks/mirrors"
 	"github.com/google/todo-tracks/repo"
 	"github.com/google/todo-tracks/resources"
+	"github.com/google/todo-tracks/search"
+	"github.com/google/todo-tracks/webhooks"
 )
 
 const (
 	fileContentsResource = "file_contents.html"
+
+	// The ways in which git repositories can be read.
+	execGitBackend = "exec"
+	goGitBackend   = "go"
 )
 
 var port int
 var todoRegex string
 var excludePaths string
+var webhookUrls string
+var webhookSecret string
+var webhookPollInterval time.Duration
+var versionFile string
+var issueTrackers stringList
+var issueTrackerToken string
+var fetchIssueStates bool
+var issueMappingFile string
+var searchRefreshInterval time.Duration
+var refPatterns string
+var plainDirs string
+var repoPaths string
+var remoteRepos string
+var mirrorDir string
+var fetchInterval time.Duration
+var fetchSecret string
+var gitBackend string
+
+// A flag value that may be given more than once.
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
 
 func init() {
 	flag.IntVar(&port, "port", 8080, "Port on which to start the server.")
@@ -50,6 +90,90 @@ func init() {
 		"exclude_paths",
 		"",
 		"Comma-separated list of file paths to exclude when matching TODOs. Each path is specified as a regular expression using the re2 syntax.")
+	flag.StringVar(
+		&webhookUrls,
+		"webhook_urls",
+		"",
+		"Comma-separated list of URLs to notify when the TODOs in a branch change.")
+	flag.StringVar(
+		&webhookSecret,
+		"webhook_secret",
+		"",
+		"Secret used to sign webhook payloads with HMAC-SHA256. If empty, payloads are not signed.")
+	flag.DurationVar(
+		&webhookPollInterval,
+		"webhook_poll_interval",
+		time.Minute,
+		"How often to check the branches for changes when webhooks are configured.")
+	flag.StringVar(
+		&versionFile,
+		"version_file",
+		"",
+		"Path, relative to the root of each repository, of a file holding its current version. TODOs referencing older versions are reported as expired.")
+	flag.Var(
+		&issueTrackers,
+		"issue_tracker",
+		"Issue tracker to link TODO references to. May be repeated. One of 'github:<owner>/<repo>', 'github:<enterprise-repo-url>', 'gitlab:<project-url>', 'jira:<server-url>', or 'template:<regex> <url-template>', where the regex's first submatch is the issue ID and '{id}' in the template is replaced by it.")
+	flag.StringVar(
+		&issueTrackerToken,
+		"issue_tracker_token",
+		"",
+		"Token used to authenticate with the issue trackers' APIs.")
+	flag.BoolVar(
+		&fetchIssueStates,
+		"fetch_issue_states",
+		false,
+		"Whether to fetch the state of referenced issues, so that TODOs pointing to closed issues can be flagged.")
+	flag.StringVar(
+		&issueMappingFile,
+		"issue_mapping_file",
+		"",
+		"File in which to record the issues filed for TODOs. If empty, they are only remembered until the server exits.")
+	flag.DurationVar(
+		&searchRefreshInterval,
+		"search_refresh_interval",
+		time.Minute,
+		"How often to check the branches for changes to keep the search index up to date.")
+	flag.StringVar(
+		&refPatterns,
+		"refs",
+		repo.DefaultRefPatterns,
+		"Comma-separated list of the refs to track, as patterns accepted by 'git for-each-ref'. For example, add 'refs/tags/' to track tags, or 'refs/pull/*/head' to track pull requests.")
+	flag.StringVar(
+		&plainDirs,
+		"plain_dirs",
+		"",
+		"Comma-separated list of directories that are not git repositories, such as vendored trees or unpacked release tarballs, to scan as well. Relative paths are resolved against the current directory.")
+	flag.StringVar(
+		&repoPaths,
+		"repos",
+		"",
+		"Comma-separated list of git repositories to track, which may be bare repositories such as mirrors. If set, the current directory is not searched for repositories. Relative paths are resolved against the current directory.")
+	flag.StringVar(
+		&remoteRepos,
+		"remote_repos",
+		"",
+		"Comma-separated list of the URLs of remote git repositories to track. Each is kept in a bare mirror under --mirror_dir. If set, the current directory is not searched for repositories.")
+	flag.StringVar(
+		&mirrorDir,
+		"mirror_dir",
+		"",
+		"Directory in which to keep the mirrors of the remote repositories. If empty, a directory in the user's cache directory is used.")
+	flag.DurationVar(
+		&fetchInterval,
+		"fetch_interval",
+		5*time.Minute,
+		"How often to fetch the remote repositories. If zero, they are only fetched at startup and when pinged at /fetch, if --fetch_secret is set.")
+	flag.StringVar(
+		&fetchSecret,
+		"fetch_secret",
+		"",
+		"Secret that pings to /fetch must be signed with using HMAC-SHA256, as GitHub signs webhooks. If empty, /fetch is not served.")
+	flag.StringVar(
+		&gitBackend,
+		"git_backend",
+		execGitBackend,
+		"How to read git repositories. Either 'exec', to run the git command, or 'go', to read them without git using a pure-Go implementation. The 'go' backend does not show uncommitted TODOs.")
 }
 
 func serveStaticContent(w http.ResponseWriter, resourceName string) {
@@ -68,18 +192,98 @@ func serveStaticContent(w http.ResponseWriter, resourceName string) {
 	w.Write(resourceContents)
 }
 
-func serveDashboard(dashboard dashboard.Dashboard) {
+// Register the given handler, recording its latencies under the handler's path.
+func handleInstrumented(path string, handler http.HandlerFunc) {
+	http.HandleFunc(path, metrics.InstrumentHandler(path, handler))
+}
+
+// Register the given handler like handleInstrumented, also compressing its responses
+// for the clients that accept it. Since compressed responses are buffered in full,
+// this is only for handlers that serve JSON and other documents.
+func handleCompressed(path string, handler http.HandlerFunc) {
+	handleInstrumented(path, dashboard.Compress(handler))
+}
+
+// Create the linker for the configured issue trackers, if there are any.
+func newIssueLinker() (*issues.Linker, error) {
+	if len(issueTrackers) == 0 {
+		return nil, nil
+	}
+	mapping, err := issues.LoadMapping(issueMappingFile)
+	if err != nil {
+		return nil, err
+	}
+	linker := &issues.Linker{FetchStates: fetchIssueStates, Mapping: mapping}
+	for _, spec := range issueTrackers {
+		provider, err := issues.ParseProvider(spec, issueTrackerToken)
+		if err != nil {
+			return nil, err
+		}
+		linker.Providers = append(linker.Providers, provider)
+	}
+	return linker, nil
+}
+
+// Start watching every repository for branch changes, if any webhooks are configured.
+func startWebhooks(repos map[string]*repo.Repository) *webhooks.Dispatcher {
+	if webhookUrls == "" {
+		return nil
+	}
+	dispatcher := webhooks.NewDispatcher(strings.Split(webhookUrls, ","), webhookSecret)
+	for _, repository := range repos {
+		watcher := &webhooks.Watcher{
+			Repository:   *repository,
+			TodoRegex:    todoRegex,
+			ExcludePaths: excludePaths,
+			Dispatcher:   dispatcher,
+		}
+		go watcher.Watch(webhookPollInterval)
+	}
+	return dispatcher
+}
+
+func serveDashboard(dashboard dashboard.Dashboard, dispatcher *webhooks.Dispatcher,
+	remoteMirrors *mirrors.Mirrors) {
 	http.HandleFunc("/ui/", func(w http.ResponseWriter, r *http.Request) {
 		resourceName := r.URL.Path[4:]
 		serveStaticContent(w, resourceName)
 	})
-	http.HandleFunc("/repos", dashboard.ServeReposJson)
-	http.HandleFunc("/aliases", dashboard.ServeAliasesJson)
-	http.HandleFunc("/revision", dashboard.ServeRevisionJson)
-	http.HandleFunc("/todo", dashboard.ServeTodoJson)
-	http.HandleFunc("/todoStatus", dashboard.ServeTodoStatusJson)
-	http.HandleFunc("/browse", dashboard.ServeBrowseRedirect)
-	http.HandleFunc("/raw", dashboard.ServeFileContents)
+	// Requests for revision-addressed resources that name the revision by anything
+	// but its full hash are redirected to the URL with the full hash.
+	handleRevision := func(path string, handler http.HandlerFunc) {
+		handleCompressed(path, dashboard.ResolveRevisions(handler))
+	}
+	handleCompressed("/repos", dashboard.ServeReposJson)
+	handleCompressed("/aliases", dashboard.ServeAliasesJson)
+	handleRevision("/revision", dashboard.CacheByRevision(dashboard.ServeRevisionJson))
+	if dashboard.TodoDetailsAreImmutable() {
+		handleRevision("/todo", dashboard.CacheByRevision(dashboard.ServeTodoJson))
+	} else {
+		handleRevision("/todo", dashboard.ServeTodoJson)
+	}
+	handleRevision("/todoStatus", dashboard.ServeTodoStatusJson)
+	handleRevision("/compare", dashboard.ServeCompareJson)
+	handleInstrumented("/browse", dashboard.ServeBrowseRedirect)
+	handleRevision("/raw", dashboard.CacheByRevision(dashboard.ServeFileContents))
+	handleCompressed("/feed", dashboard.ServeFeed)
+	handleCompressed("/calendar.ics", dashboard.ServeCalendar)
+	handleRevision("/expired", dashboard.ServeExpiredJson)
+	handleRevision("/fileIssue", dashboard.ServeFileIssueJson)
+	handleCompressed("/search", dashboard.ServeSearchJson)
+	// The stream is sent as it is produced, so it must not be buffered for compression.
+	handleInstrumented("/revisionStream", dashboard.ResolveRevisions(dashboard.ServeRevisionStream))
+	for path, handler := range dashboard.ApiHandlers() {
+		handleCompressed(path, handler)
+	}
+	http.HandleFunc("/metrics", dashboard.ServeMetrics)
+	if dispatcher != nil {
+		handleCompressed("/webhooks/deliveries", dispatcher.ServeDeliveriesJson)
+	}
+	// Anyone could make the server fetch if pings were not signed, so they are only
+	// accepted along with a secret.
+	if remoteMirrors != nil && remoteMirrors.Secret != "" {
+		handleInstrumented("/fetch", remoteMirrors.ServeFetch)
+	}
 	http.HandleFunc("/_ah/health",
 		func(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, "ok")
@@ -88,47 +292,149 @@ func serveDashboard(dashboard dashboard.Dashboard) {
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 }
 
-// Find all local repositories under the current working directory.
-func getLocalRepos() (map[string]*repo.Repository, error) {
+// Get the mirrors of the remote repositories given by the --remote_repos flag, or nil
+// if there are none.
+func newRemoteMirrors() (*mirrors.Mirrors, error) {
+	var urls []string
+	for _, remoteUrl := range strings.Split(remoteRepos, ",") {
+		if remoteUrl != "" {
+			urls = append(urls, remoteUrl)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	cacheDir := mirrorDir
+	if cacheDir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		cacheDir = filepath.Join(userCacheDir, "todo-tracks", "mirrors")
+	}
+	return mirrors.NewMirrors(cacheDir, urls, fetchSecret), nil
+}
+
+// Find all local repositories under the current working directory, or those given by
+// the --repos and --remote_repos flags, along with the plain directories given by the
+// --plain_dirs flag. The mirrors of the remote repositories are updated first, and
+// are returned so that they can be kept up to date.
+func getLocalRepos() (map[string]*repo.Repository, *mirrors.Mirrors, error) {
 	cwd, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if gitBackend != execGitBackend && gitBackend != goGitBackend {
+		return nil, nil, errors.New(fmt.Sprintf("Unknown git backend: %s", gitBackend))
+	}
+	remoteMirrors, err := newRemoteMirrors()
+	if err != nil {
+		return nil, nil, err
 	}
 	repos := make(map[string]*repo.Repository)
-	filepath.Walk(cwd, func(path string, info os.FileInfo, err error) error {
+	for _, dirPath := range strings.Split(plainDirs, ",") {
+		if dirPath == "" {
+			continue
+		}
+		if !filepath.IsAbs(dirPath) {
+			dirPath = filepath.Join(cwd, dirPath)
+		}
+		info, err := os.Stat(dirPath)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		if info.IsDir() {
-			dir, err := os.Open(path)
-			if err != nil {
-				return err
+		if !info.IsDir() {
+			return nil, nil, errors.New(fmt.Sprintf("%s is not a directory", dirPath))
+		}
+		dirRepo := repo.NewDirRepository(dirPath, todoRegex, excludePaths)
+		repos[dirRepo.GetRepoId()] = &dirRepo
+	}
+	if remoteMirrors != nil {
+		// A remote that cannot be reached is still tracked if it was mirrored before, but
+		// one that has never been mirrored could not be tracked until the next restart.
+		if err := remoteMirrors.UpdateAll(); err != nil {
+			log.Print(err)
+		}
+		for _, remoteUrl := range remoteMirrors.Urls {
+			path := remoteMirrors.Path(remoteUrl)
+			if _, ok := repo.ReadGitDirs(path); !ok {
+				return nil, nil, errors.New(fmt.Sprintf("Unable to mirror %s into %s", remoteUrl, path))
 			}
-			children, err := dir.Readdir(-1)
+			gitRepo, err := newGitRepo(path)
 			if err != nil {
-				return err
+				return nil, nil, err
+			}
+			repos[gitRepo.GetRepoId()] = &gitRepo
+		}
+	}
+	if repoPaths != "" {
+		for _, path := range strings.Split(repoPaths, ",") {
+			if path == "" {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(cwd, path)
 			}
-			for _, child := range children {
-				if child.IsDir() && child.Name() == ".git" {
-					gitRepo := repo.NewGitRepository(path, todoRegex, excludePaths)
-					repos[gitRepo.GetRepoId()] = &gitRepo
-					return filepath.SkipDir
-				}
+			if _, ok := repo.ReadGitDirs(path); !ok {
+				return nil, nil, errors.New(fmt.Sprintf("%s is not a git repository", path))
 			}
+			gitRepo, err := newGitRepo(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			repos[gitRepo.GetRepoId()] = &gitRepo
 		}
-		return nil
-	})
-	return repos, nil
+	}
+	if remoteMirrors != nil || repoPaths != "" {
+		return repos, remoteMirrors, nil
+	}
+	for _, path := range repo.FindRepositories(cwd) {
+		gitRepo, err := newGitRepo(path)
+		if err != nil {
+			log.Printf("Skipping the repository at %s: %v", path, err)
+			continue
+		}
+		repos[gitRepo.GetRepoId()] = &gitRepo
+	}
+	return repos, nil, nil
+}
+
+// Read the git repository at the given path using the backend given by the
+// --git_backend flag.
+func newGitRepo(path string) (repo.Repository, error) {
+	if gitBackend == goGitBackend {
+		return repo.NewGoGitRepository(path, todoRegex, excludePaths, refPatterns)
+	}
+	return repo.NewGitRepository(path, todoRegex, excludePaths, refPatterns), nil
 }
 
 func main() {
 	flag.Parse()
-	repos, err := getLocalRepos()
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Arg(0), flag.Args()[1:]))
+	}
+	repos, remoteMirrors, err := getLocalRepos()
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 	if repos == nil {
 		log.Fatal("Unable to find any local repositories under the current directory")
 	}
-	serveDashboard(dashboard.Dashboard{repos, todoRegex, excludePaths})
+	issueLinker, err := newIssueLinker()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	searchIndex := search.NewIndex(repos, todoRegex, excludePaths)
+	go searchIndex.Watch(searchRefreshInterval)
+	if remoteMirrors != nil && fetchInterval > 0 {
+		go remoteMirrors.Watch(fetchInterval)
+	}
+	serveDashboard(dashboard.Dashboard{
+		Repositories: repos,
+		TodoRegex:    todoRegex,
+		ExcludePaths: excludePaths,
+		VersionFile:  versionFile,
+		Issues:       issueLinker,
+		Search:       searchIndex,
+	}, startWebhooks(repos), remoteMirrors)
 }
@@ -0,0 +1,379 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics implements a small set of metric types that can be
+// exported using the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	counterType   = "counter"
+	gaugeType     = "gauge"
+	histogramType = "histogram"
+)
+
+// Default buckets, in seconds, used for latency histograms.
+var DefaultDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// Default buckets, in days, used for TODO age histograms.
+var DefaultAgeBuckets = []float64{1, 7, 30, 90, 180, 365, 730, 1825}
+
+// Metric is a named family of values that can write itself in the text format.
+type Metric interface {
+	Name() string
+	WriteText(w io.Writer)
+}
+
+type family struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+}
+
+func (f family) Name() string {
+	return f.name
+}
+
+func (f family) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.metricType)
+}
+
+// Build the "{name="value",...}" suffix for a sample.
+func (f family) formatLabels(labelValues []string, extraName, extraValue string) string {
+	pairs := make([]string, 0)
+	for i, labelName := range f.labelNames {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, escapeLabelValue(extraValue)))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (f family) checkLabelValues(labelValues []string) {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s expects %d label values, but got %d",
+			f.name, len(f.labelNames), len(labelValues)))
+	}
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer("\\", "\\\\", "\n", "\\n", "\"", "\\\"").Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func labelKey(labelValues []string) string {
+	return strings.Join(labelValues, "\x00")
+}
+
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// valueVec holds a single float value per set of label values.
+type valueVec struct {
+	family
+	mutex  sync.Mutex
+	labels map[string][]string
+	values map[string]float64
+}
+
+func newValueVec(name, help, metricType string, labelNames []string) valueVec {
+	return valueVec{
+		family: family{name, help, metricType, labelNames},
+		labels: make(map[string][]string),
+		values: make(map[string]float64),
+	}
+}
+
+func (v *valueVec) add(delta float64, labelValues []string) {
+	v.checkLabelValues(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	key := labelKey(labelValues)
+	v.labels[key] = labelValues
+	v.values[key] += delta
+}
+
+func (v *valueVec) set(value float64, labelValues []string) {
+	v.checkLabelValues(labelValues)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	key := labelKey(labelValues)
+	v.labels[key] = labelValues
+	v.values[key] = value
+}
+
+func (v *valueVec) get(labelValues []string) float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.values[labelKey(labelValues)]
+}
+
+func (v *valueVec) WriteText(w io.Writer) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.writeHeader(w)
+	for _, key := range sortedKeys(v.labels) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name,
+			v.formatLabels(v.labels[key], "", ""), formatFloat(v.values[key]))
+	}
+}
+
+// CounterVec is a set of monotonically increasing values partitioned by labels.
+type CounterVec struct {
+	valueVec
+}
+
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newValueVec(name, help, counterType, labelNames)}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.add(1, labelValues)
+}
+
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("counter %s cannot decrease", c.name))
+	}
+	c.add(delta, labelValues)
+}
+
+func (c *CounterVec) Get(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// GaugeVec is a set of arbitrary values partitioned by labels.
+type GaugeVec struct {
+	valueVec
+}
+
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newValueVec(name, help, gaugeType, labelNames)}
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues)
+}
+
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues)
+}
+
+func (g *GaugeVec) Get(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+type histogramValues struct {
+	labelValues  []string
+	bucketCounts []uint64
+	count        uint64
+	sum          float64
+}
+
+// HistogramVec counts observations in cumulative buckets partitioned by labels.
+type HistogramVec struct {
+	family
+	buckets []float64
+	mutex   sync.Mutex
+	values  map[string]*histogramValues
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	sortedBuckets := append([]float64(nil), buckets...)
+	sort.Float64s(sortedBuckets)
+	return &HistogramVec{
+		family:  family{name, help, histogramType, labelNames},
+		buckets: sortedBuckets,
+		values:  make(map[string]*histogramValues),
+	}
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.checkLabelValues(labelValues)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	key := labelKey(labelValues)
+	values, ok := h.values[key]
+	if !ok {
+		values = &histogramValues{
+			labelValues:  labelValues,
+			bucketCounts: make([]uint64, len(h.buckets)),
+		}
+		h.values[key] = values
+	}
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			values.bucketCounts[i]++
+		}
+	}
+	values.count++
+	values.sum += value
+}
+
+// Record the time elapsed since the given start time, in seconds.
+func (h *HistogramVec) ObserveSince(start time.Time, labelValues ...string) {
+	h.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Return the number of observations recorded for the given label values.
+func (h *HistogramVec) Count(labelValues ...string) uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	values, ok := h.values[labelKey(labelValues)]
+	if !ok {
+		return 0
+	}
+	return values.count
+}
+
+func (h *HistogramVec) WriteText(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	keys := make([]string, 0, len(h.values))
+	for key := range h.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := h.values[key]
+		for i, upperBound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+				h.formatLabels(values.labelValues, "le", formatFloat(upperBound)),
+				values.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name,
+			h.formatLabels(values.labelValues, "le", "+Inf"), values.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name,
+			h.formatLabels(values.labelValues, "", ""), formatFloat(values.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name,
+			h.formatLabels(values.labelValues, "", ""), values.count)
+	}
+}
+
+var registryMutex sync.Mutex
+var registry = make(map[string]Metric)
+
+// Register metrics so that they are included in the output of WriteRegistered.
+func Register(metrics ...Metric) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	for _, metric := range metrics {
+		if _, ok := registry[metric.Name()]; ok {
+			panic(fmt.Sprintf("metric %s registered twice", metric.Name()))
+		}
+		registry[metric.Name()] = metric
+	}
+}
+
+// Write all of the registered metrics, followed by the given extra metrics.
+func WriteRegistered(w io.Writer, extra ...Metric) {
+	registryMutex.Lock()
+	metrics := make([]Metric, 0, len(registry)+len(extra))
+	for _, metric := range registry {
+		metrics = append(metrics, metric)
+	}
+	registryMutex.Unlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name() < metrics[j].Name() })
+	metrics = append(metrics, extra...)
+	for _, metric := range metrics {
+		metric.WriteText(w)
+	}
+}
+
+// The content type for the Prometheus text exposition format.
+const TextContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var HttpRequestDuration = NewHistogramVec(
+	"todos_http_request_duration_seconds",
+	"Latency of HTTP requests, by handler and response code.",
+	DefaultDurationBuckets, "handler", "code")
+
+func init() {
+	Register(HttpRequestDuration)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush the underlying writer, if it supports flushing, so that streaming handlers can be instrumented.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Wrap the given handler so that its latency is recorded under the given name.
+func InstrumentHandler(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		HttpRequestDuration.ObserveSince(start, name, strconv.Itoa(recorder.status))
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCounterText(t *testing.T) {
+	counter := NewCounterVec("test_total", "A test \"counter\".", "label")
+	counter.Inc("a")
+	counter.Add(2, "a")
+	counter.Inc("quote\"d")
+	var buf bytes.Buffer
+	counter.WriteText(&buf)
+	expected := "# HELP test_total A test \"counter\".\n" +
+		"# TYPE test_total counter\n" +
+		"test_total{label=\"a\"} 3\n" +
+		"test_total{label=\"quote\\\"d\"} 1\n"
+	if buf.String() != expected {
+		t.Errorf("Expected '%s', but saw '%s'", expected, buf.String())
+	}
+}
+
+func TestHistogramText(t *testing.T) {
+	histogram := NewHistogramVec("test_seconds", "A test histogram.", []float64{1, 0.5})
+	histogram.Observe(0.25)
+	histogram.Observe(0.75)
+	histogram.Observe(3)
+	var buf bytes.Buffer
+	histogram.WriteText(&buf)
+	expected := "# HELP test_seconds A test histogram.\n" +
+		"# TYPE test_seconds histogram\n" +
+		"test_seconds_bucket{le=\"0.5\"} 1\n" +
+		"test_seconds_bucket{le=\"1\"} 2\n" +
+		"test_seconds_bucket{le=\"+Inf\"} 3\n" +
+		"test_seconds_sum 4\n" +
+		"test_seconds_count 3\n"
+	if buf.String() != expected {
+		t.Errorf("Expected '%s', but saw '%s'", expected, buf.String())
+	}
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	handler := InstrumentHandler("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	request, err := http.NewRequest("GET", "/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler(httptest.NewRecorder(), request)
+	if count := HttpRequestDuration.Count("/test", "418"); count != 1 {
+		t.Errorf("Expected a single recorded request, but saw %d", count)
+	}
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package mirrors keeps bare mirrors of remote repositories in a cache directory, so
+// that they can be tracked in the same way as local repositories.
+//
+// Each remote is cloned with "git clone --mirror" the first time that it is updated,
+// and fetched after that. Updates happen on a schedule, or in the background when a
+// webhook pings the handler served by ServeFetch.
+package mirrors
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/todo-tracks/webhooks"
+)
+
+const (
+	// The header in which GitHub sends the signature of a webhook's body, in the same
+	// form as the signatures of the webhooks that this server sends.
+	GitHubSignatureHeader = "X-Hub-Signature-256"
+
+	// Largest webhook body that is read to check its signature.
+	maxPingBodySize = 25 << 20
+)
+
+// Characters that are replaced when naming a mirror after its remote.
+var unsafeNameRegexp = regexp.MustCompile("[^A-Za-z0-9._-]+")
+
+// A set of remote repositories, each mirrored by a bare repository in a cache directory.
+type Mirrors struct {
+	CacheDir string
+	Urls     []string
+	// Secret that webhook pings must be signed with, as for the webhooks sent by this
+	// server. If empty, every ping is refused.
+	Secret string
+
+	// Held while running git, so that a remote is never updated twice at once.
+	mutex sync.Mutex
+	// The remotes that pings have asked to update, but whose updates have not started
+	// yet, so that repeated pings only lead to one more update.
+	pendingMutex sync.Mutex
+	pending      map[string]bool
+	// The updates that pings have started.
+	updates sync.WaitGroup
+}
+
+func NewMirrors(cacheDir string, urls []string, secret string) *Mirrors {
+	return &Mirrors{
+		CacheDir: cacheDir,
+		Urls:     urls,
+		Secret:   secret,
+		pending:  make(map[string]bool),
+	}
+}
+
+// Get the path of the mirror of a remote. Mirrors are named after the last element
+// of their remote's URL, along with a hash of the whole URL to keep remotes with the
+// same name apart.
+func (mirrors *Mirrors) Path(remoteUrl string) string {
+	name := strings.TrimSuffix(strings.TrimRight(remoteUrl, "/"), ".git")
+	name = name[strings.LastIndexAny(name, "/:")+1:]
+	name = strings.Trim(unsafeNameRegexp.ReplaceAllString(name, "_"), ".")
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(remoteUrl)))
+	return filepath.Join(mirrors.CacheDir, fmt.Sprintf("%s-%s.git", name, hash[:12]))
+}
+
+// List the paths of the mirrors, in the same order as their remotes.
+func (mirrors *Mirrors) Paths() []string {
+	paths := make([]string, 0)
+	for _, remoteUrl := range mirrors.Urls {
+		paths = append(paths, mirrors.Path(remoteUrl))
+	}
+	return paths
+}
+
+func runGitCommand(args ...string) error {
+	cmd := exec.Command("git", args...)
+	// Fail rather than wait for credentials that will never be typed in.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(fmt.Sprintf("git %s failed: %v: %s",
+			strings.Join(args, " "), err, strings.TrimSpace(string(out))))
+	}
+	return nil
+}
+
+// Clone the mirror of a remote if it does not exist yet, and otherwise fetch every
+// ref of the remote into it, pruning the refs that the remote no longer has.
+func (mirrors *Mirrors) Update(remoteUrl string) error {
+	mirrors.mutex.Lock()
+	defer mirrors.mutex.Unlock()
+	return mirrors.update(remoteUrl)
+}
+
+// Update the mirror of a remote. The caller must hold the mutex.
+func (mirrors *Mirrors) update(remoteUrl string) error {
+	mirrorPath := mirrors.Path(remoteUrl)
+	if _, err := os.Stat(mirrorPath); err == nil {
+		return runGitCommand("-C", mirrorPath, "fetch", "--quiet", "--prune")
+	}
+	if err := os.MkdirAll(mirrors.CacheDir, 0755); err != nil {
+		return err
+	}
+	// Clone next to the mirror and then move the clone into place, so that a clone that
+	// fails part way through is never mistaken for a mirror.
+	clonePath, err := os.MkdirTemp(mirrors.CacheDir, "clone-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(clonePath)
+	if err := runGitCommand("clone", "--quiet", "--mirror", "--", remoteUrl, clonePath); err != nil {
+		return err
+	}
+	return os.Rename(clonePath, mirrorPath)
+}
+
+// Update every mirror, carrying on past any failures. The error reports all of them.
+func (mirrors *Mirrors) UpdateAll() error {
+	var failures []string
+	for _, remoteUrl := range mirrors.Urls {
+		if err := mirrors.Update(remoteUrl); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("Failed to update %d of the mirrors: %s",
+			len(failures), strings.Join(failures, "; ")))
+	}
+	return nil
+}
+
+// Update every mirror at the given interval, forever.
+func (mirrors *Mirrors) Watch(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := mirrors.UpdateAll(); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// Update a mirror in the background, unless an update of it is already waiting to start.
+func (mirrors *Mirrors) requestUpdate(remoteUrl string) {
+	mirrors.pendingMutex.Lock()
+	defer mirrors.pendingMutex.Unlock()
+	if mirrors.pending[remoteUrl] {
+		return
+	}
+	mirrors.pending[remoteUrl] = true
+	mirrors.updates.Add(1)
+	go func() {
+		defer mirrors.updates.Done()
+		mirrors.mutex.Lock()
+		defer mirrors.mutex.Unlock()
+		// A ping from now on needs another update, since this one might miss its push.
+		mirrors.pendingMutex.Lock()
+		delete(mirrors.pending, remoteUrl)
+		mirrors.pendingMutex.Unlock()
+		if err := mirrors.update(remoteUrl); err != nil {
+			log.Print(err)
+		}
+	}()
+}
+
+// Wait for the updates started by pings to finish.
+func (mirrors *Mirrors) Wait() {
+	mirrors.updates.Wait()
+}
+
+// Update the mirrors when pinged by a webhook, such as one sent when a remote is
+// pushed to. The request must be a POST, signed with the secret, and pings are
+// refused if there is no secret. The "url" parameter picks a single remote to update,
+// and otherwise all of them are. The updates run in the background, so the response
+// only reports that they were requested.
+func (mirrors *Mirrors) ServeFetch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, "Mirrors can only be fetched with a POST request")
+		return
+	}
+	if mirrors.Secret == "" {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "Mirrors cannot be fetched without a secret")
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPingBodySize))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, err)
+		return
+	}
+	signature := r.Header.Get(GitHubSignatureHeader)
+	if signature == "" {
+		signature = r.Header.Get(webhooks.SignatureHeader)
+	}
+	if !hmac.Equal([]byte(signature), []byte(webhooks.Sign(mirrors.Secret, body))) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, "The request is not signed with the secret")
+		return
+	}
+	remoteUrls := mirrors.Urls
+	if remoteUrl := r.URL.Query().Get("url"); remoteUrl != "" {
+		remoteUrls = nil
+		for _, knownUrl := range mirrors.Urls {
+			if knownUrl == remoteUrl {
+				remoteUrls = []string{remoteUrl}
+			}
+		}
+		if remoteUrls == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "No mirror of %s", remoteUrl)
+			return
+		}
+	}
+	for _, remoteUrl := range remoteUrls {
+		mirrors.requestUpdate(remoteUrl)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Fetching %d of the mirrors", len(remoteUrls))
+}
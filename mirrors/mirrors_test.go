@@ -0,0 +1,187 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mirrors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/todo-tracks/mirrors"
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+	"github.com/google/todo-tracks/webhooks"
+)
+
+const (
+	TestSecret = "testSecret"
+)
+
+// Read the revision of a ref in a mirror, or "" if the mirror does not have the ref.
+func readMirrorRef(t *testing.T, mirrorPath, ref string) repo.Revision {
+	out, err := exec.Command("git", "-C", mirrorPath, "rev-parse", "--verify", "--quiet", ref).Output()
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return ""
+		}
+		t.Fatal(err)
+	}
+	return repo.Revision(strings.TrimSpace(string(out)))
+}
+
+func TestPath(t *testing.T) {
+	remoteMirrors := mirrors.NewMirrors("cache", []string{
+		"https://github.com/google/todo-tracks.git",
+		"git@github.com:google/todo-tracks",
+		"file:///srv/git/project/",
+	}, "")
+	paths := remoteMirrors.Paths()
+	for i, expectedPrefix := range []string{"todo-tracks-", "todo-tracks-", "project-"} {
+		name := filepath.Base(paths[i])
+		if filepath.Dir(paths[i]) != "cache" || !strings.HasPrefix(name, expectedPrefix) ||
+			!strings.HasSuffix(name, ".git") {
+			t.Errorf("Expected the mirror of %s to be named after it, but saw %s",
+				remoteMirrors.Urls[i], paths[i])
+		}
+	}
+	if paths[0] == paths[1] {
+		t.Errorf("Expected the mirrors of different remotes to be kept apart, but saw %s", paths[0])
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	firstRevision := fixture.Commit("master", "Add main", map[string]string{
+		"main.go": "// TODO: write main\n",
+	})
+	fixture.Branch("feature", "master")
+	remoteUrl := "file://" + filepath.ToSlash(fixture.Dir)
+	remoteMirrors := mirrors.NewMirrors(t.TempDir(), []string{remoteUrl}, "")
+	if err := remoteMirrors.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	mirrorPath := remoteMirrors.Path(remoteUrl)
+	if dirs, ok := repo.ReadGitDirs(mirrorPath); !ok || !dirs.Bare {
+		t.Fatalf("Expected a bare mirror at %s, but saw %v", mirrorPath, dirs)
+	}
+	if revision := readMirrorRef(t, mirrorPath, "refs/heads/feature"); revision != firstRevision {
+		t.Errorf("Expected the feature branch to be mirrored at %s, but saw %q", firstRevision, revision)
+	}
+
+	secondRevision := fixture.Commit("master", "Write main", map[string]string{
+		"main.go": "func main() {}\n",
+	})
+	fixture.Git("branch", "-D", "feature")
+	if err := remoteMirrors.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	if revision := readMirrorRef(t, mirrorPath, "refs/heads/master"); revision != secondRevision {
+		t.Errorf("Expected master to be fetched at %s, but saw %q", secondRevision, revision)
+	}
+	if revision := readMirrorRef(t, mirrorPath, "refs/heads/feature"); revision != "" {
+		t.Errorf("Expected the deleted branch to be pruned, but saw %s", revision)
+	}
+}
+
+func TestUpdateFailure(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	cacheDir := t.TempDir()
+	missingUrl := "file://" + filepath.ToSlash(filepath.Join(t.TempDir(), "missing"))
+	remoteMirrors := mirrors.NewMirrors(cacheDir, []string{missingUrl}, "")
+	if err := remoteMirrors.UpdateAll(); err == nil {
+		t.Errorf("Expected mirroring a missing remote to fail")
+	}
+	if entries, err := os.ReadDir(cacheDir); err != nil || len(entries) != 0 {
+		t.Errorf("Expected the failed clone to be cleaned up, but saw %v, %v", entries, err)
+	}
+}
+
+func TestServeFetch(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	fixture.Commit("master", "Add main", map[string]string{
+		"main.go": "// TODO: write main\n",
+	})
+	remoteUrl := "file://" + filepath.ToSlash(fixture.Dir)
+	remoteMirrors := mirrors.NewMirrors(t.TempDir(), []string{remoteUrl}, TestSecret)
+	if err := remoteMirrors.UpdateAll(); err != nil {
+		t.Fatal(err)
+	}
+	revision := fixture.Commit("master", "Write main", map[string]string{
+		"main.go": "func main() {}\n",
+	})
+
+	ping := func(method, query, signature string) *httptest.ResponseRecorder {
+		body := `{"ref": "refs/heads/master"}`
+		request, err := http.NewRequest(method, "/fetch?"+query, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if signature != "" {
+			request.Header.Set(mirrors.GitHubSignatureHeader, signature)
+		}
+		rw := httptest.NewRecorder()
+		remoteMirrors.ServeFetch(rw, request)
+		return rw
+	}
+	validSignature := webhooks.Sign(TestSecret, []byte(`{"ref": "refs/heads/master"}`))
+	for _, test := range []struct {
+		method, query, signature string
+		status                   int
+	}{
+		{"GET", "", validSignature, http.StatusMethodNotAllowed},
+		{"POST", "", "", http.StatusUnauthorized},
+		{"POST", "", webhooks.Sign("wrongSecret", []byte(`{}`)), http.StatusUnauthorized},
+		{"POST", "url=file:///unknown", validSignature, http.StatusNotFound},
+	} {
+		if rw := ping(test.method, test.query, test.signature); rw.Code != test.status {
+			t.Errorf("Expected a %s of %q to get a response code of %d, but saw %d, with a body of '%s'",
+				test.method, test.query, test.status, rw.Code, rw.Body.String())
+		}
+	}
+	mirrorPath := remoteMirrors.Path(remoteUrl)
+	if fetched := readMirrorRef(t, mirrorPath, "refs/heads/master"); fetched == revision {
+		t.Errorf("Expected the rejected pings not to fetch %s", revision)
+	}
+	if rw := ping("POST", "url="+remoteUrl, validSignature); rw.Code != http.StatusAccepted {
+		t.Fatalf("Expected a response code of %d, but saw %d, with a body of '%s'",
+			http.StatusAccepted, rw.Code, rw.Body.String())
+	}
+	remoteMirrors.Wait()
+	if fetched := readMirrorRef(t, mirrorPath, "refs/heads/master"); fetched != revision {
+		t.Errorf("Expected the ping to fetch %s, but saw %s", revision, fetched)
+	}
+}
+
+func TestServeFetchWithoutSecret(t *testing.T) {
+	remoteMirrors := mirrors.NewMirrors(t.TempDir(), []string{"file:///unknown"}, "")
+	request, err := http.NewRequest("POST", "/fetch", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	remoteMirrors.ServeFetch(rw, request)
+	if rw.Code != http.StatusForbidden {
+		t.Errorf("Expected a response code of %d without a secret, but saw %d, with a body of '%s'",
+			http.StatusForbidden, rw.Code, rw.Body.String())
+	}
+}
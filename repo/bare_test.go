@@ -0,0 +1,86 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+// Check that a bare mirror of a repository reads the same as its checkout.
+func TestBareRepository(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	addTodo := fixture.Commit("master", "Add main", map[string]string{
+		"main.go": "package main\n\n// TODO: write main\n",
+	})
+	fixture.Branch("fix", "master")
+	fixture.Commit("master", "Add the docs", map[string]string{
+		"docs/notes.txt": "TODO: write the notes\n",
+	})
+	fixture.Commit("fix", "Write main", map[string]string{
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	fixture.Tag("v1", addTodo, "The first release")
+	mirrorPath := filepath.Join(t.TempDir(), "project.git")
+	fixture.Mirror(mirrorPath)
+	todoId := repo.TodoId{Revision: addTodo, FileName: "main.go", LineNumber: 3}
+
+	for name, newRepository := range map[string]repotest.RepositoryFactory{
+		"git":    repo.NewGitRepositoryForTest,
+		"go-git": repo.NewGoGitRepositoryForTest,
+	} {
+		checkout, err := newRepository(fixture.Dir, "refs/heads/,refs/tags/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		mirror, err := newRepository(mirrorPath, "refs/heads/,refs/tags/")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expectedAliases := checkout.ListBranches()
+		if aliases := mirror.ListBranches(); len(aliases) != 3 || !reflect.DeepEqual(aliases, expectedAliases) {
+			t.Errorf("%s: expected the branches %v, but saw %v", name, expectedAliases, aliases)
+		}
+		if uncommitted := mirror.ListUncommitted(); len(uncommitted) != 0 {
+			t.Errorf("%s: expected no uncommitted changes in a bare repository, but saw %v", name, uncommitted)
+		}
+		for _, alias := range expectedAliases {
+			expectedContents := checkout.ReadRevisionContents(alias.Revision)
+			if contents := mirror.ReadRevisionContents(alias.Revision); !reflect.DeepEqual(contents, expectedContents) {
+				t.Errorf("%s: expected the contents %v, but saw %v", name, expectedContents, contents)
+			}
+			expectedTodos := checkout.LoadRevisionTodos(alias.Revision, "TODO", "")
+			if todos := mirror.LoadRevisionTodos(alias.Revision, "TODO", ""); !reflect.DeepEqual(todos, expectedTodos) {
+				t.Errorf("%s: expected the TODOs %v in %s, but saw %v", name, expectedTodos, alias.Branch, todos)
+			}
+		}
+		expectedClosing, err := checkout.FindClosingRevisions(todoId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if closing, err := mirror.FindClosingRevisions(todoId); err != nil || len(closing) != 1 || !reflect.DeepEqual(closing, expectedClosing) {
+			t.Errorf("%s: expected %v to be closed by %v, but saw %v, %v", name, todoId, expectedClosing, closing, err)
+		}
+		if revision, err := mirror.ValidateRevision("v1"); err != nil || revision != addTodo {
+			t.Errorf("%s: expected v1 to resolve to %s, but saw %s, %v", name, addTodo, revision, err)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func TestParseBlameOutputAcrossRenames(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	addFile := fixture.Commit("master", "Add a file", map[string]string{
+		"a.txt": "one\nTODO: two\nthree\n",
+	})
+	editFile := fixture.Commit("master", "Edit the file", map[string]string{
+		"a.txt": "zero\none\nTODO: two\nTHREE\n",
+	})
+	fixture.Rename("master", "Rename the file", "a.txt", "b.txt")
+	appendLine := fixture.Commit("master", "Append a line", map[string]string{
+		"b.txt": "zero\none\nTODO: two\nTHREE\nfour\n",
+	})
+
+	out := fixture.Git("blame", "--root", "--line-porcelain", string(appendLine), "--", "b.txt")
+	expected := []repo.Line{
+		{Revision: editFile, FileName: "a.txt", LineNumber: 1, Contents: "zero"},
+		{Revision: addFile, FileName: "a.txt", LineNumber: 1, Contents: "one"},
+		{Revision: addFile, FileName: "a.txt", LineNumber: 2, Contents: "TODO: two"},
+		{Revision: editFile, FileName: "a.txt", LineNumber: 4, Contents: "THREE"},
+		{Revision: appendLine, FileName: "b.txt", LineNumber: 5, Contents: "four"},
+	}
+	if lines := repo.ParseBlameOutputOrDie("b.txt", out); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected the blamed lines %v, but saw %v", expected, lines)
+	}
+
+	out = fixture.Git("blame", "--root", "--line-porcelain", "-L", "3,+1", string(appendLine), "--", "b.txt")
+	if lines := repo.ParseBlameOutputOrDie("b.txt", out); !reflect.DeepEqual(lines, expected[2:3]) {
+		t.Errorf("Expected the blamed line %v, but saw %v", expected[2:3], lines)
+	}
+}
@@ -0,0 +1,32 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func TestGitRepositoryConformance(t *testing.T) {
+	repotest.RunConformanceTests(t, repo.NewGitRepositoryForTest)
+}
+
+func TestGoGitRepositoryConformance(t *testing.T) {
+	repotest.RunConformanceTests(t, repo.NewGoGitRepositoryForTest)
+}
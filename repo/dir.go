@@ -0,0 +1,451 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// The name of the only branch of a plain directory.
+	DirBranch = "current"
+
+	// How long a walk of the directory is reused before the directory is walked again.
+	snapshotCacheDuration = 2 * time.Second
+)
+
+// Directories that hold the metadata of version control systems, which are never scanned.
+var vcsDirs = map[string]bool{".git": true, ".hg": true, ".svn": true, ".bzr": true}
+
+// The hash of a file's contents, along with the file information it was computed from.
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// The state of a plain directory at one point in time.
+type dirSnapshot struct {
+	revision Revision
+	paths    []string
+	// The hash of each file's contents, keyed by path.
+	hashes map[string]string
+	// The time at which the most recently modified file was modified.
+	modTime time.Time
+}
+
+// A repository for a plain directory that is not under version control. It has a single
+// revision, whose ID is a hash of the directory's contents, so the revision changes
+// whenever any file does, and only the current revision can be read.
+type dirRepository struct {
+	DirPath            string
+	FileHashCache      *sync.Map
+	FileTodosCache     *sync.Map
+	RevisionTodosCache *sync.Map
+
+	// The most recent snapshot, and when it was read. The mutex is held during the walk,
+	// so that concurrent requests share a single walk of the directory.
+	snapshotMutex sync.Mutex
+	snapshot      *dirSnapshot
+	snapshotRead  time.Time
+}
+
+func NewDirRepository(dirPath, todoRegex, excludePaths string) Repository {
+	repository := &dirRepository{
+		DirPath:            dirPath,
+		FileHashCache:      &sync.Map{},
+		FileTodosCache:     &sync.Map{},
+		RevisionTodosCache: &sync.Map{},
+	}
+	go func() {
+		// Pre-load the TODOs for the current contents.
+		for _, alias := range repository.ListBranches() {
+			repository.LoadRevisionTodos(alias.Revision, todoRegex, excludePaths)
+		}
+	}()
+	return repository
+}
+
+func (repository *dirRepository) GetRepoId() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(repository.DirPath)))
+}
+
+func (repository *dirRepository) GetRepoPath() string {
+	return repository.DirPath
+}
+
+// The revisions of a directory look like commit hashes, but its files are read as they
+// are now, so what is read for a revision can change.
+func (repository *dirRepository) RevisionsAreImmutable() bool {
+	return false
+}
+
+// Hash the contents of a file, reusing the previous hash if its size and modification
+// time have not changed.
+func (repository *dirRepository) hashFile(path string, info fs.FileInfo) (string, error) {
+	cachedHash, ok := repository.FileHashCache.Load(path)
+	recordCacheLookup("file_hash", ok)
+	if ok {
+		hash := cachedHash.(fileHash)
+		if hash.size == info.Size() && hash.modTime.Equal(info.ModTime()) {
+			return hash.hash, nil
+		}
+	}
+	contents, err := os.ReadFile(filepath.Join(repository.DirPath, path))
+	if err != nil {
+		return "", err
+	}
+	hash := fmt.Sprintf("%x", sha1.Sum(contents))
+	repository.FileHashCache.Store(path, fileHash{info.Size(), info.ModTime(), hash})
+	return hash, nil
+}
+
+// Read the current state of the directory, reusing a recent walk of it.
+func (repository *dirRepository) readSnapshot() dirSnapshot {
+	repository.snapshotMutex.Lock()
+	defer repository.snapshotMutex.Unlock()
+	cached := repository.snapshot != nil && time.Since(repository.snapshotRead) < snapshotCacheDuration
+	recordCacheLookup("dir_snapshot", cached)
+	if !cached {
+		snapshot := repository.walkSnapshot()
+		if repository.snapshot == nil || repository.snapshot.revision != snapshot.revision {
+			repository.pruneCaches(snapshot)
+		}
+		repository.snapshot = &snapshot
+		repository.snapshotRead = time.Now()
+	}
+	return *repository.snapshot
+}
+
+// Drop everything cached for files and revisions other than those of the given
+// snapshot. Only the current revision can be read, and every edit makes a new one, so
+// the caches would otherwise grow for as long as the directory is watched.
+func (repository *dirRepository) pruneCaches(snapshot dirSnapshot) {
+	hashes := make(map[string]bool)
+	for _, hash := range snapshot.hashes {
+		hashes[hash] = true
+	}
+	repository.FileHashCache.Range(func(path, hash any) bool {
+		if _, ok := snapshot.hashes[path.(string)]; !ok {
+			repository.FileHashCache.Delete(path)
+		}
+		return true
+	})
+	repository.FileTodosCache.Range(func(hash, todos any) bool {
+		if !hashes[hash.(string)] {
+			repository.FileTodosCache.Delete(hash)
+		}
+		return true
+	})
+	repository.RevisionTodosCache.Range(func(revision, todos any) bool {
+		if revision.(Revision) != snapshot.revision {
+			repository.RevisionTodosCache.Delete(revision)
+		}
+		return true
+	})
+}
+
+// Forget the most recent snapshot, so that the next read walks the directory again.
+func (repository *dirRepository) invalidateSnapshot() {
+	repository.snapshotMutex.Lock()
+	defer repository.snapshotMutex.Unlock()
+	repository.snapshot = nil
+}
+
+// Walk the directory to compute its current revision.
+func (repository *dirRepository) walkSnapshot() dirSnapshot {
+	snapshot := dirSnapshot{hashes: make(map[string]string)}
+	filepath.WalkDir(repository.DirPath, func(fullPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Skip anything that cannot be read, such as files that were just removed.
+			return nil
+		}
+		if entry.IsDir() {
+			if vcsDirs[entry.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		relativePath, err := filepath.Rel(repository.DirPath, fullPath)
+		if err != nil {
+			return nil
+		}
+		path := filepath.ToSlash(relativePath)
+		hash, err := repository.hashFile(path, info)
+		if err != nil {
+			return nil
+		}
+		snapshot.paths = append(snapshot.paths, path)
+		snapshot.hashes[path] = hash
+		if info.ModTime().After(snapshot.modTime) {
+			snapshot.modTime = info.ModTime()
+		}
+		return nil
+	})
+	sort.Strings(snapshot.paths)
+	treeHash := sha1.New()
+	for _, path := range snapshot.paths {
+		fmt.Fprintf(treeHash, "%s\x00%s\n", path, snapshot.hashes[path])
+	}
+	snapshot.revision = Revision(fmt.Sprintf("%x", treeHash.Sum(nil)))
+	return snapshot
+}
+
+func (repository *dirRepository) ListBranches() []Alias {
+	snapshot := repository.readSnapshot()
+	alias := Alias{
+		Branch:       DirBranch,
+		Revision:     snapshot.revision,
+		Type:         BranchRef,
+		LastModified: snapshot.modTime.Unix(),
+		TodoCount:    -1,
+	}
+	if cachedTodos, ok := repository.RevisionTodosCache.Load(snapshot.revision); ok {
+		alias.TodoCount = len(cachedTodos.([]Line))
+	}
+	return []Alias{alias}
+}
+
+func (repository *dirRepository) ListUncommitted() []Alias {
+	return []Alias{}
+}
+
+func (repository *dirRepository) IsAncestor(ancestor, descendant Revision) bool {
+	return ancestor == descendant
+}
+
+func (repository *dirRepository) ReadFirstParentHistory(revision Revision, maxCount int) []Revision {
+	if maxCount < 1 {
+		return []Revision{}
+	}
+	return []Revision{revision}
+}
+
+func (repository *dirRepository) ReadRevisionContents(revision Revision) *RevisionContents {
+	snapshot := repository.readSnapshot()
+	if snapshot.paths == nil {
+		snapshot.paths = make([]string, 0)
+	}
+	return &RevisionContents{Revision: revision, Paths: snapshot.paths}
+}
+
+func (repository *dirRepository) ReadRevisionMetadata(revision Revision) RevisionMetadata {
+	return RevisionMetadata{
+		Revision:  revision,
+		Timestamp: repository.readSnapshot().modTime.Unix(),
+		Subject:   fmt.Sprintf("Contents of %s", repository.DirPath),
+	}
+}
+
+func (repository *dirRepository) readFile(path string) (string, error) {
+	contents, err := os.ReadFile(filepath.Join(repository.DirPath, filepath.FromSlash(path)))
+	return string(contents), err
+}
+
+func (repository *dirRepository) ReadFileSnippetAtRevision(revision Revision, path string, startLine, endLine int) string {
+	contents, err := repository.readFile(path)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.Trim(contents, " \n"), "\n")
+	if startLine < 1 {
+		startLine = 1
+	}
+	if endLine > len(lines) || endLine < 0 {
+		endLine = len(lines) + 1
+	}
+	if startLine > endLine {
+		return ""
+	}
+	var snippet strings.Builder
+	for _, line := range lines[startLine-1 : endLine-1] {
+		snippet.WriteString(line)
+		snippet.WriteString("\n")
+	}
+	return snippet.String()
+}
+
+func (repository *dirRepository) GrepRevision(revision Revision, words []string) ([]string, error) {
+	lines := make([]string, 0)
+	if len(words) == 0 {
+		return lines, nil
+	}
+	for _, path := range repository.readSnapshot().paths {
+		// Skip the files that were changed or removed since they were listed.
+		if contents, err := repository.readFile(path); err == nil {
+			lines = append(lines, GrepLines(contents, words)...)
+		}
+	}
+	return lines, nil
+}
+
+func (repository *dirRepository) LoadRevisionTodos(
+	revision Revision, todoRegex, excludePaths string) []Line {
+	var todos []Line
+	for progress := range repository.StreamRevisionTodos(revision, todoRegex, excludePaths) {
+		todos = append(todos, progress.Todos...)
+	}
+	return todos
+}
+
+func (repository *dirRepository) LoadCachedRevisionTodos(revision Revision) ([]Line, bool) {
+	cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
+	if !ok {
+		return nil, false
+	}
+	return cachedTodos.([]Line), true
+}
+
+func (repository *dirRepository) StreamRevisionTodos(
+	revision Revision, todoRegex, excludePaths string) <-chan ScanProgress {
+	progress := make(chan ScanProgress)
+	go func() {
+		defer close(progress)
+		cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
+		recordCacheLookup("revision_todos", ok)
+		if ok {
+			progress <- ScanProgress{Todos: cachedTodos.([]Line), FilesDone: 1, FilesTotal: 1}
+			return
+		}
+		start := time.Now()
+		snapshot := repository.readSnapshot()
+		if snapshot.revision != revision {
+			// Only the current contents can be read.
+			return
+		}
+		excludeRegexs := compileRegexsOrDie(excludePaths)
+		paths := make([]string, 0)
+	Paths:
+		for _, path := range snapshot.paths {
+			for _, regex := range excludeRegexs {
+				if regex.MatchString(path) {
+					continue Paths
+				}
+			}
+			paths = append(paths, path)
+		}
+		var todos []Line
+		for i, path := range paths {
+			fileTodos := repository.loadFileTodos(revision, path, snapshot.hashes[path], todoRegex)
+			todos = append(todos, fileTodos...)
+			progress <- ScanProgress{
+				FileName:   path,
+				Todos:      fileTodos,
+				FilesDone:  i + 1,
+				FilesTotal: len(paths),
+			}
+		}
+		repository.RevisionTodosCache.Store(revision, todos)
+		revisionScanDuration.ObserveSince(start, repository.DirPath)
+	}()
+	return progress
+}
+
+// Find the TODOs in a file, all of which are reported at the given revision. The line
+// numbers and contents are cached by the hash of the file's contents.
+func (repository *dirRepository) loadFileTodos(revision Revision, path, hash, todoRegex string) []Line {
+	var fileTodos []Line
+	cachedTodos, ok := repository.FileTodosCache.Load(hash)
+	recordCacheLookup("blob_todos", ok)
+	if ok {
+		fileTodos = cachedTodos.([]Line)
+	} else {
+		contents, err := repository.readFile(path)
+		if err != nil {
+			return nil
+		}
+		for lineNumber, lineContents := range strings.Split(contents, "\n") {
+			matched, err := regexp.MatchString(todoRegex, lineContents)
+			if err == nil && matched {
+				fileTodos = append(fileTodos, Line{LineNumber: lineNumber + 1, Contents: lineContents})
+			}
+		}
+		repository.FileTodosCache.Store(hash, fileTodos)
+	}
+	todos := make([]Line, 0, len(fileTodos))
+	for _, todo := range fileTodos {
+		todos = append(todos, Line{revision, path, todo.LineNumber, todo.Contents})
+	}
+	return todos
+}
+
+func (repository *dirRepository) LoadFileTodos(
+	revision Revision, path string, todoRegex string) []Line {
+	snapshot := repository.readSnapshot()
+	hash, ok := snapshot.hashes[path]
+	if !ok || snapshot.revision != revision {
+		return nil
+	}
+	return repository.loadFileTodos(revision, path, hash, todoRegex)
+}
+
+func (repository *dirRepository) FindClosingRevisions(todoId TodoId) ([]Revision, error) {
+	// There is no history in which a TODO could have been removed.
+	return []Revision{}, nil
+}
+
+func (repository *dirRepository) GetBrowseUrl(revision Revision, path string, lineNumber int) string {
+	return fmt.Sprintf("/raw?repo=%s&revision=%s&fileName=%s&lineNumber=%d",
+		repository.GetRepoId(), string(revision), url.QueryEscape(path), lineNumber)
+}
+
+func (repository *dirRepository) ValidateRevision(revisionString string) (Revision, error) {
+	snapshot := repository.readSnapshot()
+	if revisionString == string(snapshot.revision) || revisionString == DirBranch {
+		return snapshot.revision, nil
+	}
+	return Revision(""), errors.New(fmt.Sprintf("Unknown revision: %s", revisionString))
+}
+
+func (repository *dirRepository) ValidatePathAtRevision(revision Revision, path string) error {
+	if _, ok := repository.readSnapshot().hashes[path]; ok {
+		return nil
+	}
+	return errors.New(fmt.Sprintf("Path '%s' not found at revision %s", path, string(revision)))
+}
+
+func (repository *dirRepository) ValidateLineNumberInPathAtRevision(
+	revision Revision, path string, lineNumber int) error {
+	contents, err := repository.readFile(path)
+	if err != nil {
+		return err
+	}
+	if lineNumber < 1 || len(strings.Split(strings.Trim(contents, " \n"), "\n")) < lineNumber {
+		return errors.New(fmt.Sprintf(
+			"Line #%d, not found at path %s in revision %s",
+			lineNumber, path, string(revision)))
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const testTodoRegex = "TODO"
+
+func TestDirRepository(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(path, contents string) {
+		fullPath := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("main.go", "package main\n\n// TODO: write main\n")
+	writeFile("docs/notes.txt", "Nothing to do here\n")
+	writeFile(".git/config", "TODO: not scanned\n")
+	repository := &dirRepository{
+		DirPath:            dir,
+		FileHashCache:      &sync.Map{},
+		FileTodosCache:     &sync.Map{},
+		RevisionTodosCache: &sync.Map{},
+	}
+
+	aliases := repository.ListBranches()
+	if len(aliases) != 1 || aliases[0].Branch != DirBranch {
+		t.Fatalf("Expected a single %s branch, but saw %v", DirBranch, aliases)
+	}
+	revision := aliases[0].Revision
+	if !hashRegexp.MatchString(string(revision)) {
+		t.Errorf("Expected the revision to be a content hash, but saw %s", revision)
+	}
+	todos := repository.LoadRevisionTodos(revision, testTodoRegex, "")
+	expected := Line{revision, "main.go", 3, "// TODO: write main"}
+	if len(todos) != 1 || todos[0] != expected {
+		t.Errorf("Expected %v, but saw %v", expected, todos)
+	}
+	if resolved, err := repository.ValidateRevision(DirBranch); err != nil || resolved != revision {
+		t.Errorf("Expected %s to resolve to %s, but saw %s, %v", DirBranch, revision, resolved, err)
+	}
+	if err := repository.ValidatePathAtRevision(revision, ".git/config"); err == nil {
+		t.Errorf("Expected the .git directory to be skipped")
+	}
+	if snippet := repository.ReadFileSnippetAtRevision(revision, "main.go", 3, 4); snippet != expected.Contents+"\n" {
+		t.Errorf("Expected the snippet to be the TODO, but saw %q", snippet)
+	}
+
+	// A recent walk of the directory is reused, even if a file changed since.
+	writeFile("docs/notes.txt", "TODO: write the notes\n")
+	if cachedRevision := repository.ListBranches()[0].Revision; cachedRevision != revision {
+		t.Errorf("Expected the recent revision %s to be reused, but saw %s", revision, cachedRevision)
+	}
+
+	// Once the directory is walked again, the changed file should change the revision,
+	// and retire the old one.
+	repository.invalidateSnapshot()
+	newRevision := repository.ListBranches()[0].Revision
+	if newRevision == revision {
+		t.Errorf("Expected the revision to change along with the contents")
+	}
+	if _, err := repository.ValidateRevision(string(revision)); err == nil {
+		t.Errorf("Expected the old revision %s to be rejected", revision)
+	}
+	if todos := repository.LoadRevisionTodos(newRevision, testTodoRegex, ""); len(todos) != 2 {
+		t.Errorf("Expected both TODOs, but saw %v", todos)
+	}
+
+	excluding := NewDirRepository(dir, testTodoRegex, "^docs/")
+	if todos := excluding.LoadRevisionTodos(newRevision, testTodoRegex, "^docs/"); len(todos) != 1 {
+		t.Errorf("Expected the excluded path to be skipped, but saw %v", todos)
+	}
+
+	// Only the current revision, and its files, are kept in the caches.
+	if err := os.Remove(filepath.Join(dir, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	repository.invalidateSnapshot()
+	lastRevision := repository.ListBranches()[0].Revision
+	for _, cached := range []Revision{revision, newRevision} {
+		if _, ok := repository.LoadCachedRevisionTodos(cached); ok {
+			t.Errorf("Expected the TODOs of the old revision %s to be dropped", cached)
+		}
+	}
+	if _, ok := repository.FileHashCache.Load("main.go"); ok {
+		t.Errorf("Expected the hash of the removed file to be dropped")
+	}
+	if todos := repository.LoadRevisionTodos(lastRevision, testTodoRegex, ""); len(todos) != 1 {
+		t.Errorf("Expected the remaining TODO, but saw %v", todos)
+	}
+}
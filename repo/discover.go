@@ -0,0 +1,155 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The git directories of a checkout or a bare repository.
+type GitDirs struct {
+	// The directory holding the checkout's HEAD and index. This is the ".git"
+	// directory of a plain checkout, the directory named by the ".git" file of a
+	// submodule or a linked worktree, and the repository itself if it is bare.
+	GitDir string
+	// The directory holding the objects and refs, which all of the worktrees of a
+	// repository share.
+	CommonDir string
+	// Whether the repository is bare, so it has no working directory.
+	Bare bool
+}
+
+// Report whether the given directory is laid out as a git directory, with a HEAD
+// file and directories for objects and refs.
+func isGitDir(dirPath string) bool {
+	if info, err := os.Stat(filepath.Join(dirPath, "HEAD")); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	for _, name := range []string{"objects", "refs"} {
+		if info, err := os.Stat(filepath.Join(dirPath, name)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+// Read the git directories of the checkout in the given directory, if it has a ".git"
+// directory, or a ".git" file containing "gitdir: <path>". A directory without either
+// is read as a bare repository if it is laid out as a git directory, as mirrors are.
+func ReadGitDirs(dirPath string) (GitDirs, bool) {
+	dotGitPath := filepath.Join(dirPath, ".git")
+	info, err := os.Stat(dotGitPath)
+	if err != nil {
+		if !isGitDir(dirPath) {
+			return GitDirs{}, false
+		}
+		return GitDirs{filepath.Clean(dirPath), filepath.Clean(dirPath), true}, true
+	}
+	gitDir := dotGitPath
+	if !info.IsDir() {
+		contents, err := os.ReadFile(dotGitPath)
+		if err != nil {
+			return GitDirs{}, false
+		}
+		target, ok := strings.CutPrefix(strings.TrimSpace(string(contents)), "gitdir:")
+		if !ok {
+			return GitDirs{}, false
+		}
+		gitDir = resolveRelativePath(dirPath, strings.TrimSpace(target))
+		if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+			return GitDirs{}, false
+		}
+	}
+	commonDir := gitDir
+	if contents, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = resolveRelativePath(gitDir, strings.TrimSpace(string(contents)))
+	}
+	return GitDirs{filepath.Clean(gitDir), filepath.Clean(commonDir), false}, true
+}
+
+func resolveRelativePath(basePath, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(basePath, path)
+}
+
+// The paths of the submodules declared in the ".gitmodules" file of a checkout, relative
+// to the checkout.
+var submodulePathRegexp = regexp.MustCompile(`(?m)^\s*path\s*=\s*(.+?)\s*$`)
+
+func readSubmodulePaths(checkoutPath string) []string {
+	contents, err := os.ReadFile(filepath.Join(checkoutPath, ".gitmodules"))
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, match := range submodulePathRegexp.FindAllStringSubmatch(string(contents), -1) {
+		paths = append(paths, filepath.FromSlash(strings.Trim(match[1], `"`)))
+	}
+	return paths
+}
+
+// Find the repositories in the given directory and its subdirectories, including the
+// submodules of checkouts and bare repositories. The walk stops at each repository,
+// so the contents of checkouts are not walked, and their submodules are found from
+// their ".gitmodules" files instead. Worktrees sharing a repository are listed once,
+// as the main worktree or the bare repository if it is found, and otherwise as the
+// first one found. The paths are sorted.
+func FindRepositories(rootPath string) []string {
+	repositories := make(map[string]string)
+	var addRepository func(path string, dirs GitDirs)
+	addRepository = func(path string, dirs GitDirs) {
+		if _, seen := repositories[dirs.CommonDir]; !seen || dirs.GitDir == dirs.CommonDir {
+			repositories[dirs.CommonDir] = path
+		}
+		if dirs.Bare {
+			return
+		}
+		for _, submodulePath := range readSubmodulePaths(path) {
+			submodulePath = filepath.Join(path, submodulePath)
+			if submoduleDirs, ok := ReadGitDirs(submodulePath); ok {
+				addRepository(submodulePath, submoduleDirs)
+			}
+		}
+	}
+	filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || !entry.IsDir() {
+			return nil
+		}
+		if entry.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		dirs, ok := ReadGitDirs(path)
+		if !ok {
+			return nil
+		}
+		addRepository(path, dirs)
+		return filepath.SkipDir
+	})
+	paths := make([]string, 0)
+	for _, path := range repositories {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
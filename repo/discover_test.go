@@ -0,0 +1,93 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func TestFindRepositories(t *testing.T) {
+	libFixture := repotest.NewFixture(t)
+	libFixture.Commit("master", "Add the library", map[string]string{"lib.go": "package lib\n"})
+	projectFixture := repotest.NewFixture(t)
+	projectFixture.Commit("master", "Add the main file", map[string]string{"main.go": "package main\n"})
+	projectFixture.AddSubmodule("master", "Add the library", "third_party/lib", libFixture)
+	projectFixture.Branch("feature", "master")
+
+	// Move the project into the searched directory, and give it a linked worktree that
+	// is found before the main one.
+	rootPath := t.TempDir()
+	projectPath := filepath.Join(rootPath, "project")
+	if err := os.Rename(projectFixture.Dir, projectPath); err != nil {
+		t.Fatal(err)
+	}
+	projectFixture.Dir = projectPath
+	worktreePath := filepath.Join(rootPath, "a-worktree")
+	projectFixture.Git("worktree", "add", "-q", worktreePath, "feature")
+	mirrorPath := filepath.Join(rootPath, "mirrors", "lib.git")
+	libFixture.Mirror(mirrorPath)
+	plainPath := filepath.Join(rootPath, "plain")
+	if err := os.Mkdir(plainPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// A clone within a checkout that is not one of its submodules is not walked into.
+	ignoredFixture := repotest.NewFixture(t)
+	ignoredFixture.Commit("master", "Add a dependency", map[string]string{"index.js": "\n"})
+	if err := os.MkdirAll(filepath.Join(projectPath, "node_modules"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(ignoredFixture.Dir, filepath.Join(projectPath, "node_modules", "dep")); err != nil {
+		t.Fatal(err)
+	}
+
+	submodulePath := filepath.Join(projectPath, "third_party", "lib")
+	expected := []string{mirrorPath, projectPath, submodulePath}
+	if repositories := repo.FindRepositories(rootPath); !reflect.DeepEqual(repositories, expected) {
+		t.Errorf("Expected the repositories %v, but saw %v", expected, repositories)
+	}
+
+	projectGitDir := filepath.Join(projectPath, ".git")
+	for _, test := range []struct {
+		path     string
+		expected repo.GitDirs
+	}{
+		{projectPath, repo.GitDirs{GitDir: projectGitDir, CommonDir: projectGitDir}},
+		{worktreePath, repo.GitDirs{
+			GitDir:    filepath.Join(projectGitDir, "worktrees", "a-worktree"),
+			CommonDir: projectGitDir,
+		}},
+		{submodulePath, repo.GitDirs{
+			GitDir:    filepath.Join(projectGitDir, "modules", "third_party", "lib"),
+			CommonDir: filepath.Join(projectGitDir, "modules", "third_party", "lib"),
+		}},
+		{mirrorPath, repo.GitDirs{GitDir: mirrorPath, CommonDir: mirrorPath, Bare: true}},
+	} {
+		if dirs, ok := repo.ReadGitDirs(test.path); !ok || dirs != test.expected {
+			t.Errorf("Expected the git directories of %s to be %v, but saw %v", test.path, test.expected, dirs)
+		}
+	}
+	if dirs, ok := repo.ReadGitDirs(plainPath); ok {
+		t.Errorf("Expected %s not to be a checkout, but saw %v", plainPath, dirs)
+	}
+}
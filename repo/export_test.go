@@ -0,0 +1,30 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+// Create repositories for the tests in the repo_test package, without pre-loading
+// their TODOs in the background, which could outlive the tests' directories.
+
+func NewGitRepositoryForTest(dirPath, refPatterns string) (Repository, error) {
+	return newGitRepository(dirPath, refPatterns), nil
+}
+
+func NewGoGitRepositoryForTest(dirPath, refPatterns string) (Repository, error) {
+	return newGoGitRepository(dirPath, refPatterns)
+}
+
+var ParseBlameOutputOrDie = parseBlameOutputOrDie
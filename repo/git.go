@@ -18,6 +18,7 @@ package repo
 
 import (
 	"bytes"
+	"container/list"
 	"crypto/sha1"
 	"errors"
 	"fmt"
@@ -28,35 +29,80 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/google/todo-tracks/metrics"
 )
 
 const (
 	hashFormat      = "^([[:xdigit:]]){40}$"
 	maxCacheEntries = 1000
+	// The fields of each branch listed by "git for-each-ref", separated by NUL characters.
+	branchRefFormat = "%(HEAD)%00%(refname)%00%(symref)" +
+		"%00%(objecttype)%00%(objectname)%00%(committerdate:unix)%00%(authorname)" +
+		"%00%(*objecttype)%00%(*objectname)%00%(*committerdate:unix)%00%(*authorname)"
+	// The fields of each default branch candidate listed by "git for-each-ref".
+	defaultBranchFormat = "%(refname)%00%(symref)%00%(objecttype)%00%(objectname)"
+	// The refs that are listed when no ref patterns are configured.
+	DefaultRefPatterns = "refs/heads/,refs/remotes/"
 )
 
+// The refs that may be the default branch, in order of preference. The refs ending in
+// HEAD only count if they point to a branch.
+var defaultBranchCandidates = []string{
+	"refs/remotes/origin/HEAD", "refs/heads/main", "refs/heads/master", "HEAD"}
+
 var hashRegexp *regexp.Regexp
 
+var gitCommandsCounter = metrics.NewCounterVec(
+	"todos_git_commands_total",
+	"Number of git subprocesses spawned, by git subcommand.",
+	"command")
+var cacheLookupsCounter = metrics.NewCounterVec(
+	"todos_cache_lookups_total",
+	"Number of TODO cache lookups, by cache and result (hit or miss).",
+	"cache", "result")
+var revisionScanDuration = metrics.NewHistogramVec(
+	"todos_revision_scan_duration_seconds",
+	"Time taken to scan a revision for TODOs when it is not already cached.",
+	metrics.DefaultDurationBuckets, "repo")
+
 func init() {
 	var err error
 	hashRegexp, err = regexp.Compile(hashFormat)
 	if err != nil {
 		log.Fatal(err)
 	}
+	metrics.Register(gitCommandsCounter, cacheLookupsCounter, revisionScanDuration)
 }
 
-type gitRepository struct {
-	DirPath            string
-	BlobTodosCache     *sync.Map
-	RevisionTodosCache *sync.Map
+func recordCacheLookup(cache string, hit bool) {
+	if hit {
+		cacheLookupsCounter.Inc(cache, "hit")
+	} else {
+		cacheLookupsCounter.Inc(cache, "miss")
+	}
 }
 
-func NewGitRepository(dirPath, todoRegex, excludePaths string) Repository {
-	repository := &gitRepository{
-		DirPath:            dirPath,
-		BlobTodosCache:     &sync.Map{},
-		RevisionTodosCache: &sync.Map{},
-	}
+type gitRepository struct {
+	DirPath               string
+	BlobTodosCache        *sync.Map
+	RevisionTodosCache    *sync.Map
+	RevisionMetadataCache *sync.Map
+	AheadBehindCache      *sync.Map
+	TreeCache             *treeCache
+	// The patterns of the refs to list, as accepted by "git for-each-ref".
+	RefPatterns []string
+
+	aheadBehindOnce      sync.Once
+	aheadBehindSupported bool
+}
+
+// Create a repository for the git checkout in the given directory. The refs to list
+// are given as a comma-separated list of "git for-each-ref" patterns, such as
+// "refs/tags/v*", or DefaultRefPatterns if empty.
+func NewGitRepository(dirPath, todoRegex, excludePaths, refPatterns string) Repository {
+	repository := newGitRepository(dirPath, refPatterns)
 	go func() {
 		// Pre-load all of the TODOs for the current branches
 		for _, alias := range repository.ListBranches() {
@@ -66,6 +112,21 @@ func NewGitRepository(dirPath, todoRegex, excludePaths string) Repository {
 	return repository
 }
 
+func newGitRepository(dirPath, refPatterns string) *gitRepository {
+	if refPatterns == "" {
+		refPatterns = DefaultRefPatterns
+	}
+	return &gitRepository{
+		DirPath:               dirPath,
+		RefPatterns:           strings.Split(refPatterns, ","),
+		BlobTodosCache:        &sync.Map{},
+		RevisionTodosCache:    &sync.Map{},
+		RevisionMetadataCache: &sync.Map{},
+		AheadBehindCache:      &sync.Map{},
+		TreeCache:             newTreeCache(treeCacheSize),
+	}
+}
+
 func (repository *gitRepository) GetRepoId() string {
 	return fmt.Sprintf("%x", sha1.Sum([]byte(repository.DirPath)))
 }
@@ -74,9 +135,21 @@ func (repository *gitRepository) GetRepoPath() string {
 	return repository.DirPath
 }
 
-func (repository *gitRepository) runGitCommand(cmd *exec.Cmd) (string, error) {
+func (repository *gitRepository) RevisionsAreImmutable() bool {
+	return true
+}
+
+// Run the given git command in the repository's directory, and record it in the metrics.
+func (repository *gitRepository) runCommand(cmd *exec.Cmd) ([]byte, error) {
 	cmd.Dir = repository.DirPath
-	out, err := cmd.Output()
+	if len(cmd.Args) > 1 {
+		gitCommandsCounter.Inc(cmd.Args[1])
+	}
+	return cmd.Output()
+}
+
+func (repository *gitRepository) runGitCommand(cmd *exec.Cmd) (string, error) {
+	out, err := repository.runCommand(cmd)
 	if err != nil {
 		return "", err
 	}
@@ -84,8 +157,7 @@ func (repository *gitRepository) runGitCommand(cmd *exec.Cmd) (string, error) {
 }
 
 func (repository *gitRepository) runGitCommandWithoutTrim(cmd *exec.Cmd) (string, error) {
-	cmd.Dir = repository.DirPath
-	out, err := cmd.Output()
+	out, err := repository.runCommand(cmd)
 	if err != nil {
 		return "", err
 	}
@@ -112,28 +184,172 @@ func (repository *gitRepository) runGitCommandWithoutTrimOrDie(cmd *exec.Cmd) st
 	return out
 }
 
-func splitCommandOutputLine(line string) []string {
-	lineParts := make([]string, 0)
-	for _, part := range strings.Split(line, " ") {
-		if part != "" {
-			lineParts = append(lineParts, part)
+// Get the name and type of an alias for the given full ref name. Branches are named
+// as in the output of "git branch --all", and tags are prefixed with "tags/".
+func refAliasNameAndType(refName string) (string, string) {
+	if strings.HasPrefix(refName, "refs/heads/") {
+		return strings.TrimPrefix(refName, "refs/heads/"), BranchRef
+	} else if strings.HasPrefix(refName, "refs/remotes/") {
+		return strings.TrimPrefix(refName, "refs/"), RemoteRef
+	} else if strings.HasPrefix(refName, "refs/tags/") {
+		return strings.TrimPrefix(refName, "refs/"), TagRef
+	}
+	return refName, OtherRef
+}
+
+// Parse the output of "git for-each-ref" run with branchRefFormat, optionally followed
+// by the ahead-behind atom.
+func parseBranchRefs(out string) []Alias {
+	aliases := make([]Alias, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) < 11 || fields[2] != "" {
+			// Skip symbolic refs, such as "origin/HEAD", and anything unexpected.
+			continue
+		}
+		// Annotated tags are peeled to the commits they point to, and refs that
+		// do not point to commits, such as tags of trees, are skipped.
+		commitFields := fields[3:7]
+		if fields[3] != "commit" {
+			commitFields = fields[7:11]
 		}
+		if commitFields[0] != "commit" || !hashRegexp.MatchString(commitFields[1]) {
+			continue
+		}
+		name, refType := refAliasNameAndType(fields[1])
+		lastModified, _ := strconv.ParseInt(commitFields[2], 10, 64)
+		alias := Alias{
+			Branch:         name,
+			Revision:       Revision(commitFields[1]),
+			Type:           refType,
+			LastModified:   lastModified,
+			LastModifiedBy: strings.TrimSpace(commitFields[3]),
+		}
+		if len(fields) > 11 {
+			fmt.Sscanf(fields[11], "%d %d", &alias.Ahead, &alias.Behind)
+		}
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
+
+// Find the full ref name and revision of the repository's default branch, which the
+// other branches are compared with. This is the branch that origin/HEAD points to, or
+// else main or master, or else the checked-out branch, which for a bare mirror is the
+// remote's default branch.
+//
+// All but the last of the candidates are read with a single "git for-each-ref", so
+// listing the branches only runs more git commands when none of them exist.
+func (repository *gitRepository) readDefaultBranch() (string, Revision) {
+	var listedCandidates []string
+	for _, candidate := range defaultBranchCandidates {
+		if strings.HasPrefix(candidate, "refs/") {
+			listedCandidates = append(listedCandidates, candidate)
+		}
+	}
+	args := append([]string{"for-each-ref", "--format=" + defaultBranchFormat}, listedCandidates...)
+	out, err := repository.runGitCommand(exec.Command("git", args...))
+	if err == nil {
+		if refName, revision := parseDefaultBranch(out); refName != "" {
+			return refName, revision
+		}
+	}
+	target, err := repository.runGitCommand(exec.Command("git", "symbolic-ref", "-q", "HEAD"))
+	if err != nil || !strings.HasPrefix(target, "refs/") {
+		return "", ""
+	}
+	out, err = repository.runGitCommand(exec.Command(
+		"git", "rev-parse", "--verify", "--quiet", target+"^{commit}"))
+	if err != nil || !hashRegexp.MatchString(out) {
+		return "", ""
+	}
+	return target, Revision(out)
+}
+
+// Pick the first of the default branch candidates from the output of "git for-each-ref"
+// run with defaultBranchFormat. Symbolic refs are replaced by the refs they point to.
+func parseDefaultBranch(out string) (string, Revision) {
+	listedRefs := make(map[string][]string)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x00")
+		if len(fields) == 4 {
+			listedRefs[fields[0]] = fields[1:]
+		}
+	}
+	for _, candidate := range defaultBranchCandidates {
+		fields, ok := listedRefs[candidate]
+		if !ok || fields[1] != "commit" || !hashRegexp.MatchString(fields[2]) {
+			continue
+		}
+		refName := candidate
+		if strings.HasSuffix(candidate, "HEAD") {
+			if !strings.HasPrefix(fields[0], "refs/") {
+				continue
+			}
+			refName = fields[0]
+		}
+		return refName, Revision(fields[2])
+	}
+	return "", ""
+}
+
+// Report whether the installed git supports the ahead-behind atom of for-each-ref,
+// which was added in git 2.41.
+func (repository *gitRepository) supportsAheadBehind() bool {
+	repository.aheadBehindOnce.Do(func() {
+		_, err := repository.runGitCommand(exec.Command(
+			"git", "for-each-ref", "--count=1", "--format=%(ahead-behind:HEAD)", "refs/heads/"))
+		repository.aheadBehindSupported = err == nil
+	})
+	return repository.aheadBehindSupported
+}
+
+// Count the commits in the branch that are not in the default branch, and vice versa,
+// for versions of git without the ahead-behind atom. The counts are cached, so this only
+// runs git for branches that moved.
+func (repository *gitRepository) countAheadBehind(baseRevision, revision Revision) (int, int) {
+	key := [2]Revision{baseRevision, revision}
+	cachedCounts, ok := repository.AheadBehindCache.Load(key)
+	recordCacheLookup("ahead_behind", ok)
+	if ok {
+		counts := cachedCounts.([2]int)
+		return counts[0], counts[1]
 	}
-	return lineParts
+	out, err := repository.runGitCommand(exec.Command(
+		"git", "rev-list", "--left-right", "--count", string(baseRevision)+"..."+string(revision)))
+	if err != nil {
+		return 0, 0
+	}
+	var ahead, behind int
+	fmt.Sscanf(out, "%d %d", &behind, &ahead)
+	repository.AheadBehindCache.Store(key, [2]int{ahead, behind})
+	return ahead, behind
 }
 
+// List the refs matching the repository's ref patterns, along with the metadata of
+// their last commits, using a single "git for-each-ref" command.
 func (repository *gitRepository) ListBranches() []Alias {
-	out := repository.runGitCommandOrDie(
-		exec.Command("git", "branch", "-av", "--list", "--abbrev=40", "--no-color"))
-	lines := strings.Split(out, "\n")
-	aliases := make([]Alias, 0)
-	for _, line := range lines {
-		line = strings.Trim(line, "* ")
-		lineParts := splitCommandOutputLine(line)
-		if len(lineParts) >= 2 && len(lineParts[1]) == 40 {
-			branch := lineParts[0]
-			revision := Revision(lineParts[1])
-			aliases = append(aliases, Alias{branch, revision})
+	format := branchRefFormat
+	baseRefName, baseRevision := repository.readDefaultBranch()
+	supportsAheadBehind := baseRefName != "" && repository.supportsAheadBehind()
+	if supportsAheadBehind {
+		format += "%00%(ahead-behind:" + baseRefName + ")"
+	}
+	base, _ := refAliasNameAndType(baseRefName)
+	args := append([]string{"for-each-ref", "--format=" + format}, repository.RefPatterns...)
+	out := repository.runGitCommandOrDie(exec.Command("git", args...))
+	aliases := parseBranchRefs(out)
+	for i := range aliases {
+		alias := &aliases[i]
+		alias.Base = base
+		if baseRefName == "" {
+			alias.Ahead, alias.Behind = 0, 0
+		} else if !supportsAheadBehind {
+			alias.Ahead, alias.Behind = repository.countAheadBehind(baseRevision, alias.Revision)
+		}
+		alias.TodoCount = -1
+		if cachedTodos, ok := repository.RevisionTodosCache.Load(alias.Revision); ok {
+			alias.TodoCount = len(cachedTodos.([]Line))
 		}
 	}
 	return aliases
@@ -146,16 +362,40 @@ func (repository *gitRepository) IsAncestor(ancestor, descendant Revision) bool
 	return err == nil
 }
 
+func (repository *gitRepository) ReadFirstParentHistory(revision Revision, maxCount int) []Revision {
+	if IsUncommitted(revision) {
+		// Uncommitted changes are made on top of HEAD, which may not exist yet.
+		history := []Revision{revision}
+		if _, err := repository.ValidateRevision("HEAD"); err == nil && maxCount > 1 {
+			history = append(history, repository.ReadFirstParentHistory("HEAD", maxCount-1)...)
+		}
+		return history
+	}
+	out := repository.runGitCommandOrDie(exec.Command(
+		"git", "rev-list", "--first-parent", fmt.Sprintf("--max-count=%d", maxCount),
+		string(revision)))
+	revisions := make([]Revision, 0)
+	for _, line := range strings.Split(out, "\n") {
+		if line != "" {
+			revisions = append(revisions, Revision(line))
+		}
+	}
+	return revisions
+}
+
 func (repository *gitRepository) ReadRevisionContents(revision Revision) *RevisionContents {
-	out := repository.runGitCommandOrDie(exec.Command("git", "ls-tree", "-r", string(revision)))
-	lines := strings.Split(out, "\n")
-	paths := make([]string, 0)
-	for _, line := range lines {
-		line = strings.Replace(line, "\t", " ", -1)
-		lineParts := strings.SplitN(line, " ", 4)
-		paths = append(paths, lineParts[len(lineParts)-1])
+	if IsUncommitted(revision) {
+		return &RevisionContents{revision, repository.readUncommittedPaths(revision), nil}
+	}
+	contents := &RevisionContents{Revision: revision, Paths: make([]string, 0)}
+	for _, entry := range repository.readTreeOrDie(revision).entries {
+		if entry.Type == "commit" {
+			contents.Submodules = append(contents.Submodules, Submodule{entry.Path, Revision(entry.Object)})
+		} else {
+			contents.Paths = append(contents.Paths, entry.Path)
+		}
 	}
-	return &RevisionContents{revision, paths}
+	return contents
 }
 
 func (repository *gitRepository) getSubject(revision Revision) string {
@@ -184,26 +424,133 @@ func (repository *gitRepository) getTimestamp(revision Revision) int64 {
 }
 
 func (repository *gitRepository) ReadRevisionMetadata(revision Revision) RevisionMetadata {
-	return RevisionMetadata{
+	if IsUncommitted(revision) {
+		return repository.readUncommittedMetadata(revision)
+	}
+	cachedMetadata, ok := repository.RevisionMetadataCache.Load(revision)
+	recordCacheLookup("revision_metadata", ok)
+	if ok {
+		return cachedMetadata.(RevisionMetadata)
+	}
+	metadata := RevisionMetadata{
 		Revision:    revision,
 		Timestamp:   repository.getTimestamp(revision),
 		Subject:     repository.getSubject(revision),
 		AuthorName:  repository.getAuthorName(revision),
 		AuthorEmail: repository.getAuthorEmail(revision),
 	}
+	repository.RevisionMetadataCache.Store(revision, metadata)
+	return metadata
+}
+
+// The entries in the tree of a revision, along with the blob of each file keyed by path.
+type revisionTree struct {
+	entries []treeEntry
+	blobs   map[string]string
+}
+
+// The number of trees kept by the tree cache. A tree holds an entry for every file in
+// a revision, so only the trees of the few most recently read revisions are kept.
+const treeCacheSize = 16
+
+// A cache of the trees of the most recently read revisions.
+type treeCache struct {
+	mutex    sync.Mutex
+	capacity int
+	// The cached revisions, from the most to the least recently used.
+	order   *list.List
+	entries map[Revision]*list.Element
+}
+
+type treeCacheEntry struct {
+	revision Revision
+	tree     *revisionTree
+}
+
+func newTreeCache(capacity int) *treeCache {
+	return &treeCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[Revision]*list.Element),
+	}
+}
+
+func (cache *treeCache) Load(revision Revision) (*revisionTree, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.entries[revision]
+	if !ok {
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	return element.Value.(treeCacheEntry).tree, true
+}
+
+// Store the tree of a revision, evicting the least recently used tree if the cache is full.
+func (cache *treeCache) Store(revision Revision, tree *revisionTree) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.entries[revision]; ok {
+		element.Value = treeCacheEntry{revision, tree}
+		cache.order.MoveToFront(element)
+		return
+	}
+	cache.entries[revision] = cache.order.PushFront(treeCacheEntry{revision, tree})
+	if cache.order.Len() > cache.capacity {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.entries, oldest.Value.(treeCacheEntry).revision)
+	}
+}
+
+// Read the tree of a revision, recursing into subtrees. Since the tree of a commit
+// never changes, this is cached for revisions given as full hashes, so that every
+// lookup of a path in a recently read revision shares a single "git ls-tree".
+func (repository *gitRepository) readTree(revision Revision) (*revisionTree, error) {
+	cacheable := hashRegexp.MatchString(string(revision))
+	if cacheable {
+		cachedTree, ok := repository.TreeCache.Load(revision)
+		recordCacheLookup("tree", ok)
+		if ok {
+			return cachedTree, nil
+		}
+	}
+	out, err := repository.runGitCommandWithoutTrim(
+		exec.Command("git", "ls-tree", "-r", "-z", string(revision)))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parseTreeEntries(out)
+	if err != nil {
+		return nil, err
+	}
+	tree := &revisionTree{entries, make(map[string]string)}
+	for _, entry := range entries {
+		if entry.Type == "blob" {
+			tree.blobs[entry.Path] = entry.Object
+		}
+	}
+	if cacheable {
+		repository.TreeCache.Store(revision, tree)
+	}
+	return tree, nil
+}
+
+func (repository *gitRepository) readTreeOrDie(revision Revision) *revisionTree {
+	tree, err := repository.readTree(revision)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return tree
 }
 
 func (repository *gitRepository) getFileBlob(revision Revision, path string) (string, error) {
-	out, err := repository.runGitCommand(exec.Command("git", "ls-tree", "-r", string(revision)))
+	tree, err := repository.readTree(revision)
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(out, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, path) {
-			lineParts := strings.Split(strings.Replace(line, "\t", " ", -1), " ")
-			return lineParts[2], nil
-		}
+	if blob, ok := tree.blobs[path]; ok {
+		return blob, nil
 	}
 	return "", errors.New("Failed to lookup blob hash for " + path)
 }
@@ -216,36 +563,31 @@ func (repository *gitRepository) getFileBlobOrDie(revision Revision, path string
 	return blob
 }
 
-func parseBlameOutputOrDie(fileName string, out string) []Line {
-	result := make([]Line, 0)
-	for out != "" {
-		// First split off the next blame section
-		split := strings.SplitN(out, "\n\t", 2)
-		blame := split[0]
-		// Then split off the source line that goes with that blame section
-		split = strings.SplitN(split[1], "\n", 2)
-		contents := strings.TrimPrefix(split[0], "\t")
-		// And update the out variable to be what is left.
-		if len(split) == 2 {
-			out = split[1]
-		} else {
-			out = ""
-		}
+// Read the contents of a file at the given revision, without any trailing newlines.
+func (repository *gitRepository) readFile(revision Revision, path string) (string, error) {
+	if IsUncommitted(revision) {
+		contents, _ := repository.readUncommittedFile(revision, path)
+		return strings.Trim(contents, " \n"), nil
+	}
+	blob, err := repository.getFileBlob(revision, path)
+	if err != nil {
+		return "", err
+	}
+	return repository.runGitCommand(exec.Command("git", "show", blob))
+}
 
-		// Finally, parse the blame section and add to the result.
-		blameParts := strings.Split(blame, "\n")
-		firstLineParts := strings.Split(blameParts[0], " ")
-		revision := Revision(firstLineParts[0])
-		lineNumber, err := strconv.Atoi(firstLineParts[1])
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, blamePart := range blameParts[1:] {
-			if strings.HasPrefix(blamePart, "filename ") {
-				fileName = strings.SplitN(blamePart, " ", 2)[1]
-			}
-		}
-		result = append(result, Line{revision, fileName, lineNumber, contents})
+func (repository *gitRepository) readFileOrDie(revision Revision, path string) string {
+	contents, err := repository.readFile(revision, path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return contents
+}
+
+func parseBlameOutputOrDie(fileName string, out string) []Line {
+	result, err := parseBlameOutput(fileName, out)
+	if err != nil {
+		log.Fatal(err)
 	}
 	return result
 }
@@ -293,32 +635,101 @@ func (repository *gitRepository) loadRevisionPaths(revision Revision, excludePat
 
 func (repository *gitRepository) asyncLoadRevisionTodos(
 	revision Revision, todoRegex, excludePaths string, todosChannel chan []Line) {
+	if IsUncommitted(revision) {
+		todosChannel <- repository.scanUncommittedTodos(revision, todoRegex, excludePaths, nil)
+		return
+	}
+	todos, ok := repository.loadCachedRevisionTodos(revision)
+	if !ok {
+		todos = repository.scanRevisionTodos(revision, todoRegex, excludePaths, nil)
+	}
+	todosChannel <- todos
+}
+
+func (repository *gitRepository) loadCachedRevisionTodos(revision Revision) ([]Line, bool) {
 	var todos []Line
 	cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
 	if ok {
 		todos, ok = cachedTodos.([]Line)
 	}
+	recordCacheLookup("revision_todos", ok)
+	return todos, ok
+}
+
+func (repository *gitRepository) LoadCachedRevisionTodos(revision Revision) ([]Line, bool) {
+	cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
 	if !ok {
-		revisionPaths := repository.loadRevisionPaths(revision, excludePaths)
-		todoChannels := make([]chan []Line, 0)
-		for _, path := range revisionPaths {
-			blob := repository.getFileBlobOrDie(revision, path)
+		return nil, false
+	}
+	return cachedTodos.([]Line), true
+}
+
+// Scan every file in the revision for TODOs, and cache the result. If the progress
+// channel is not nil, the TODOs of each file are sent on it as soon as that file is
+// scanned, which may not be in the order of the paths.
+func (repository *gitRepository) scanRevisionTodos(
+	revision Revision, todoRegex, excludePaths string, progress chan<- ScanProgress) []Line {
+	start := time.Now()
+	revisionPaths := repository.loadRevisionPaths(revision, excludePaths)
+	type fileTodos struct {
+		index int
+		todos []Line
+	}
+	results := make(chan fileTodos, len(revisionPaths))
+	for i, path := range revisionPaths {
+		blob := repository.getFileBlobOrDie(revision, path)
+		go func(index int, path, blob string) {
 			channel := make(chan []Line, 1)
-			todoChannels = append(todoChannels, channel)
-			go repository.asyncLoadFileTodos(revision, path, blob, todoRegex, channel)
-		}
-		for _, channel := range todoChannels {
-			pathTodos := <-channel
-			todos = append(todos, pathTodos...)
+			repository.asyncLoadFileTodos(revision, path, blob, todoRegex, channel)
+			results <- fileTodos{index, <-channel}
+		}(i, path, blob)
+	}
+	todosByPath := make([][]Line, len(revisionPaths))
+	for done := 1; done <= len(revisionPaths); done++ {
+		result := <-results
+		todosByPath[result.index] = result.todos
+		if progress != nil {
+			progress <- ScanProgress{
+				FileName:   revisionPaths[result.index],
+				Todos:      result.todos,
+				FilesDone:  done,
+				FilesTotal: len(revisionPaths),
+			}
 		}
-		// TODO: Consider grouping the TODOs based on the containing file.
-		repository.RevisionTodosCache.Store(revision, todos)
 	}
-	todosChannel <- todos
+	var todos []Line
+	for _, pathTodos := range todosByPath {
+		todos = append(todos, pathTodos...)
+	}
+	// TODO: Consider grouping the TODOs based on the containing file.
+	repository.RevisionTodosCache.Store(revision, todos)
+	revisionScanDuration.ObserveSince(start, repository.DirPath)
+	return todos
+}
+
+func (repository *gitRepository) StreamRevisionTodos(
+	revision Revision, todoRegex, excludePaths string) <-chan ScanProgress {
+	progress := make(chan ScanProgress)
+	go func() {
+		defer close(progress)
+		if IsUncommitted(revision) {
+			repository.scanUncommittedTodos(revision, todoRegex, excludePaths, progress)
+			return
+		}
+		if todos, ok := repository.loadCachedRevisionTodos(revision); ok {
+			progress <- ScanProgress{Todos: todos, FilesDone: 1, FilesTotal: 1}
+			return
+		}
+		repository.scanRevisionTodos(revision, todoRegex, excludePaths, progress)
+	}()
+	return progress
 }
 
 func (repository *gitRepository) LoadFileTodos(
 	revision Revision, path string, todoRegex string) []Line {
+	if IsUncommitted(revision) {
+		return repository.loadUncommittedFileTodos(revision, path, todoRegex)
+	}
 	blob := repository.getFileBlobOrDie(revision, path)
 	todosChannel := make(chan []Line, 1)
 	go repository.asyncLoadFileTodos(revision, path, blob, todoRegex, todosChannel)
@@ -332,6 +743,7 @@ func (repository *gitRepository) asyncLoadFileTodos(
 	if ok {
 		blobTodos, ok = cachedTodos.([]Line)
 	}
+	recordCacheLookup("blob_todos", ok)
 	if !ok {
 		raw := repository.runGitCommandWithoutTrimOrDie(exec.Command("git", "show", blob))
 		rawLines := strings.Split(raw, "\n")
@@ -340,7 +752,7 @@ func (repository *gitRepository) asyncLoadFileTodos(
 			if err == nil && matched {
 				// git-blame numbers lines starting from 1 rather than 0
 				gitLineNumber := lineNumber + 1
-				out := repository.runGitCommandOrDie(exec.Command(
+				out := repository.runGitCommandWithoutTrimOrDie(exec.Command(
 					"git", "blame", "--root", "--line-porcelain",
 					"-L", fmt.Sprintf("%d,+1", gitLineNumber),
 					string(revision), "--", path))
@@ -353,8 +765,7 @@ func (repository *gitRepository) asyncLoadFileTodos(
 }
 
 func (repository *gitRepository) ReadFileSnippetAtRevision(revision Revision, path string, startLine, endLine int) string {
-	blob := repository.getFileBlobOrDie(revision, path)
-	out := repository.runGitCommandOrDie(exec.Command("git", "show", blob))
+	out := repository.readFileOrDie(revision, path)
 	lines := strings.Split(out, "\n")
 	if startLine < 1 {
 		startLine = 1
@@ -374,36 +785,74 @@ func (repository *gitRepository) ReadFileSnippetAtRevision(revision Revision, pa
 	return buffer.String()
 }
 
-func (repository *gitRepository) readTodoContents(todoId TodoId) string {
-	blob := repository.getFileBlobOrDie(todoId.Revision, todoId.FileName)
-	out := repository.runGitCommandOrDie(exec.Command("git", "show", blob))
-	lines := strings.Split(out, "\n")
-	return lines[todoId.LineNumber-1]
+func (repository *gitRepository) GrepRevision(revision Revision, words []string) ([]string, error) {
+	lines := make([]string, 0)
+	if len(words) == 0 {
+		return lines, nil
+	}
+	if IsUncommitted(revision) {
+		for _, path := range repository.readUncommittedPaths(revision) {
+			// Skip the files that were changed or removed since they were listed.
+			if contents, err := repository.readUncommittedFile(revision, path); err == nil {
+				lines = append(lines, GrepLines(contents, words)...)
+			}
+		}
+		return lines, nil
+	}
+	args := []string{"grep", "-I", "-h", "-F", "--no-color", "--no-line-number", "--no-column"}
+	for _, word := range words {
+		args = append(args, "-e", word)
+	}
+	args = append(args, string(revision), "--")
+	out, err := repository.runGitCommandWithoutTrim(exec.Command("git", args...))
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		// This is how git grep reports that nothing matched.
+		return lines, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitGrepOutput(out), nil
+}
+
+func (repository *gitRepository) readTodoContents(todoId TodoId) (string, error) {
+	out, err := repository.readFile(todoId.Revision, todoId.FileName)
+	if err != nil {
+		return "", err
+	}
+	return todoLineContents(todoId, out)
 }
 
-func (repository *gitRepository) FindClosingRevisions(todoId TodoId) []Revision {
+func (repository *gitRepository) FindClosingRevisions(todoId TodoId) ([]Revision, error) {
 	results := make([]Revision, 0)
-	contents := repository.readTodoContents(todoId)
-	args := []string{"log", "--pretty=oneline", "--no-abbrev-commit", "--no-color", fmt.Sprintf("-S%s", contents), "^" + string(todoId.Revision)}
+	if IsUncommitted(todoId.Revision) {
+		// A TODO that has not been committed cannot have been removed by a commit.
+		return results, nil
+	}
+	contents, err := repository.readTodoContents(todoId)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"log", "-z", "--format=%H", "--no-color", fmt.Sprintf("-S%s", contents), "^" + string(todoId.Revision)}
 	for _, alias := range repository.ListBranches() {
 		if alias.Revision != todoId.Revision {
 			args = append(args, string(alias.Revision))
 		}
 	}
-	out := repository.runGitCommandOrDie(exec.Command("git", args...))
-	lines := strings.Split(out, "\n")
-	for _, entry := range lines {
-		if len(entry) > 40 {
-			revision := Revision(strings.Split(entry, " ")[0])
-			raw := repository.runGitCommandOrDie(exec.Command(
-				"git", "show", "--no-color", string(revision)))
-			// TODO(ojarjur): Exclude revisions that are later rolled back.
-			if strings.Contains(raw, "-"+contents) && !strings.Contains(raw, "+"+contents) {
-				results = append(results, revision)
-			}
+	out := repository.runGitCommandWithoutTrimOrDie(exec.Command("git", args...))
+	revisions, err := parseRevisions(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, revision := range revisions {
+		raw := repository.runGitCommandOrDie(exec.Command(
+			"git", "show", "--no-color", string(revision)))
+		// TODO(ojarjur): Exclude revisions that are later rolled back.
+		if strings.Contains(raw, "-"+contents) && !strings.Contains(raw, "+"+contents) {
+			results = append(results, revision)
 		}
 	}
-	return results
+	return results, nil
 }
 
 func isGitHubHttpsUrl(remoteUrl string) bool {
@@ -420,53 +869,77 @@ func gitHubBrowseSuffix(revision Revision, path string, lineNumber int) string {
 	return fmt.Sprintf("/blob/%s/%s#L%d", string(revision), path, lineNumber)
 }
 
+// Build the URL for browsing a file on GitHub, using the first of the given remote URLs
+// that is hosted there.
+func gitHubBrowseUrl(remoteUrls []string, revision Revision, path string, lineNumber int) (string, bool) {
+	for _, remoteUrl := range remoteUrls {
+		if isGitHubHttpsUrl(remoteUrl) {
+			browseSuffix := gitHubBrowseSuffix(revision, path, lineNumber)
+			return strings.TrimSuffix(remoteUrl, ".git") + browseSuffix, true
+		}
+		if isGitHubSshUrl(remoteUrl) {
+			browseSuffix := gitHubBrowseSuffix(revision, path, lineNumber)
+			repoName := strings.SplitN(
+				strings.TrimSuffix(remoteUrl, ".git"),
+				":", 2)[1]
+			return "https://github.com/" + repoName + browseSuffix, true
+		}
+	}
+	return "", false
+}
+
 func (repository *gitRepository) GetBrowseUrl(revision Revision, path string, lineNumber int) string {
 	rawUrl := fmt.Sprintf("/raw?repo=%s&revision=%s&fileName=%s&lineNumber=%d",
 		repository.GetRepoId(), string(revision), url.QueryEscape(path), lineNumber)
-	out, err := repository.runGitCommand(exec.Command("git", "remote", "-v"))
+	if IsUncommitted(revision) {
+		return rawUrl
+	}
+	out, err := repository.runGitCommandWithoutTrim(exec.Command(
+		"git", "config", "-z", "--get-regexp", `^remote\..*\.url$`))
 	if err != nil {
 		return rawUrl
 	}
-	remotes := strings.Split(strings.Trim(string(out), "\n"), "\n")
-	for _, remote := range remotes {
-		remoteParts := strings.SplitN(remote, "\t", 2)
-		if len(remoteParts) == 2 {
-			remoteUrl := strings.Split(remoteParts[1], " ")[0]
-			if isGitHubHttpsUrl(remoteUrl) {
-				browseSuffix := gitHubBrowseSuffix(revision, path, lineNumber)
-				return strings.TrimSuffix(remoteUrl, ".git") + browseSuffix
-			}
-			if isGitHubSshUrl(remoteUrl) {
-				browseSuffix := gitHubBrowseSuffix(revision, path, lineNumber)
-				repoName := strings.SplitN(
-					strings.TrimSuffix(remoteUrl, ".git"),
-					":", 2)[1]
-				return "https://github.com/" + repoName + browseSuffix
-			}
-		}
+	remoteUrls := make([]string, 0)
+	for _, value := range parseConfigValues(out) {
+		remoteUrls = append(remoteUrls, value.Value)
+	}
+	if browseUrl, ok := gitHubBrowseUrl(remoteUrls, revision, path, lineNumber); ok {
+		return browseUrl
 	}
 	return rawUrl
 }
 
 func (repository *gitRepository) ValidateRevision(revisionString string) (Revision, error) {
-	if !hashRegexp.MatchString(revisionString) {
-		return Revision(""), errors.New(fmt.Sprintf("Invalid hash format: %s", revisionString))
+	// Since the revision comes from the user, make sure that git cannot mistake it for
+	// an option, and that it names a commit rather than some other object.
+	if revisionString == "" || strings.HasPrefix(revisionString, "-") ||
+		strings.ContainsAny(revisionString, "\x00\n") {
+		return Revision(""), errors.New(fmt.Sprintf("Invalid revision: %s", revisionString))
 	}
-	_, err := repository.runGitCommand(
-		exec.Command("git", "ls-tree", "--name-only", revisionString))
-	if err != nil {
-		return Revision(""), err
+	if IsUncommitted(Revision(revisionString)) && len(repository.ListUncommitted()) > 0 {
+		return Revision(revisionString), nil
+	}
+	out, err := repository.runGitCommand(exec.Command(
+		"git", "rev-parse", "--verify", "--quiet", "--end-of-options", revisionString+"^{commit}"))
+	if err != nil || !hashRegexp.MatchString(out) {
+		return Revision(""), errors.New(fmt.Sprintf("Unknown revision: %s", revisionString))
 	}
-	return Revision(revisionString), nil
+	return Revision(out), nil
 }
 
 func (repository *gitRepository) ValidatePathAtRevision(revision Revision, path string) error {
-	out, err := repository.runGitCommand(
-		exec.Command("git", "ls-tree", "-r", "--name-only", string(revision)))
-	if err != nil {
-		return err
+	var revisionPaths []string
+	if IsUncommitted(revision) {
+		revisionPaths = repository.readUncommittedPaths(revision)
+	} else {
+		tree, err := repository.readTree(revision)
+		if err != nil {
+			return err
+		}
+		if _, ok := tree.blobs[path]; ok {
+			return nil
+		}
 	}
-	revisionPaths := strings.Split(out, "\n")
 	for _, revisionPath := range revisionPaths {
 		if path == revisionPath {
 			return nil
@@ -477,10 +950,9 @@ func (repository *gitRepository) ValidatePathAtRevision(revision Revision, path
 
 func (repository *gitRepository) ValidateLineNumberInPathAtRevision(
 	revision Revision, path string, lineNumber int) error {
-	blob := repository.getFileBlobOrDie(revision, path)
-	out := repository.runGitCommandOrDie(exec.Command("git", "show", blob))
+	out := repository.readFileOrDie(revision, path)
 	lines := strings.Split(out, "\n")
-	if len(lines) < lineNumber {
+	if lineNumber < 1 || len(lines) < lineNumber {
 		return errors.New(fmt.Sprintf(
 			"Line #%d, not found at path %s in revision %s",
 			lineNumber, path, string(revision)))
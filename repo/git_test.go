@@ -0,0 +1,104 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	masterHash  = "1111111111111111111111111111111111111111"
+	featureHash = "2222222222222222222222222222222222222222"
+	tagHash     = "3333333333333333333333333333333333333333"
+)
+
+func TestParseBranchRefs(t *testing.T) {
+	// Fields that are empty for refs pointing directly to commits.
+	notPeeled := "\x00\x00\x00\x00"
+	out := strings.Join([]string{
+		"*\x00refs/heads/master\x00\x00commit\x00" + masterHash + "\x001400000000\x00Alice" +
+			notPeeled + "\x000 0",
+		" \x00refs/heads/feature\x00\x00commit\x00" + featureHash + "\x001400000100\x00Bob" +
+			notPeeled + "\x002 1",
+		" \x00refs/remotes/origin/HEAD\x00refs/remotes/origin/master\x00commit\x00" + masterHash +
+			"\x001400000000\x00Alice" + notPeeled + "\x000 0",
+		" \x00refs/remotes/origin/master\x00\x00commit\x00" + masterHash + "\x001400000000\x00Alice" +
+			notPeeled,
+		" \x00refs/tags/v1.0\x00\x00tag\x00" + tagHash + "\x00\x00\x00commit\x00" + masterHash +
+			"\x001400000000\x00Alice",
+		" \x00refs/tags/tree\x00\x00tag\x00" + tagHash + "\x00\x00\x00tree\x00" + featureHash +
+			"\x00\x00",
+		" \x00refs/pull/1/head\x00\x00commit\x00" + featureHash + "\x001400000100\x00Bob" + notPeeled,
+	}, "\n")
+	aliases := parseBranchRefs(out)
+	expected := []Alias{
+		{Branch: "master", Revision: masterHash, Type: BranchRef, LastModified: 1400000000,
+			LastModifiedBy: "Alice"},
+		{Branch: "feature", Revision: featureHash, Type: BranchRef, LastModified: 1400000100,
+			LastModifiedBy: "Bob", Ahead: 2, Behind: 1},
+		{Branch: "remotes/origin/master", Revision: masterHash, Type: RemoteRef,
+			LastModified: 1400000000, LastModifiedBy: "Alice"},
+		{Branch: "tags/v1.0", Revision: masterHash, Type: TagRef, LastModified: 1400000000,
+			LastModifiedBy: "Alice"},
+		{Branch: "refs/pull/1/head", Revision: featureHash, Type: OtherRef, LastModified: 1400000100,
+			LastModifiedBy: "Bob"},
+	}
+	if !reflect.DeepEqual(aliases, expected) {
+		t.Errorf("Expected %v, but saw %v", expected, aliases)
+	}
+}
+
+func TestParseDefaultBranch(t *testing.T) {
+	out := strings.Join([]string{
+		"refs/heads/main/old\x00\x00commit\x00" + tagHash,
+		"refs/heads/master\x00\x00commit\x00" + masterHash,
+		"refs/remotes/origin/HEAD\x00refs/remotes/origin/feature\x00commit\x00" + featureHash,
+	}, "\n")
+	refName, revision := parseDefaultBranch(out)
+	if refName != "refs/remotes/origin/feature" || revision != featureHash {
+		t.Errorf("Expected origin/HEAD's target, but saw %s at %s", refName, revision)
+	}
+	refName, revision = parseDefaultBranch(strings.Join(strings.Split(out, "\n")[:2], "\n"))
+	if refName != "refs/heads/master" || revision != masterHash {
+		t.Errorf("Expected master, but saw %s at %s", refName, revision)
+	}
+	if refName, _ = parseDefaultBranch(""); refName != "" {
+		t.Errorf("Expected no default branch, but saw %s", refName)
+	}
+}
+
+func TestTreeCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newTreeCache(2)
+	masterTree, featureTree, tagTree := &revisionTree{}, &revisionTree{}, &revisionTree{}
+	cache.Store(masterHash, masterTree)
+	cache.Store(featureHash, featureTree)
+	// Reading the master tree makes the feature tree the least recently used.
+	if tree, ok := cache.Load(masterHash); !ok || tree != masterTree {
+		t.Errorf("Expected the master tree to be cached")
+	}
+	cache.Store(tagHash, tagTree)
+	if _, ok := cache.Load(featureHash); ok {
+		t.Errorf("Expected the feature tree to be evicted")
+	}
+	for revision, expected := range map[Revision]*revisionTree{masterHash: masterTree, tagHash: tagTree} {
+		if tree, ok := cache.Load(revision); !ok || tree != expected {
+			t.Errorf("Expected the tree of %s to be cached", revision)
+		}
+	}
+}
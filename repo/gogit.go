@@ -0,0 +1,1024 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// A file in the tree of a commit.
+type goGitFile struct {
+	path string
+	blob plumbing.Hash
+}
+
+// A repository for a git checkout that is read using a pure-Go implementation of git,
+// rather than by running the git command. It reads the same refs and reports the same
+// TODOs as the repository returned by NewGitRepository, but it does not list the
+// uncommitted changes, and when tracing lines back through history it only follows
+// files that were renamed without being modified.
+type goGitRepository struct {
+	DirPath               string
+	BlobTodosCache        *sync.Map
+	RevisionTodosCache    *sync.Map
+	RevisionMetadataCache *sync.Map
+	AheadBehindCache      *sync.Map
+	// Whether a commit removes a line's contents, keyed by removalKey.
+	RemovalCache *sync.Map
+	// The patterns of the refs to list, as accepted by "git for-each-ref".
+	RefPatterns []string
+
+	// Guards the repository, since reading its objects is not safe for concurrent use.
+	// It is only held while objects are read, rather than while blaming or diffing them,
+	// so that slow requests do not block every other request to the repository.
+	mutex      sync.Mutex
+	repository *git.Repository
+	// The blobs whose TODOs are being loaded, so that concurrent requests for the same
+	// blob share a single load.
+	blobLoads sync.Map
+}
+
+// A load of the TODOs in a blob, which is done once the channel is closed.
+type blobLoad struct {
+	done  chan bool
+	todos []Line
+}
+
+// The key of a cached result of removesContents.
+type removalKey struct {
+	commit   plumbing.Hash
+	contents string
+}
+
+// Create a repository for the git checkout in the given directory, which is read
+// without running git. The arguments are the same as for NewGitRepository.
+func NewGoGitRepository(dirPath, todoRegex, excludePaths, refPatterns string) (Repository, error) {
+	repository, err := newGoGitRepository(dirPath, refPatterns)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		// Pre-load all of the TODOs for the current branches
+		for _, alias := range repository.ListBranches() {
+			repository.LoadRevisionTodos(alias.Revision, todoRegex, excludePaths)
+		}
+	}()
+	return repository, nil
+}
+
+func newGoGitRepository(dirPath, refPatterns string) (*goGitRepository, error) {
+	gitRepo, err := git.PlainOpenWithOptions(dirPath, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	if err != nil {
+		return nil, err
+	}
+	if refPatterns == "" {
+		refPatterns = DefaultRefPatterns
+	}
+	return &goGitRepository{
+		DirPath:               dirPath,
+		RefPatterns:           strings.Split(refPatterns, ","),
+		BlobTodosCache:        &sync.Map{},
+		RevisionTodosCache:    &sync.Map{},
+		RevisionMetadataCache: &sync.Map{},
+		AheadBehindCache:      &sync.Map{},
+		RemovalCache:          &sync.Map{},
+		repository:            gitRepo,
+	}, nil
+}
+
+func (repository *goGitRepository) GetRepoId() string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(repository.DirPath)))
+}
+
+func (repository *goGitRepository) GetRepoPath() string {
+	return repository.DirPath
+}
+
+func (repository *goGitRepository) RevisionsAreImmutable() bool {
+	return true
+}
+
+// Report whether a ref matches a pattern in the same way as "git for-each-ref", which
+// matches either as a glob, or literally up to a slash.
+func matchesRefPattern(refName, pattern string) bool {
+	if refName == pattern {
+		return true
+	}
+	if strings.HasPrefix(refName, pattern) &&
+		(strings.HasSuffix(pattern, "/") || refName[len(pattern)] == '/') {
+		return true
+	}
+	matched, err := path.Match(pattern, refName)
+	return err == nil && matched
+}
+
+// Read the commit that a ref points to, peeling annotated tags. This returns nil for
+// refs that do not point to commits. The caller must hold the mutex.
+func (repository *goGitRepository) readRefCommit(hash plumbing.Hash) *object.Commit {
+	if commit, err := repository.repository.CommitObject(hash); err == nil {
+		return commit
+	}
+	tag, err := repository.repository.TagObject(hash)
+	if err != nil || tag.TargetType != plumbing.CommitObject {
+		return nil
+	}
+	commit, err := repository.repository.CommitObject(tag.Target)
+	if err != nil {
+		return nil
+	}
+	return commit
+}
+
+// List the revisions that can be reached from the given one, including itself. The
+// caller must hold the mutex.
+func (repository *goGitRepository) readAncestors(revision plumbing.Hash) map[plumbing.Hash]bool {
+	ancestors := make(map[plumbing.Hash]bool)
+	pending := []plumbing.Hash{revision}
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if ancestors[hash] {
+			continue
+		}
+		commit, err := repository.repository.CommitObject(hash)
+		if err != nil {
+			continue
+		}
+		ancestors[hash] = true
+		pending = append(pending, commit.ParentHashes...)
+	}
+	return ancestors
+}
+
+// Find the full ref name and revision of the repository's default branch, in the same
+// way as the git backend. The caller must hold the mutex.
+func (repository *goGitRepository) readDefaultBranch() (string, Revision) {
+	for _, candidate := range defaultBranchCandidates {
+		ref, err := repository.repository.Reference(plumbing.ReferenceName(candidate), false)
+		if err != nil {
+			continue
+		}
+		if ref.Type() == plumbing.SymbolicReference {
+			if ref, err = repository.repository.Reference(ref.Target(), true); err != nil {
+				continue
+			}
+		} else if strings.HasSuffix(candidate, "HEAD") {
+			continue
+		}
+		if commit := repository.readRefCommit(ref.Hash()); commit != nil {
+			return ref.Name().String(), Revision(commit.Hash.String())
+		}
+	}
+	return "", ""
+}
+
+// Count the commits in the branch that are not in the default branch, and vice versa.
+// The caller must hold the mutex.
+func (repository *goGitRepository) countAheadBehind(baseRevision, revision Revision) (int, int) {
+	key := [2]Revision{baseRevision, revision}
+	cachedCounts, ok := repository.AheadBehindCache.Load(key)
+	recordCacheLookup("ahead_behind", ok)
+	if ok {
+		counts := cachedCounts.([2]int)
+		return counts[0], counts[1]
+	}
+	baseAncestors := repository.readAncestors(plumbing.NewHash(string(baseRevision)))
+	ancestors := repository.readAncestors(plumbing.NewHash(string(revision)))
+	var ahead, behind int
+	for hash := range ancestors {
+		if !baseAncestors[hash] {
+			ahead++
+		}
+	}
+	for hash := range baseAncestors {
+		if !ancestors[hash] {
+			behind++
+		}
+	}
+	repository.AheadBehindCache.Store(key, [2]int{ahead, behind})
+	return ahead, behind
+}
+
+func (repository *goGitRepository) ListBranches() []Alias {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	baseRefName, baseRevision := repository.readDefaultBranch()
+	base, _ := refAliasNameAndType(baseRefName)
+	refs, err := repository.repository.References()
+	if err != nil {
+		log.Fatal(err)
+	}
+	aliases := make([]Alias, 0)
+	refs.ForEach(func(ref *plumbing.Reference) error {
+		// Skip symbolic refs, such as "origin/HEAD".
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		matched := false
+		for _, pattern := range repository.RefPatterns {
+			matched = matched || matchesRefPattern(ref.Name().String(), pattern)
+		}
+		if !matched {
+			return nil
+		}
+		commit := repository.readRefCommit(ref.Hash())
+		if commit == nil {
+			return nil
+		}
+		name, refType := refAliasNameAndType(ref.Name().String())
+		alias := Alias{
+			Branch:         name,
+			Revision:       Revision(commit.Hash.String()),
+			Type:           refType,
+			LastModified:   commit.Committer.When.Unix(),
+			LastModifiedBy: strings.TrimSpace(commit.Author.Name),
+		}
+		aliases = append(aliases, alias)
+		return nil
+	})
+	// Match the order of "git for-each-ref".
+	sort.Slice(aliases, func(i, j int) bool {
+		return aliasRefName(aliases[i]) < aliasRefName(aliases[j])
+	})
+	for i := range aliases {
+		alias := &aliases[i]
+		alias.Base = base
+		if baseRefName != "" {
+			alias.Ahead, alias.Behind = repository.countAheadBehind(baseRevision, alias.Revision)
+		}
+		alias.TodoCount = -1
+		if cachedTodos, ok := repository.RevisionTodosCache.Load(alias.Revision); ok {
+			alias.TodoCount = len(cachedTodos.([]Line))
+		}
+	}
+	return aliases
+}
+
+// Get the full name of the ref that an alias was named after by refAliasNameAndType.
+func aliasRefName(alias Alias) string {
+	switch alias.Type {
+	case BranchRef:
+		return "refs/heads/" + alias.Branch
+	case RemoteRef, TagRef:
+		return "refs/" + alias.Branch
+	}
+	return alias.Branch
+}
+
+func (repository *goGitRepository) ListUncommitted() []Alias {
+	return []Alias{}
+}
+
+func (repository *goGitRepository) IsAncestor(ancestor, descendant Revision) bool {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	ancestorCommit, err := repository.repository.CommitObject(plumbing.NewHash(string(ancestor)))
+	if err != nil {
+		return false
+	}
+	descendantCommit, err := repository.repository.CommitObject(plumbing.NewHash(string(descendant)))
+	if err != nil {
+		return false
+	}
+	isAncestor, err := ancestorCommit.IsAncestor(descendantCommit)
+	return err == nil && isAncestor
+}
+
+func (repository *goGitRepository) ReadFirstParentHistory(revision Revision, maxCount int) []Revision {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	revisions := make([]Revision, 0)
+	hash := plumbing.NewHash(string(revision))
+	for len(revisions) < maxCount {
+		commit, err := repository.repository.CommitObject(hash)
+		if err != nil {
+			break
+		}
+		revisions = append(revisions, Revision(commit.Hash.String()))
+		if len(commit.ParentHashes) == 0 {
+			break
+		}
+		hash = commit.ParentHashes[0]
+	}
+	return revisions
+}
+
+// List the files in a revision, in the same order as "git ls-tree -r". Only the trees
+// are read, rather than the blobs of the files.
+func (repository *goGitRepository) readRevisionFiles(revision Revision) ([]goGitFile, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]goGitFile, 0)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if entry.Mode.IsFile() {
+			files = append(files, goGitFile{name, entry.Hash})
+		}
+	}
+}
+
+func (repository *goGitRepository) readRevisionFilesOrDie(revision Revision) []goGitFile {
+	files, err := repository.readRevisionFiles(revision)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return files
+}
+
+// List the submodules in a revision, which are left out of its files.
+func (repository *goGitRepository) readRevisionSubmodules(revision Revision) ([]Submodule, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	var submodules []Submodule
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return submodules, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Submodule {
+			submodules = append(submodules, Submodule{name, Revision(entry.Hash.String())})
+		}
+	}
+}
+
+func (repository *goGitRepository) ReadRevisionContents(revision Revision) *RevisionContents {
+	contents := &RevisionContents{Revision: revision, Paths: make([]string, 0)}
+	for _, file := range repository.readRevisionFilesOrDie(revision) {
+		contents.Paths = append(contents.Paths, file.path)
+	}
+	submodules, err := repository.readRevisionSubmodules(revision)
+	if err != nil {
+		log.Fatal(err)
+	}
+	contents.Submodules = submodules
+	return contents
+}
+
+// Get the subject of a commit message in the same way as the "%s" placeholder of
+// "git show", which joins the lines of the first paragraph.
+func commitSubject(message string) string {
+	var subjectLines []string
+	for _, line := range strings.Split(strings.TrimLeft(message, "\n"), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			break
+		}
+		subjectLines = append(subjectLines, line)
+	}
+	return strings.Join(subjectLines, " ")
+}
+
+func (repository *goGitRepository) ReadRevisionMetadata(revision Revision) RevisionMetadata {
+	cachedMetadata, ok := repository.RevisionMetadataCache.Load(revision)
+	recordCacheLookup("revision_metadata", ok)
+	if ok {
+		return cachedMetadata.(RevisionMetadata)
+	}
+	repository.mutex.Lock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	repository.mutex.Unlock()
+	if err != nil {
+		log.Fatal(err)
+	}
+	metadata := RevisionMetadata{
+		Revision:    revision,
+		Timestamp:   commit.Committer.When.Unix(),
+		Subject:     commitSubject(commit.Message),
+		AuthorName:  strings.TrimSpace(commit.Author.Name),
+		AuthorEmail: strings.TrimSpace(commit.Author.Email),
+	}
+	repository.RevisionMetadataCache.Store(revision, metadata)
+	return metadata
+}
+
+// Find the blob of a file in a tree, which must be a file rather than a directory or
+// a submodule. The caller must hold the mutex.
+func findFileBlob(tree *object.Tree, path string) (plumbing.Hash, bool) {
+	entry, err := tree.FindEntry(path)
+	if err != nil || !entry.Mode.IsFile() {
+		return plumbing.ZeroHash, false
+	}
+	return entry.Hash, true
+}
+
+// Read the contents of a blob. The caller must hold the mutex.
+func (repository *goGitRepository) readBlob(hash plumbing.Hash) (string, error) {
+	blob, err := repository.repository.BlobObject(hash)
+	if err != nil {
+		return "", err
+	}
+	reader, err := blob.Reader()
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	return string(contents), err
+}
+
+// Read the contents of a file at the given revision.
+func (repository *goGitRepository) readFile(revision Revision, path string) (string, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	if err != nil {
+		return "", err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", err
+	}
+	blob, ok := findFileBlob(tree, path)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("Path '%s' not found at revision %s", path, string(revision)))
+	}
+	return repository.readBlob(blob)
+}
+
+// Read the contents of a file at the given revision, without any trailing newlines.
+func (repository *goGitRepository) readFileOrDie(revision Revision, path string) string {
+	contents, err := repository.readFile(revision, path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return strings.Trim(contents, " \n")
+}
+
+func (repository *goGitRepository) ReadFileSnippetAtRevision(revision Revision, path string, startLine, endLine int) string {
+	lines := strings.Split(repository.readFileOrDie(revision, path), "\n")
+	if startLine < 1 {
+		startLine = 1
+	}
+	if endLine > len(lines) || endLine < 0 {
+		endLine = len(lines) + 1
+	}
+	if startLine > endLine {
+		return ""
+	}
+	var snippet strings.Builder
+	for _, line := range lines[startLine-1 : endLine-1] {
+		snippet.WriteString(line)
+		snippet.WriteString("\n")
+	}
+	return snippet.String()
+}
+
+func (repository *goGitRepository) GrepRevision(revision Revision, words []string) ([]string, error) {
+	lines := make([]string, 0)
+	if len(words) == 0 {
+		return lines, nil
+	}
+	files, err := repository.readRevisionFiles(revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		repository.mutex.Lock()
+		contents, err := repository.readBlob(file.blob)
+		repository.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, GrepLines(contents, words)...)
+	}
+	return lines, nil
+}
+
+func (repository *goGitRepository) LoadRevisionTodos(
+	revision Revision, todoRegex, excludePaths string) []Line {
+	var todos []Line
+	for progress := range repository.StreamRevisionTodos(revision, todoRegex, excludePaths) {
+		todos = append(todos, progress.Todos...)
+	}
+	return todos
+}
+
+func (repository *goGitRepository) LoadCachedRevisionTodos(revision Revision) ([]Line, bool) {
+	cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
+	if !ok {
+		return nil, false
+	}
+	return cachedTodos.([]Line), true
+}
+
+func (repository *goGitRepository) StreamRevisionTodos(
+	revision Revision, todoRegex, excludePaths string) <-chan ScanProgress {
+	progress := make(chan ScanProgress)
+	go func() {
+		defer close(progress)
+		cachedTodos, ok := repository.RevisionTodosCache.Load(revision)
+		recordCacheLookup("revision_todos", ok)
+		if ok {
+			progress <- ScanProgress{Todos: cachedTodos.([]Line), FilesDone: 1, FilesTotal: 1}
+			return
+		}
+		start := time.Now()
+		excludeRegexs := compileRegexsOrDie(excludePaths)
+		files := make([]goGitFile, 0)
+	Files:
+		for _, file := range repository.readRevisionFilesOrDie(revision) {
+			for _, regex := range excludeRegexs {
+				if regex.MatchString(file.path) {
+					continue Files
+				}
+			}
+			files = append(files, file)
+		}
+		var todos []Line
+		for i, file := range files {
+			fileTodos := repository.loadBlobTodos(revision, file.path, file.blob, todoRegex)
+			todos = append(todos, fileTodos...)
+			progress <- ScanProgress{
+				FileName:   file.path,
+				Todos:      fileTodos,
+				FilesDone:  i + 1,
+				FilesTotal: len(files),
+			}
+		}
+		repository.RevisionTodosCache.Store(revision, todos)
+		revisionScanDuration.ObserveSince(start, repository.DirPath)
+	}()
+	return progress
+}
+
+func (repository *goGitRepository) LoadFileTodos(
+	revision Revision, path string, todoRegex string) []Line {
+	repository.mutex.Lock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	var blob plumbing.Hash
+	ok := false
+	if err == nil {
+		if tree, err := commit.Tree(); err == nil {
+			blob, ok = findFileBlob(tree, path)
+		}
+	}
+	repository.mutex.Unlock()
+	if !ok {
+		log.Fatal("Failed to lookup blob hash for " + path)
+	}
+	return repository.loadBlobTodos(revision, path, blob, todoRegex)
+}
+
+// Find the TODOs in a blob, each of which is reported at the revision that last
+// modified it. As with "git blame", these are cached by the blob alone.
+func (repository *goGitRepository) loadBlobTodos(
+	revision Revision, path string, blob plumbing.Hash, todoRegex string) []Line {
+	cachedTodos, ok := repository.BlobTodosCache.Load(blob)
+	recordCacheLookup("blob_todos", ok)
+	if ok {
+		return cachedTodos.([]Line)
+	}
+	load := &blobLoad{done: make(chan bool)}
+	if existing, loaded := repository.blobLoads.LoadOrStore(blob, load); loaded {
+		<-existing.(*blobLoad).done
+		return existing.(*blobLoad).todos
+	}
+	defer func() {
+		repository.blobLoads.Delete(blob)
+		close(load.done)
+	}()
+	repository.mutex.Lock()
+	contents, err := repository.readBlob(blob)
+	repository.mutex.Unlock()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var blobTodos []Line
+	lineMappings := make(map[[2]plumbing.Hash][]int)
+	for lineNumber, lineContents := range strings.Split(contents, "\n") {
+		matched, err := regexp.MatchString(todoRegex, lineContents)
+		if err == nil && matched {
+			// git numbers lines starting from 1 rather than 0
+			todo, err := repository.blameLine(revision, path, lineNumber+1, lineMappings)
+			if err != nil {
+				log.Fatal(err)
+			}
+			todo.Contents = lineContents
+			blobTodos = append(blobTodos, todo)
+		}
+	}
+	repository.BlobTodosCache.Store(blob, blobTodos)
+	load.todos = blobTodos
+	return blobTodos
+}
+
+// Count the lines in a segment of a line diff, the last of which may not end in a newline.
+func countLines(text string) int {
+	lines := strings.Count(text, "\n")
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		lines++
+	}
+	return lines
+}
+
+// Map each line of the new contents to the line of the old contents that it was left
+// unchanged from, or to zero if it was added. Lines are numbered from 1, so the first
+// entry is unused.
+func mapUnchangedLines(oldContents, newContents string) []int {
+	mapping := []int{0}
+	oldLine := 1
+	for _, lineDiff := range diff.Do(oldContents, newContents) {
+		count := countLines(lineDiff.Text)
+		switch lineDiff.Type {
+		case diffmatchpatch.DiffEqual:
+			for i := 0; i < count; i++ {
+				mapping = append(mapping, oldLine+i)
+			}
+			oldLine += count
+		case diffmatchpatch.DiffDelete:
+			oldLine += count
+		case diffmatchpatch.DiffInsert:
+			for i := 0; i < count; i++ {
+				mapping = append(mapping, 0)
+			}
+		}
+	}
+	return mapping
+}
+
+// Find a file from a commit in the tree of one of its parents. Besides files at the
+// same path, this finds files that were renamed without being modified. The caller must
+// hold the mutex.
+func findParentFile(tree, parentTree *object.Tree, path string, blob plumbing.Hash) (string, plumbing.Hash, bool) {
+	if parentBlob, ok := findFileBlob(parentTree, path); ok {
+		return path, parentBlob, true
+	}
+	renamedPath := ""
+	parentTree.Files().ForEach(func(file *object.File) error {
+		if file.Hash == blob {
+			if _, ok := findFileBlob(tree, file.Name); !ok {
+				renamedPath = file.Name
+				return storer.ErrStop
+			}
+		}
+		return nil
+	})
+	return renamedPath, blob, renamedPath != ""
+}
+
+// Trace a line back through history to the revision that last modified it, in the same
+// way as "git blame". The returned line has the path and line number of that revision,
+// but not the contents. The line mappings between pairs of blobs are cached in the given
+// map. The mutex is taken for each step's object reads, but not for the diffs.
+func (repository *goGitRepository) blameLine(
+	revision Revision, path string, lineNumber int, lineMappings map[[2]plumbing.Hash][]int) (Line, error) {
+	repository.mutex.Lock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	var tree *object.Tree
+	if err == nil {
+		tree, err = commit.Tree()
+	}
+	var blob plumbing.Hash
+	ok := false
+	if err == nil {
+		blob, ok = findFileBlob(tree, path)
+	}
+	repository.mutex.Unlock()
+	if err != nil {
+		return Line{}, err
+	}
+	if !ok {
+		return Line{}, errors.New("Failed to lookup blob hash for " + path)
+	}
+	type parentFile struct {
+		commit *object.Commit
+		tree   *object.Tree
+		path   string
+		blob   plumbing.Hash
+	}
+	readParentFiles := func() ([]parentFile, error) {
+		repository.mutex.Lock()
+		defer repository.mutex.Unlock()
+		var parentFiles []parentFile
+		for _, parentHash := range commit.ParentHashes {
+			parent, err := repository.repository.CommitObject(parentHash)
+			if err != nil {
+				return nil, err
+			}
+			parentTree, err := parent.Tree()
+			if err != nil {
+				return nil, err
+			}
+			if parentPath, parentBlob, ok := findParentFile(tree, parentTree, path, blob); ok {
+				parentFiles = append(parentFiles, parentFile{parent, parentTree, parentPath, parentBlob})
+			}
+		}
+		return parentFiles, nil
+	}
+	readBlobs := func(oldBlob, newBlob plumbing.Hash) (string, string, error) {
+		repository.mutex.Lock()
+		defer repository.mutex.Unlock()
+		oldContents, err := repository.readBlob(oldBlob)
+		if err != nil {
+			return "", "", err
+		}
+		newContents, err := repository.readBlob(newBlob)
+		return oldContents, newContents, err
+	}
+	for {
+		parentFiles, err := readParentFiles()
+		if err != nil {
+			return Line{}, err
+		}
+		// A parent with an identical file takes the blame for every line, and otherwise
+		// the first parent in which the line was left unchanged does.
+		var next *parentFile
+		for i := range parentFiles {
+			if parentFiles[i].blob == blob {
+				next = &parentFiles[i]
+				break
+			}
+		}
+		if next == nil {
+			for i := range parentFiles {
+				key := [2]plumbing.Hash{parentFiles[i].blob, blob}
+				mapping, ok := lineMappings[key]
+				if !ok {
+					oldContents, newContents, err := readBlobs(parentFiles[i].blob, blob)
+					if err != nil {
+						return Line{}, err
+					}
+					mapping = mapUnchangedLines(oldContents, newContents)
+					lineMappings[key] = mapping
+				}
+				if lineNumber < len(mapping) && mapping[lineNumber] != 0 {
+					next = &parentFiles[i]
+					lineNumber = mapping[lineNumber]
+					break
+				}
+			}
+		}
+		if next == nil {
+			return Line{Revision(commit.Hash.String()), path, lineNumber, ""}, nil
+		}
+		commit, tree, path, blob = next.commit, next.tree, next.path, next.blob
+	}
+}
+
+// Report whether a commit removes the given line contents, in the same way as the
+// "git log -S" search used by the git command based repository. Since commits never
+// change, the results are cached. The mutex is taken to read the changed files, but not
+// to diff them.
+func (repository *goGitRepository) removesContents(commit *object.Commit, contents string) (bool, error) {
+	key := removalKey{commit.Hash, contents}
+	cachedRemoves, ok := repository.RemovalCache.Load(key)
+	recordCacheLookup("removes_contents", ok)
+	if ok {
+		return cachedRemoves.(bool), nil
+	}
+	type changedFile struct {
+		oldContents string
+		newContents string
+	}
+	readChangedFiles := func() ([]changedFile, error) {
+		repository.mutex.Lock()
+		defer repository.mutex.Unlock()
+		tree, err := commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+		parentTree := &object.Tree{}
+		if len(commit.ParentHashes) > 0 {
+			parent, err := repository.repository.CommitObject(commit.ParentHashes[0])
+			if err != nil {
+				return nil, err
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return nil, err
+			}
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return nil, err
+		}
+		var changedFiles []changedFile
+		for _, change := range changes {
+			from, to, err := change.Files()
+			if err != nil {
+				return nil, err
+			}
+			var file changedFile
+			if from != nil {
+				if file.oldContents, err = from.Contents(); err != nil {
+					return nil, err
+				}
+			}
+			if to != nil {
+				if file.newContents, err = to.Contents(); err != nil {
+					return nil, err
+				}
+			}
+			changedFiles = append(changedFiles, file)
+		}
+		return changedFiles, nil
+	}
+	changedFiles, err := readChangedFiles()
+	if err != nil {
+		return false, err
+	}
+	countChanged := false
+	for _, file := range changedFiles {
+		if strings.Count(file.oldContents, contents) != strings.Count(file.newContents, contents) {
+			countChanged = true
+		}
+	}
+	removed, added := false, false
+	for _, file := range changedFiles {
+		if !countChanged {
+			break
+		}
+		for _, lineDiff := range diff.Do(file.oldContents, file.newContents) {
+			if strings.Contains(lineDiff.Text, contents) {
+				removed = removed || lineDiff.Type == diffmatchpatch.DiffDelete
+				added = added || lineDiff.Type == diffmatchpatch.DiffInsert
+			}
+		}
+	}
+	removes := removed && !added
+	repository.RemovalCache.Store(key, removes)
+	return removes, nil
+}
+
+func (repository *goGitRepository) FindClosingRevisions(todoId TodoId) ([]Revision, error) {
+	results := make([]Revision, 0)
+	file, err := repository.readFile(todoId.Revision, todoId.FileName)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := todoLineContents(todoId, file)
+	if err != nil {
+		return nil, err
+	}
+	var pending []plumbing.Hash
+	for _, alias := range repository.ListBranches() {
+		if alias.Revision != todoId.Revision {
+			pending = append(pending, plumbing.NewHash(string(alias.Revision)))
+		}
+	}
+	// Search the commits that are in any of the branches, but not in the TODO's revision.
+	// The mutex is only held while reading each commit, since searching them is slow.
+	repository.mutex.Lock()
+	seen := repository.readAncestors(plumbing.NewHash(string(todoId.Revision)))
+	repository.mutex.Unlock()
+	var closingCommits []*object.Commit
+	for len(pending) > 0 {
+		hash := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		repository.mutex.Lock()
+		commit, err := repository.repository.CommitObject(hash)
+		repository.mutex.Unlock()
+		if err != nil {
+			log.Fatal(err)
+		}
+		pending = append(pending, commit.ParentHashes...)
+		// As with "git log", merges are not searched.
+		if len(commit.ParentHashes) > 1 {
+			continue
+		}
+		// TODO(ojarjur): Exclude revisions that are later rolled back.
+		removes, err := repository.removesContents(commit, contents)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if removes {
+			closingCommits = append(closingCommits, commit)
+		}
+	}
+	sort.SliceStable(closingCommits, func(i, j int) bool {
+		return closingCommits[i].Committer.When.After(closingCommits[j].Committer.When)
+	})
+	for _, commit := range closingCommits {
+		results = append(results, Revision(commit.Hash.String()))
+	}
+	return results, nil
+}
+
+func (repository *goGitRepository) GetBrowseUrl(revision Revision, path string, lineNumber int) string {
+	rawUrl := fmt.Sprintf("/raw?repo=%s&revision=%s&fileName=%s&lineNumber=%d",
+		repository.GetRepoId(), string(revision), url.QueryEscape(path), lineNumber)
+	repository.mutex.Lock()
+	remotes, err := repository.repository.Remotes()
+	repository.mutex.Unlock()
+	if err != nil {
+		return rawUrl
+	}
+	// The order of the remotes is not kept, so sort them by name.
+	sort.Slice(remotes, func(i, j int) bool {
+		return remotes[i].Config().Name < remotes[j].Config().Name
+	})
+	remoteUrls := make([]string, 0)
+	for _, remote := range remotes {
+		remoteUrls = append(remoteUrls, remote.Config().URLs...)
+	}
+	if browseUrl, ok := gitHubBrowseUrl(remoteUrls, revision, path, lineNumber); ok {
+		return browseUrl
+	}
+	return rawUrl
+}
+
+func (repository *goGitRepository) ValidateRevision(revisionString string) (Revision, error) {
+	if revisionString == "" || strings.HasPrefix(revisionString, "-") ||
+		strings.ContainsAny(revisionString, "\x00\n") {
+		return Revision(""), errors.New(fmt.Sprintf("Invalid revision: %s", revisionString))
+	}
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	// This resolves names to the commits they point to, peeling any tags.
+	hash, err := repository.repository.ResolveRevision(plumbing.Revision(revisionString))
+	if err != nil {
+		return Revision(""), errors.New(fmt.Sprintf("Unknown revision: %s", revisionString))
+	}
+	return Revision(hash.String()), nil
+}
+
+func (repository *goGitRepository) ValidatePathAtRevision(revision Revision, path string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+	commit, err := repository.repository.CommitObject(plumbing.NewHash(string(revision)))
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	if _, ok := findFileBlob(tree, path); !ok {
+		return errors.New(fmt.Sprintf("Path '%s' not found at revision %s", path, string(revision)))
+	}
+	return nil
+}
+
+func (repository *goGitRepository) ValidateLineNumberInPathAtRevision(
+	revision Revision, path string, lineNumber int) error {
+	lines := strings.Split(repository.readFileOrDie(revision, path), "\n")
+	if lineNumber < 1 || len(lines) < lineNumber {
+		return errors.New(fmt.Sprintf(
+			"Line #%d, not found at path %s in revision %s",
+			lineNumber, path, string(revision)))
+	}
+	return nil
+}
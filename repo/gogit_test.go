@@ -0,0 +1,184 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatchesRefPattern(t *testing.T) {
+	for _, test := range []struct {
+		refName, pattern string
+		expected         bool
+	}{
+		{"refs/heads/master", "refs/heads/", true},
+		{"refs/heads/master", "refs/heads", true},
+		{"refs/heads/master", "refs/heads/master", true},
+		{"refs/heads/master", "refs/head", false},
+		{"refs/heads/master2", "refs/heads/master", false},
+		{"refs/pull/1/head", "refs/pull/*/head", true},
+		{"refs/pull/1/merge", "refs/pull/*/head", false},
+		{"refs/tags/v1.0", "refs/tags/v*", true},
+	} {
+		if matched := matchesRefPattern(test.refName, test.pattern); matched != test.expected {
+			t.Errorf("Expected matching %q against %q to be %v", test.refName, test.pattern, test.expected)
+		}
+	}
+}
+
+// Build a repository with a little history using the git command, and check that both
+// backends read the same things from it.
+func TestGoGitRepositoryMatchesGitRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("HOME", dir)
+	commitTime := 1400000000
+	runGit := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Alice", "GIT_AUTHOR_EMAIL=alice@example.com",
+			"GIT_COMMITTER_NAME=Alice", "GIT_COMMITTER_EMAIL=alice@example.com",
+			fmt.Sprintf("GIT_AUTHOR_DATE=%d +0000", commitTime),
+			fmt.Sprintf("GIT_COMMITTER_DATE=%d +0000", commitTime))
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %v\n%s", args, err, out)
+		}
+	}
+	commit := func(message string, files map[string]string) {
+		for path, contents := range files {
+			fullPath := filepath.Join(dir, path)
+			if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		commitTime += 100
+		runGit("add", "-A")
+		runGit("commit", "-q", "-m", message)
+	}
+
+	runGit("init", "-q", "-b", "master")
+	commit("Add the main file", map[string]string{
+		"main.go": "package main\n\n// TODO: write main\n",
+	})
+	commit("Add the docs\n\nThey are not written yet.", map[string]string{
+		"docs/notes.txt": "TODO: write the notes\nTODO: and more\n",
+	})
+	runGit("tag", "-a", "-m", "The first release", "v1")
+	runGit("checkout", "-q", "-b", "feature")
+	commit("Write the notes", map[string]string{
+		"docs/notes.txt": "The notes\nTODO: and more\n",
+	})
+	runGit("mv", "docs/notes.txt", "docs/readme.txt")
+	commit("Rename the notes", nil)
+	runGit("checkout", "-q", "master")
+	commit("Add a package comment", map[string]string{
+		"main.go": "// The main package\npackage main\n\n// TODO: write main\n",
+	})
+	runGit("merge", "-q", "--no-ff", "-m", "Merge the feature", "feature")
+	commit("Add another TODO", map[string]string{
+		"main.go": "// The main package\npackage main\n\n// TODO: write main\n// TODO: test main\n",
+	})
+
+	gitRepo := newGitRepository(dir, "refs/heads/,refs/tags/")
+	goGitRepo, err := newGoGitRepository(dir, "refs/heads/,refs/tags/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAliases := gitRepo.ListBranches()
+	if aliases := goGitRepo.ListBranches(); !reflect.DeepEqual(aliases, expectedAliases) {
+		t.Errorf("Expected the branches %v, but saw %v", expectedAliases, aliases)
+	}
+	for _, name := range []string{"master", "feature", "v1", "master~1", "master~1^2", "HEAD"} {
+		expected, err := gitRepo.ValidateRevision(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revision, err := goGitRepo.ValidateRevision(name); err != nil || revision != expected {
+			t.Errorf("Expected %s to resolve to %s, but saw %s, %v", name, expected, revision, err)
+		}
+	}
+	if _, err := goGitRepo.ValidateRevision("missing"); err == nil {
+		t.Errorf("Expected an unknown revision to be rejected")
+	}
+
+	var todos []Line
+	for _, alias := range expectedAliases {
+		revision := alias.Revision
+		expectedContents := gitRepo.ReadRevisionContents(revision)
+		if contents := goGitRepo.ReadRevisionContents(revision); !reflect.DeepEqual(contents, expectedContents) {
+			t.Errorf("Expected the contents %v, but saw %v", expectedContents, contents)
+		}
+		expectedHistory := gitRepo.ReadFirstParentHistory(revision, 10)
+		if history := goGitRepo.ReadFirstParentHistory(revision, 10); !reflect.DeepEqual(history, expectedHistory) {
+			t.Errorf("Expected the history %v, but saw %v", expectedHistory, history)
+		}
+		expectedTodos := gitRepo.LoadRevisionTodos(revision, testTodoRegex, "")
+		revisionTodos := goGitRepo.LoadRevisionTodos(revision, testTodoRegex, "")
+		if !reflect.DeepEqual(revisionTodos, expectedTodos) {
+			t.Errorf("Expected the TODOs %v in %s, but saw %v", expectedTodos, alias.Branch, revisionTodos)
+		}
+		todos = append(todos, revisionTodos...)
+		for _, otherAlias := range expectedAliases {
+			expected := gitRepo.IsAncestor(otherAlias.Revision, revision)
+			if goGitRepo.IsAncestor(otherAlias.Revision, revision) != expected {
+				t.Errorf("Expected %s being an ancestor of %s to be %v",
+					otherAlias.Branch, alias.Branch, expected)
+			}
+		}
+	}
+	closed := false
+	for _, todo := range todos {
+		expectedMetadata := gitRepo.ReadRevisionMetadata(todo.Revision)
+		if metadata := goGitRepo.ReadRevisionMetadata(todo.Revision); metadata != expectedMetadata {
+			t.Errorf("Expected the metadata %v, but saw %v", expectedMetadata, metadata)
+		}
+		todoId := TodoId{todo.Revision, todo.FileName, todo.LineNumber}
+		expectedClosing, err := gitRepo.FindClosingRevisions(todoId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed = closed || len(expectedClosing) > 0
+		if closing, err := goGitRepo.FindClosingRevisions(todoId); err != nil || !reflect.DeepEqual(closing, expectedClosing) {
+			t.Errorf("Expected %v to be closed by %v, but saw %v, %v", todo, expectedClosing, closing, err)
+		}
+		expectedSnippet := gitRepo.ReadFileSnippetAtRevision(todo.Revision, todo.FileName, 1, -1)
+		snippet := goGitRepo.ReadFileSnippetAtRevision(todo.Revision, todo.FileName, 1, -1)
+		if snippet != expectedSnippet {
+			t.Errorf("Expected the snippet %q, but saw %q", expectedSnippet, snippet)
+		}
+	}
+	if !closed {
+		t.Errorf("Expected one of the TODOs %v to have been removed", todos)
+	}
+	if !strings.Contains(fmt.Sprint(todos), "docs/notes.txt") {
+		t.Errorf("Expected the renamed TODO to be traced back to its original path, but saw %v", todos)
+	}
+}
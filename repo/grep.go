@@ -0,0 +1,61 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"strings"
+)
+
+// How much of a file is checked for NUL bytes to tell if it is binary, which is the
+// same amount that git checks.
+const binaryCheckSize = 8000
+
+// Report whether the given contents are binary rather than text, as git decides
+// when it skips binary files.
+func isBinary(contents string) bool {
+	if len(contents) > binaryCheckSize {
+		contents = contents[:binaryCheckSize]
+	}
+	return strings.IndexByte(contents, 0) >= 0
+}
+
+// Return the lines of the given contents that contain any of the given strings, or
+// nothing if the contents are binary.
+func GrepLines(contents string, words []string) []string {
+	lines := make([]string, 0)
+	if isBinary(contents) {
+		return lines
+	}
+	for _, line := range strings.Split(contents, "\n") {
+		for _, word := range words {
+			if strings.Contains(line, word) {
+				lines = append(lines, line)
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// Split the output of "git grep" into lines, dropping the final newline.
+func splitGrepOutput(out string) []string {
+	out = strings.TrimSuffix(out, "\n")
+	if out == "" {
+		return make([]string, 0)
+	}
+	return strings.Split(out, "\n")
+}
@@ -18,13 +18,24 @@ package repo
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
 type Revision string
 type RevisionContents struct {
 	Revision Revision
-	Paths    []string
+	// The files in the revision, which do not include its submodules.
+	Paths      []string
+	Submodules []Submodule
+}
+
+// A submodule of a revision, which is another repository pinned at one of its revisions.
+type Submodule struct {
+	Path     string
+	Revision Revision
 }
 
 type RevisionMetadata struct {
@@ -35,10 +46,37 @@ type RevisionMetadata struct {
 	AuthorEmail string
 }
 
+// The types of the refs that an Alias can name.
+const (
+	BranchRef = "branch"
+	RemoteRef = "remote"
+	TagRef    = "tag"
+	// Any other ref, such as "refs/pull/1/head".
+	OtherRef = "ref"
+	// A pseudo-revision for uncommitted changes, such as WorktreeRevision.
+	UncommittedRef = "uncommitted"
+)
+
+// A named ref, such as a branch or a tag, and the revision it points to.
 type Alias struct {
+	// The name of the ref, which is "remotes/<remote>/<branch>" for remote branches,
+	// "tags/<tag>" for tags, and the full name of any other ref.
 	Branch   string
 	Revision Revision
-	// TODO: Add LastModified and LastModifiedBy fields based on the RevisionMetadata
+	// One of BranchRef, RemoteRef, TagRef, OtherRef, or UncommittedRef.
+	Type string
+	// The commit time, in seconds since the epoch, and the author of the branch's last commit.
+	LastModified   int64
+	LastModifiedBy string
+	// The number of commits in the branch that are not in the repository's default
+	// branch, and the number of commits in the default branch that are not in this one.
+	Ahead  int
+	Behind int
+	// The name of the default branch that Ahead and Behind are counted against, in the
+	// same form as Branch, or empty if the repository has no default branch.
+	Base string
+	// The number of TODOs in the branch, or -1 if its revision has not been scanned yet.
+	TodoCount int
 }
 
 type Line struct {
@@ -61,30 +99,81 @@ type TodoDetails struct {
 	Context          string
 }
 
+// The changes to the TODOs between two revisions.
+//
+// TODOs are matched based on their contents and the revision that last modified them,
+// so a TODO whose line number changes is neither added nor removed. A matched TODO
+// that ends up in a different file is reported as moved.
+type TodoDiff struct {
+	Added   []Line
+	Removed []Line
+	Moved   []TodoMove
+}
+
+type TodoMove struct {
+	From Line
+	To   Line
+}
+
 type TodoStatus struct {
 	BranchesMissing []Alias
 	BranchesPresent []Alias
 	BranchesRemoved []Alias
 }
 
+// The progress of scanning a revision for TODOs, reported after each file is scanned.
+type ScanProgress struct {
+	// The file that was just scanned, and the TODOs found in it.
+	FileName string
+	Todos    []Line
+	// The number of files scanned so far, and the number of files to scan in total.
+	FilesDone  int
+	FilesTotal int
+}
+
 type Repository interface {
 	// Get an opaque ID that uniquely identifies this repo on this machine.
 	GetRepoId() string
 	// Get the path to this repo on this machine.
 	GetRepoPath() string
+	// Whether everything read at a revision given by its full hash can never change,
+	// as for git commits, so that responses for it can be cached forever.
+	RevisionsAreImmutable() bool
 
+	// List the refs that the repository is configured to track, which are the local
+	// and remote branches by default.
 	ListBranches() []Alias
+	// List the pseudo-revisions for uncommitted changes, such as WorktreeRevision,
+	// that can be read like any other revision. Their TODOs are never cached.
+	ListUncommitted() []Alias
 	IsAncestor(ancestor, descendant Revision) bool
+	// Read the given revision and up to maxCount of its first-parent ancestors,
+	// starting with the given revision.
+	ReadFirstParentHistory(revision Revision, maxCount int) []Revision
 	ReadRevisionContents(revision Revision) *RevisionContents
 	ReadRevisionMetadata(revision Revision) RevisionMetadata
 	ReadFileSnippetAtRevision(revision Revision, path string, startLine, endLine int) string
+	// Find the lines in the text files of a revision that contain any of the given
+	// strings. Binary files are skipped.
+	GrepRevision(revision Revision, words []string) ([]string, error)
 	LoadRevisionTodos(revision Revision, todoRegex, excludePaths string) []Line
+	// Get the TODOs in a revision if it has already been scanned, without scanning it.
+	LoadCachedRevisionTodos(revision Revision) ([]Line, bool)
+	// Load the TODOs in a revision, sending the TODOs of each file on the returned
+	// channel as soon as that file is scanned. The channel is closed once every file
+	// has been scanned. If the revision was already scanned, the TODOs may be sent
+	// all at once.
+	StreamRevisionTodos(revision Revision, todoRegex, excludePaths string) <-chan ScanProgress
 	LoadFileTodos(revision Revision, path string, todoRegex string) []Line
-	FindClosingRevisions(todoId TodoId) []Revision
+	// Find the commits, in any of the branches, that remove the TODO's line. This fails
+	// if the TODO's revision does not have the line.
+	FindClosingRevisions(todoId TodoId) ([]Revision, error)
 
 	GetBrowseUrl(revision Revision, path string, lineNumber int) string
 
-	// Check that the given string is a valid revision.
+	// Check that the given string is a valid revision, and resolve it to the full
+	// hash of the commit it names. Besides full hashes, this accepts abbreviated
+	// hashes, branch and tag names, and expressions such as "HEAD~3".
 	// This is intended for user input validation.
 	ValidateRevision(revisionString string) (Revision, error)
 
@@ -100,7 +189,7 @@ type Repository interface {
 }
 
 func WriteJson(w io.Writer, repository Repository) error {
-	bytes, err := json.Marshal(repository.ListBranches())
+	bytes, err := json.Marshal(append(repository.ListBranches(), repository.ListUncommitted()...))
 	if err != nil {
 		return err
 	}
@@ -120,8 +209,22 @@ func LoadTodoDetails(repository Repository, todoId TodoId, linesBefore int, line
 	}
 }
 
-func LoadTodoStatus(repository Repository, todoId TodoId) *TodoStatus {
-	closingRevs := repository.FindClosingRevisions(todoId)
+// Get the contents of a TODO's line from the contents of its file.
+func todoLineContents(todoId TodoId, contents string) (string, error) {
+	lines := strings.Split(contents, "\n")
+	if todoId.LineNumber < 1 || len(lines) < todoId.LineNumber {
+		return "", errors.New(fmt.Sprintf(
+			"Line #%d, not found at path %s in revision %s",
+			todoId.LineNumber, todoId.FileName, string(todoId.Revision)))
+	}
+	return lines[todoId.LineNumber-1], nil
+}
+
+func LoadTodoStatus(repository Repository, todoId TodoId) (*TodoStatus, error) {
+	closingRevs, err := repository.FindClosingRevisions(todoId)
+	if err != nil {
+		return nil, err
+	}
 	missing := make([]Alias, 0)
 	present := make([]Alias, 0)
 	removed := make([]Alias, 0)
@@ -145,7 +248,58 @@ Branches:
 		BranchesMissing: missing,
 		BranchesPresent: present,
 		BranchesRemoved: removed,
+	}, nil
+}
+
+type todoKey struct {
+	Revision Revision
+	Contents string
+}
+
+// Compute the changes between two sets of TODOs, such as those of two revisions.
+func DiffTodos(oldTodos, newTodos []Line) TodoDiff {
+	unmatched := make(map[todoKey][]Line)
+	for _, todo := range oldTodos {
+		key := todoKey{todo.Revision, todo.Contents}
+		unmatched[key] = append(unmatched[key], todo)
+	}
+	diff := TodoDiff{
+		Added:   make([]Line, 0),
+		Removed: make([]Line, 0),
+		Moved:   make([]TodoMove, 0),
+	}
+	for _, todo := range newTodos {
+		key := todoKey{todo.Revision, todo.Contents}
+		candidates := unmatched[key]
+		if len(candidates) == 0 {
+			diff.Added = append(diff.Added, todo)
+			continue
+		}
+		// Prefer a match in the same file, so that only TODOs that really moved are reported.
+		matchIndex := 0
+		for i, candidate := range candidates {
+			if candidate.FileName == todo.FileName {
+				matchIndex = i
+				break
+			}
+		}
+		match := candidates[matchIndex]
+		unmatched[key] = append(candidates[:matchIndex:matchIndex], candidates[matchIndex+1:]...)
+		if match.FileName != todo.FileName {
+			diff.Moved = append(diff.Moved, TodoMove{From: match, To: todo})
+		}
+	}
+	for _, todo := range oldTodos {
+		key := todoKey{todo.Revision, todo.Contents}
+		for i, candidate := range unmatched[key] {
+			if candidate == todo {
+				diff.Removed = append(diff.Removed, todo)
+				unmatched[key] = append(unmatched[key][:i:i], unmatched[key][i+1:]...)
+				break
+			}
+		}
 	}
+	return diff
 }
 
 func WriteTodosJson(w io.Writer, repository Repository, revision Revision, todoRegex, excludePaths string) error {
@@ -168,7 +322,11 @@ func WriteTodoDetailsJson(w io.Writer, repository Repository, todoId TodoId) err
 }
 
 func WriteTodoStatusDetailsJson(w io.Writer, repository Repository, todoId TodoId) error {
-	bytes, err := json.Marshal(LoadTodoStatus(repository, todoId))
+	status, err := LoadTodoStatus(repository, todoId)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(status)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,160 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An entry listed by "git ls-tree -z".
+type treeEntry struct {
+	Mode   string
+	Type   string
+	Object string
+	Path   string
+}
+
+// An entry listed by "git ls-files -z --stage".
+type indexEntry struct {
+	Mode   string
+	Object string
+	Stage  int
+	Path   string
+}
+
+// A configuration value listed by "git config -z --get-regexp".
+type configValue struct {
+	Key   string
+	Value string
+}
+
+// Split output made of NUL-terminated records, such as that of the "-z" option of
+// many git commands. The terminator of the last record is optional.
+func splitNulTerminated(out string) []string {
+	if out == "" {
+		return []string{}
+	}
+	return strings.Split(strings.TrimSuffix(out, "\x00"), "\x00")
+}
+
+// Parse the output of "git ls-tree -z", in which each entry is
+// "<mode> SP <type> SP <object> TAB <path>" followed by a NUL character. Paths are
+// not quoted, so they may contain any character other than NUL.
+func parseTreeEntries(out string) ([]treeEntry, error) {
+	entries := make([]treeEntry, 0)
+	for _, record := range splitNulTerminated(out) {
+		info, path, ok := strings.Cut(record, "\t")
+		fields := strings.Split(info, " ")
+		if !ok || len(fields) != 3 || path == "" {
+			return nil, errors.New(fmt.Sprintf("Malformed tree entry: %q", record))
+		}
+		entries = append(entries, treeEntry{fields[0], fields[1], fields[2], path})
+	}
+	return entries, nil
+}
+
+// Parse the output of "git ls-files -z --stage", in which each entry is
+// "<mode> SP <object> SP <stage> TAB <path>" followed by a NUL character.
+func parseIndexEntries(out string) ([]indexEntry, error) {
+	entries := make([]indexEntry, 0)
+	for _, record := range splitNulTerminated(out) {
+		info, path, ok := strings.Cut(record, "\t")
+		fields := strings.Split(info, " ")
+		if !ok || len(fields) != 3 || path == "" {
+			return nil, errors.New(fmt.Sprintf("Malformed index entry: %q", record))
+		}
+		stage, err := strconv.Atoi(fields[2])
+		if err != nil || stage < 0 || stage > 3 {
+			return nil, errors.New(fmt.Sprintf("Malformed index entry: %q", record))
+		}
+		entries = append(entries, indexEntry{fields[0], fields[1], stage, path})
+	}
+	return entries, nil
+}
+
+// Parse the output of "git config -z --get-regexp", in which each entry is
+// "<key> LF <value>" followed by a NUL character. Keys without values, which are
+// listed without the LF, are given empty values.
+func parseConfigValues(out string) []configValue {
+	values := make([]configValue, 0)
+	for _, record := range splitNulTerminated(out) {
+		key, value, _ := strings.Cut(record, "\n")
+		values = append(values, configValue{key, value})
+	}
+	return values
+}
+
+// Parse the output of a git command that lists commit hashes, one per NUL-terminated
+// record, such as "git log -z --format=%H".
+func parseRevisions(out string) ([]Revision, error) {
+	revisions := make([]Revision, 0)
+	for _, record := range splitNulTerminated(out) {
+		if !hashRegexp.MatchString(record) {
+			return nil, errors.New(fmt.Sprintf("Malformed revision: %q", record))
+		}
+		revisions = append(revisions, Revision(record))
+	}
+	return revisions, nil
+}
+
+// Undo the quoting of a path in the output of git, which quotes paths containing
+// special characters in the same way as C string literals.
+func unquoteGitPath(path string) (string, error) {
+	if !strings.HasPrefix(path, "\"") {
+		return path, nil
+	}
+	return strconv.Unquote(path)
+}
+
+// Parse the output of "git blame --line-porcelain". Each line of the file is described
+// by a header of "<revision> <original line> <final line> [<lines in group>]", then by
+// "<key> <value>" lines such as "filename <path>", and then by the line's contents
+// prefixed with a TAB. Lines are reported at the path given, unless the output says
+// they came from another one.
+func parseBlameOutput(fileName string, out string) ([]Line, error) {
+	result := make([]Line, 0)
+	if out == "" {
+		return result, nil
+	}
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		headerFields := strings.Split(lines[i], " ")
+		if len(headerFields) < 3 || len(headerFields) > 4 || !hashRegexp.MatchString(headerFields[0]) {
+			return nil, errors.New(fmt.Sprintf("Malformed blame header: %q", lines[i]))
+		}
+		lineNumber, err := strconv.Atoi(headerFields[1])
+		if err != nil || lineNumber < 1 {
+			return nil, errors.New(fmt.Sprintf("Malformed blame header: %q", lines[i]))
+		}
+		for i++; i < len(lines) && !strings.HasPrefix(lines[i], "\t"); i++ {
+			if quotedName, ok := strings.CutPrefix(lines[i], "filename "); ok {
+				if fileName, err = unquoteGitPath(quotedName); err != nil {
+					return nil, errors.New(fmt.Sprintf("Malformed blame file name: %q", quotedName))
+				}
+			}
+		}
+		if i == len(lines) {
+			return nil, errors.New("Blame output ended before the contents of a line")
+		}
+		contents := strings.TrimPrefix(lines[i], "\t")
+		result = append(result, Line{Revision(headerFields[0]), fileName, lineNumber, contents})
+	}
+	return result, nil
+}
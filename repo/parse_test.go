@@ -0,0 +1,209 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	blobHash = "4444444444444444444444444444444444444444"
+)
+
+func TestParseTreeEntries(t *testing.T) {
+	out := "100644 blob " + blobHash + "\twith space.txt\x00" +
+		"100755 blob " + blobHash + "\ttab\there.txt\x00" +
+		"100644 blob " + blobHash + "\tnaïve/\"quoted\".txt\x00" +
+		"160000 commit " + masterHash + "\tsubmodule\x00"
+	expected := []treeEntry{
+		{"100644", "blob", blobHash, "with space.txt"},
+		{"100755", "blob", blobHash, "tab\there.txt"},
+		{"100644", "blob", blobHash, "naïve/\"quoted\".txt"},
+		{"160000", "commit", masterHash, "submodule"},
+	}
+	entries, err := parseTreeEntries(out)
+	if err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, but saw %v, %v", expected, entries, err)
+	}
+	for _, malformed := range []string{"100644 blob " + blobHash, "100644 " + blobHash + "\tfile", "\x00\x00"} {
+		if entries, err := parseTreeEntries(malformed); err == nil {
+			t.Errorf("Expected %q to be rejected, but saw %v", malformed, entries)
+		}
+	}
+}
+
+func TestParseIndexEntries(t *testing.T) {
+	out := "100644 " + blobHash + " 0\tsome file\x00100644 " + blobHash + " 2\tconflict\x00"
+	expected := []indexEntry{
+		{"100644", blobHash, 0, "some file"},
+		{"100644", blobHash, 2, "conflict"},
+	}
+	entries, err := parseIndexEntries(out)
+	if err != nil || !reflect.DeepEqual(entries, expected) {
+		t.Errorf("Expected %v, but saw %v, %v", expected, entries, err)
+	}
+	if entries, err := parseIndexEntries("100644 " + blobHash + " x\tfile\x00"); err == nil {
+		t.Errorf("Expected a malformed stage to be rejected, but saw %v", entries)
+	}
+}
+
+func TestParseConfigValues(t *testing.T) {
+	out := "remote.origin.url\ngit@github.com:example/project.git\x00remote.empty.url\x00"
+	expected := []configValue{
+		{"remote.origin.url", "git@github.com:example/project.git"},
+		{"remote.empty.url", ""},
+	}
+	if values := parseConfigValues(out); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected %v, but saw %v", expected, values)
+	}
+}
+
+func TestParseBlameOutput(t *testing.T) {
+	out := strings.Join([]string{
+		masterHash + " 3 1 1",
+		"author Alice",
+		"summary Add a file",
+		"filename \"tab\\there \\303\\257.txt\"",
+		"\t// TODO: write main ",
+		featureHash + " 1 2",
+		"author Bob",
+		"previous " + masterHash + " old name.txt",
+		"filename new name.txt",
+		"\t\tindented TODO",
+	}, "\n") + "\n"
+	expected := []Line{
+		{masterHash, "tab\there ï.txt", 3, "// TODO: write main "},
+		{featureHash, "new name.txt", 1, "\tindented TODO"},
+	}
+	lines, err := parseBlameOutput("main.go", out)
+	if err != nil || !reflect.DeepEqual(lines, expected) {
+		t.Errorf("Expected %v, but saw %v, %v", expected, lines, err)
+	}
+	for _, malformed := range []string{
+		masterHash + " 3 1 1\nauthor Alice\n",
+		masterHash + "\n\tcontents\n",
+		"not-a-hash 1 1 1\n\tcontents\n",
+		masterHash + " 0 1 1\n\tcontents\n",
+		masterHash + " 1 1 1\nfilename \"unterminated\n\tcontents\n",
+	} {
+		if lines, err := parseBlameOutput("main.go", malformed); err == nil {
+			t.Errorf("Expected %q to be rejected, but saw %v", malformed, lines)
+		}
+	}
+}
+
+func FuzzParseTreeEntries(f *testing.F) {
+	f.Add("100644 blob " + blobHash + "\twith space.txt\x00")
+	f.Add("160000 commit " + masterHash + "\tsub\tmodule\x00100644 blob " + blobHash + "\tfile")
+	f.Add("\x00")
+	f.Fuzz(func(t *testing.T, out string) {
+		entries, err := parseTreeEntries(out)
+		if err != nil {
+			return
+		}
+		// Listing the entries again should give back the same entries.
+		var relisted strings.Builder
+		for _, entry := range entries {
+			fmt.Fprintf(&relisted, "%s %s %s\t%s\x00", entry.Mode, entry.Type, entry.Object, entry.Path)
+		}
+		reparsed, err := parseTreeEntries(relisted.String())
+		if err != nil || !reflect.DeepEqual(reparsed, entries) {
+			t.Errorf("Expected %v to be parsed again, but saw %v, %v", entries, reparsed, err)
+		}
+	})
+}
+
+func FuzzParseIndexEntries(f *testing.F) {
+	f.Add("100644 " + blobHash + " 0\tsome file\x00")
+	f.Add("100644 " + blobHash + " 3\ta\x00100644 " + blobHash + " -1\tb\x00")
+	f.Fuzz(func(t *testing.T, out string) {
+		entries, err := parseIndexEntries(out)
+		if err != nil {
+			return
+		}
+		for _, entry := range entries {
+			if entry.Stage < 0 || entry.Stage > 3 || entry.Path == "" {
+				t.Errorf("Expected a valid entry, but saw %v", entry)
+			}
+		}
+	})
+}
+
+func FuzzParseConfigValues(f *testing.F) {
+	f.Add("remote.origin.url\nhttps://github.com/example/project.git\x00")
+	f.Add("key\x00\x00key\nvalue\nwith newline")
+	f.Fuzz(func(t *testing.T, out string) {
+		for _, value := range parseConfigValues(out) {
+			if strings.Contains(value.Key, "\n") || strings.Contains(value.Key+value.Value, "\x00") {
+				t.Errorf("Expected the records to be split, but saw %v", value)
+			}
+		}
+	})
+}
+
+func FuzzParseBlameOutput(f *testing.F) {
+	f.Add(masterHash + " 3 1 1\nauthor Alice\nfilename main.go\n\t// TODO: write main\n")
+	f.Add(masterHash + " 1 1\nfilename \"\\303\\257\"\n\t\n" + featureHash + " 2 2\n\tTODO")
+	f.Add(masterHash + " 1 1 1\n")
+	f.Fuzz(func(t *testing.T, out string) {
+		lines, err := parseBlameOutput("main.go", out)
+		if err != nil {
+			return
+		}
+		if len(lines) > strings.Count(out, "\n\t")+1 {
+			t.Errorf("Expected at most one line per line of contents, but saw %v", lines)
+		}
+		for _, line := range lines {
+			if line.LineNumber < 1 || strings.Contains(line.Contents, "\n") ||
+				!hashRegexp.MatchString(string(line.Revision)) {
+				t.Errorf("Expected a valid line, but saw %v", line)
+			}
+		}
+	})
+}
+
+func FuzzParseBranchRefs(f *testing.F) {
+	f.Add("*\x00refs/heads/master\x00\x00commit\x00" + masterHash + "\x001400000000\x00Alice\x00\x00\x00\x00\x000 0")
+	f.Add(" \x00refs/tags/v1\x00\x00tag\x00" + tagHash + "\x00\x00\x00commit\x00" + masterHash + "\x00x\x00Bob\n")
+	f.Fuzz(func(t *testing.T, out string) {
+		aliases := parseBranchRefs(out)
+		for _, alias := range aliases {
+			if !hashRegexp.MatchString(string(alias.Revision)) {
+				t.Errorf("Expected every revision to be a hash, but saw %v", alias)
+			}
+		}
+	})
+}
+
+func FuzzParseRevisions(f *testing.F) {
+	f.Add(masterHash + "\x00" + featureHash + "\x00")
+	f.Add(masterHash + "\n")
+	f.Fuzz(func(t *testing.T, out string) {
+		revisions, err := parseRevisions(out)
+		if err != nil {
+			return
+		}
+		for _, revision := range revisions {
+			if !hashRegexp.MatchString(string(revision)) {
+				t.Errorf("Expected every revision to be a hash, but saw %q", revision)
+			}
+		}
+	})
+}
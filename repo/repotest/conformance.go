@@ -0,0 +1,511 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repotest
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	conformanceTodoRegex = "TODO"
+)
+
+// Create the repository under test for the git checkout in the given directory, which
+// should list the refs matching the given patterns, as for repo.NewGitRepository. The
+// repository must not read the directory in the background, since it is removed as
+// soon as the test finishes.
+type RepositoryFactory func(dirPath, refPatterns string) (repo.Repository, error)
+
+// Build the history used by the conformance tests, which is:
+//
+//	add-main --- add-notes --- document-main --- merge-feature --- add-test-todo  (master)
+//	                |  (v1)                         /
+//	                +--- resolve-note --- rename-notes  (feature)
+//
+// The revisions are returned keyed by those names.
+func newConformanceFixture(t *testing.T) (*Fixture, map[string]repo.Revision) {
+	fixture := NewFixture(t)
+	revisions := make(map[string]repo.Revision)
+	revisions["add-main"] = fixture.Commit("master", "Add main", map[string]string{
+		"main.go": "package main\n\n// TODO: write main\n",
+	})
+	revisions["add-notes"] = fixture.Commit("master", "Add the notes\n\nThey are not written yet.", map[string]string{
+		"notes.txt": "TODO: first note\nTODO: second note\n",
+	})
+	fixture.Tag("v1", revisions["add-notes"], "The first release")
+	fixture.Branch("feature", "master")
+	revisions["resolve-note"] = fixture.Commit("feature", "Resolve the first note", map[string]string{
+		"notes.txt": "Done\nTODO: second note\n",
+	})
+	revisions["rename-notes"] = fixture.Rename("feature", "Move the notes", "notes.txt", "docs/notes.txt")
+	revisions["document-main"] = fixture.Commit("master", "Document main", map[string]string{
+		"main.go": "// The main package.\npackage main\n\n// TODO: write main\n",
+	})
+	revisions["merge-feature"] = fixture.Merge("master", "feature", "Merge the feature")
+	revisions["add-test-todo"] = fixture.Commit("master", "Add a TODO for tests", map[string]string{
+		"main.go": "// The main package.\npackage main\n\n// TODO: write main\n// TODO: test main\n",
+	})
+	return fixture, revisions
+}
+
+// Sort TODOs by file name and line number, for comparing TODOs that were not
+// loaded in any particular order.
+func sortTodos(todos []repo.Line) {
+	sort.Slice(todos, func(i, j int) bool {
+		if todos[i].FileName != todos[j].FileName {
+			return todos[i].FileName < todos[j].FileName
+		}
+		return todos[i].LineNumber < todos[j].LineNumber
+	})
+}
+
+// Run every method of the repo.Repository interface against repositories created by
+// the given factory, for a scripted history with branches, tags, merges, renames, and
+// TODOs that are added and removed. Each method is run as a separate subtest.
+func RunConformanceTests(t *testing.T, newRepository RepositoryFactory) {
+	fixture, revisions := newConformanceFixture(t)
+	repository, err := newRepository(fixture.Dir, "refs/heads/,refs/tags/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	master := revisions["add-test-todo"]
+	feature := revisions["rename-notes"]
+	masterTodos := []repo.Line{
+		{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 2, Contents: "TODO: second note"},
+		{Revision: revisions["add-main"], FileName: "main.go", LineNumber: 3, Contents: "// TODO: write main"},
+		{Revision: revisions["add-test-todo"], FileName: "main.go", LineNumber: 5, Contents: "// TODO: test main"},
+	}
+
+	t.Run("GetRepoPath", func(t *testing.T) {
+		if repository.GetRepoPath() != fixture.Dir {
+			t.Errorf("Expected the path %s, but saw %s", fixture.Dir, repository.GetRepoPath())
+		}
+		if repository.GetRepoId() == "" || repository.GetRepoId() != repository.GetRepoId() {
+			t.Errorf("Expected a stable, non-empty ID, but saw %q", repository.GetRepoId())
+		}
+	})
+
+	t.Run("ListBranches", func(t *testing.T) {
+		expected := []repo.Alias{
+			{Branch: "feature", Revision: feature, Type: repo.BranchRef,
+				LastModified: fixture.Timestamp(feature), LastModifiedBy: FixtureAuthorName,
+				Behind: 3, Base: "master"},
+			{Branch: "master", Revision: master, Type: repo.BranchRef,
+				LastModified: fixture.Timestamp(master), LastModifiedBy: FixtureAuthorName, Base: "master"},
+			{Branch: "tags/v1", Revision: revisions["add-notes"], Type: repo.TagRef,
+				LastModified:   fixture.Timestamp(revisions["add-notes"]),
+				LastModifiedBy: FixtureAuthorName, Behind: 5, Base: "master"},
+		}
+		aliases := repository.ListBranches()
+		for i := range aliases {
+			// The TODOs might or might not have been counted yet.
+			if aliases[i].TodoCount < -1 {
+				t.Errorf("Expected the TODO count of %s to be at least -1", aliases[i].Branch)
+			}
+			aliases[i].TodoCount = 0
+		}
+		if !reflect.DeepEqual(aliases, expected) {
+			t.Errorf("Expected the branches %v, but saw %v", expected, aliases)
+		}
+	})
+
+	t.Run("DefaultBranch", func(t *testing.T) {
+		branchesFixture := NewFixture(t)
+		base := branchesFixture.Commit("master", "Add main", map[string]string{"main.go": "package main\n"})
+		branchesFixture.Branch("feature", "master")
+		// Committing to the feature branch leaves it checked out, which should not
+		// change what the branches are compared with.
+		feature := branchesFixture.Commit("feature", "Add a TODO", map[string]string{
+			"main.go": "package main\n\n// TODO: write main\n",
+		})
+		branchesRepository, err := newRepository(branchesFixture.Dir, "refs/heads/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkCounts := func(expectedBase string, expected map[string][2]int) {
+			for _, alias := range branchesRepository.ListBranches() {
+				counts := [2]int{alias.Ahead, alias.Behind}
+				if alias.Base != expectedBase || counts != expected[alias.Branch] {
+					t.Errorf("Expected %s to be %v ahead and behind %s, but saw %v ahead and behind %s",
+						alias.Branch, expected[alias.Branch], expectedBase, counts, alias.Base)
+				}
+			}
+		}
+		checkCounts("master", map[string][2]int{"master": {0, 0}, "feature": {1, 0}})
+
+		// The remote's default branch, when known, is preferred to a local master.
+		branchesFixture.Git("update-ref", "refs/remotes/origin/feature", string(feature))
+		branchesFixture.Git("update-ref", "refs/remotes/origin/master", string(base))
+		branchesFixture.Git("symbolic-ref", "refs/remotes/origin/HEAD", "refs/remotes/origin/feature")
+		checkCounts("remotes/origin/feature", map[string][2]int{"master": {0, 1}, "feature": {0, 0}})
+	})
+
+	t.Run("ListUncommitted", func(t *testing.T) {
+		for _, alias := range repository.ListUncommitted() {
+			if alias.Type != repo.UncommittedRef || !repo.IsUncommitted(alias.Revision) {
+				t.Errorf("Expected only uncommitted pseudo-revisions, but saw %v", alias)
+			}
+		}
+	})
+
+	t.Run("IsAncestor", func(t *testing.T) {
+		for _, test := range []struct {
+			ancestor, descendant string
+			expected             bool
+		}{
+			{"add-main", "add-test-todo", true},
+			{"rename-notes", "add-test-todo", true},
+			{"add-test-todo", "add-test-todo", true},
+			{"document-main", "rename-notes", false},
+			{"add-test-todo", "add-main", false},
+		} {
+			isAncestor := repository.IsAncestor(revisions[test.ancestor], revisions[test.descendant])
+			if isAncestor != test.expected {
+				t.Errorf("Expected %s being an ancestor of %s to be %v",
+					test.ancestor, test.descendant, test.expected)
+			}
+		}
+	})
+
+	t.Run("ReadFirstParentHistory", func(t *testing.T) {
+		expected := []repo.Revision{master, revisions["merge-feature"], revisions["document-main"],
+			revisions["add-notes"], revisions["add-main"]}
+		if firstParents := repository.ReadFirstParentHistory(master, 10); !reflect.DeepEqual(firstParents, expected) {
+			t.Errorf("Expected the history %v, but saw %v", expected, firstParents)
+		}
+		if firstParents := repository.ReadFirstParentHistory(master, 2); !reflect.DeepEqual(firstParents, expected[:2]) {
+			t.Errorf("Expected the history %v, but saw %v", expected[:2], firstParents)
+		}
+	})
+
+	t.Run("ReadRevisionContents", func(t *testing.T) {
+		for revision, expected := range map[repo.Revision][]string{
+			master:                {"docs/notes.txt", "main.go"},
+			feature:               {"docs/notes.txt", "main.go"},
+			revisions["add-main"]: {"main.go"},
+		} {
+			contents := repository.ReadRevisionContents(revision)
+			if contents.Revision != revision || !reflect.DeepEqual(contents.Paths, expected) {
+				t.Errorf("Expected the paths %v in %s, but saw %v", expected, revision, contents)
+			}
+		}
+	})
+
+	t.Run("ReadRevisionMetadata", func(t *testing.T) {
+		revision := revisions["add-notes"]
+		expected := repo.RevisionMetadata{
+			Revision:    revision,
+			Timestamp:   fixture.Timestamp(revision),
+			Subject:     "Add the notes",
+			AuthorName:  FixtureAuthorName,
+			AuthorEmail: FixtureAuthorEmail,
+		}
+		if metadata := repository.ReadRevisionMetadata(revision); metadata != expected {
+			t.Errorf("Expected the metadata %v, but saw %v", expected, metadata)
+		}
+	})
+
+	t.Run("ReadFileSnippetAtRevision", func(t *testing.T) {
+		expected := "// TODO: write main\n// TODO: test main\n"
+		if snippet := repository.ReadFileSnippetAtRevision(master, "main.go", 4, 6); snippet != expected {
+			t.Errorf("Expected the snippet %q, but saw %q", expected, snippet)
+		}
+		expected = "// The main package.\npackage main\n\n// TODO: write main\n// TODO: test main\n"
+		if snippet := repository.ReadFileSnippetAtRevision(master, "main.go", 0, -1); snippet != expected {
+			t.Errorf("Expected the whole file %q, but saw %q", expected, snippet)
+		}
+	})
+
+	t.Run("GrepRevision", func(t *testing.T) {
+		grepFixture := NewFixture(t)
+		revision := grepFixture.Commit("master", "Add flags", map[string]string{
+			"flags.go":  "package flags\n\nvar useOldParser = flag.Bool(\"use_old_parser\")\n",
+			"logo.png":  "\x89PNG\x00use_old_parser\n",
+			"notes.txt": "TODO: remove when flag use_new_parser is gone\n",
+		})
+		grepRepository, err := newRepository(grepFixture.Dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines, err := grepRepository.GrepRevision(revision, []string{"use_old_parser", "use_new_parser"})
+		sort.Strings(lines)
+		expected := []string{
+			"TODO: remove when flag use_new_parser is gone",
+			"var useOldParser = flag.Bool(\"use_old_parser\")",
+		}
+		if err != nil || !reflect.DeepEqual(lines, expected) {
+			t.Errorf("Expected the lines %q, skipping the binary file, but saw %q, %v", expected, lines, err)
+		}
+		if lines, err := grepRepository.GrepRevision(revision, []string{"use_no_parser"}); err != nil || len(lines) != 0 {
+			t.Errorf("Expected no lines, but saw %q, %v", lines, err)
+		}
+	})
+
+	t.Run("LoadRevisionTodos", func(t *testing.T) {
+		todos := repository.LoadRevisionTodos(master, conformanceTodoRegex, "")
+		sortTodos(todos)
+		expected := append([]repo.Line{}, masterTodos...)
+		sortTodos(expected)
+		if !reflect.DeepEqual(todos, expected) {
+			t.Errorf("Expected the TODOs %v, but saw %v", expected, todos)
+		}
+		// The TODO on the renamed file is traced back to its original path.
+		expected = []repo.Line{{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 2, Contents: "TODO: second note"}}
+		if todos := repository.LoadRevisionTodos(feature, conformanceTodoRegex, "^main"); !reflect.DeepEqual(todos, expected) {
+			t.Errorf("Expected the TODOs %v, but saw %v", expected, todos)
+		}
+	})
+
+	t.Run("StreamRevisionTodos", func(t *testing.T) {
+		var todos []repo.Line
+		var last repo.ScanProgress
+		for progress := range repository.StreamRevisionTodos(
+			revisions["add-notes"], conformanceTodoRegex, "") {
+			todos = append(todos, progress.Todos...)
+			last = progress
+		}
+		if last.FilesDone != last.FilesTotal {
+			t.Errorf("Expected the last progress to be complete, but saw %v", last)
+		}
+		expected := []repo.Line{
+			{Revision: revisions["add-main"], FileName: "main.go", LineNumber: 3, Contents: "// TODO: write main"},
+			{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 1, Contents: "TODO: first note"},
+			{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 2, Contents: "TODO: second note"},
+		}
+		sortTodos(todos)
+		if !reflect.DeepEqual(todos, expected) {
+			t.Errorf("Expected the TODOs %v, but saw %v", expected, todos)
+		}
+	})
+
+	t.Run("LoadFileTodos", func(t *testing.T) {
+		expected := masterTodos[1:]
+		if todos := repository.LoadFileTodos(master, "main.go", conformanceTodoRegex); !reflect.DeepEqual(todos, expected) {
+			t.Errorf("Expected the TODOs %v, but saw %v", expected, todos)
+		}
+	})
+
+	t.Run("FindClosingRevisions", func(t *testing.T) {
+		removed := repo.TodoId{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 1}
+		expected := []repo.Revision{revisions["resolve-note"]}
+		if closing, err := repository.FindClosingRevisions(removed); err != nil || !reflect.DeepEqual(closing, expected) {
+			t.Errorf("Expected %v to be closed by %v, but saw %v, %v", removed, expected, closing, err)
+		}
+		open := repo.TodoId{Revision: revisions["add-main"], FileName: "main.go", LineNumber: 3}
+		if closing, err := repository.FindClosingRevisions(open); err != nil || len(closing) != 0 {
+			t.Errorf("Expected %v to still be open, but saw it closed by %v, %v", open, closing, err)
+		}
+		for _, lineNumber := range []int{0, 100} {
+			missing := repo.TodoId{Revision: revisions["add-main"], FileName: "main.go", LineNumber: lineNumber}
+			if closing, err := repository.FindClosingRevisions(missing); err == nil {
+				t.Errorf("Expected line %d to be rejected, but saw it closed by %v", lineNumber, closing)
+			}
+		}
+	})
+
+	t.Run("ConcurrentReads", func(t *testing.T) {
+		// A new repository has nothing cached, so every request reads the objects.
+		fresh, err := newRepository(fixture.Dir, "refs/heads/,refs/tags/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed := repo.TodoId{Revision: revisions["add-notes"], FileName: "notes.txt", LineNumber: 1}
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				expected := masterTodos[1:]
+				if todos := fresh.LoadFileTodos(master, "main.go", conformanceTodoRegex); !reflect.DeepEqual(todos, expected) {
+					t.Errorf("Expected the TODOs %v, but saw %v", expected, todos)
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				expected := []repo.Revision{revisions["resolve-note"]}
+				if closing, err := fresh.FindClosingRevisions(removed); err != nil || !reflect.DeepEqual(closing, expected) {
+					t.Errorf("Expected %v to be closed by %v, but saw %v, %v", removed, expected, closing, err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("ValidateRevision", func(t *testing.T) {
+		for name, expected := range map[string]repo.Revision{
+			"master":                           master,
+			"feature":                          feature,
+			"v1":                               revisions["add-notes"],
+			"master~1":                         revisions["merge-feature"],
+			string(master):                     master,
+			string(revisions["add-main"])[:10]: revisions["add-main"],
+		} {
+			if revision, err := repository.ValidateRevision(name); err != nil || revision != expected {
+				t.Errorf("Expected %s to resolve to %s, but saw %s, %v", name, expected, revision, err)
+			}
+		}
+		tree := fixture.Git("rev-parse", "master^{tree}")
+		for _, name := range []string{"", "-n", "missing", "master\n", tree} {
+			if revision, err := repository.ValidateRevision(name); err == nil {
+				t.Errorf("Expected %q to be rejected, but saw %s", name, revision)
+			}
+		}
+	})
+
+	t.Run("ValidatePathAtRevision", func(t *testing.T) {
+		if err := repository.ValidatePathAtRevision(master, "main.go"); err != nil {
+			t.Error(err)
+		}
+		for _, path := range []string{"notes.txt", "ain.go", "docs", ""} {
+			if err := repository.ValidatePathAtRevision(master, path); err == nil {
+				t.Errorf("Expected the path %q to be rejected", path)
+			}
+		}
+	})
+
+	t.Run("ValidateLineNumberInPathAtRevision", func(t *testing.T) {
+		if err := repository.ValidateLineNumberInPathAtRevision(master, "main.go", 5); err != nil {
+			t.Error(err)
+		}
+		for _, lineNumber := range []int{-1, 0, 6} {
+			if err := repository.ValidateLineNumberInPathAtRevision(master, "main.go", lineNumber); err == nil {
+				t.Errorf("Expected line %d to be rejected", lineNumber)
+			}
+		}
+	})
+
+	t.Run("UnusualPaths", func(t *testing.T) {
+		pathsFixture := NewFixture(t)
+		files := map[string]string{
+			"naïve.txt":      "TODO: accents\n",
+			"say \"hi\".txt": "TODO: quotes\n",
+			"tab\there.txt":  "TODO: tabs\n",
+			"with space.txt": "TODO: spaces\n",
+		}
+		revision := pathsFixture.Commit("master", "Add files with unusual names", files)
+		pathsRepository, err := newRepository(pathsFixture.Dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedPaths := []string{"naïve.txt", "say \"hi\".txt", "tab\there.txt", "with space.txt"}
+		if contents := pathsRepository.ReadRevisionContents(revision); !reflect.DeepEqual(contents.Paths, expectedPaths) {
+			t.Errorf("Expected the paths %q, but saw %q", expectedPaths, contents.Paths)
+		}
+		var expectedTodos []repo.Line
+		for _, path := range expectedPaths {
+			contents := strings.TrimSuffix(files[path], "\n")
+			expectedTodos = append(expectedTodos,
+				repo.Line{Revision: revision, FileName: path, LineNumber: 1, Contents: contents})
+			if err := pathsRepository.ValidatePathAtRevision(revision, path); err != nil {
+				t.Error(err)
+			}
+			if snippet := pathsRepository.ReadFileSnippetAtRevision(revision, path, 1, 2); snippet != files[path] {
+				t.Errorf("Expected the snippet %q, but saw %q", files[path], snippet)
+			}
+		}
+		todos := pathsRepository.LoadRevisionTodos(revision, conformanceTodoRegex, "")
+		if !reflect.DeepEqual(todos, expectedTodos) {
+			t.Errorf("Expected the TODOs %v, but saw %v", expectedTodos, todos)
+		}
+	})
+
+	t.Run("SubstringPaths", func(t *testing.T) {
+		// Each path is a substring of the ones listed before it.
+		substringsFixture := NewFixture(t)
+		files := map[string]string{
+			"Metadata.go": "// TODO: read the metadata\n",
+			"data.go":     "package data\n\n// TODO: load the data\n",
+			"a.go":        "package a\n// TODO: write a\n",
+		}
+		revision := substringsFixture.Commit("master", "Add files with similar names", files)
+		substringsRepository, err := newRepository(substringsFixture.Dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, contents := range files {
+			if snippet := substringsRepository.ReadFileSnippetAtRevision(revision, path, 1, -1); snippet != contents {
+				t.Errorf("Expected the contents of %s to be %q, but saw %q", path, contents, snippet)
+			}
+			lineNumber := strings.Count(contents, "\n")
+			todoId := repo.TodoId{Revision: revision, FileName: path, LineNumber: lineNumber}
+			expected := []repo.Line{{Revision: revision, FileName: path, LineNumber: lineNumber,
+				Contents: strings.Split(contents, "\n")[lineNumber-1]}}
+			if todos := substringsRepository.LoadFileTodos(revision, path, conformanceTodoRegex); !reflect.DeepEqual(todos, expected) {
+				t.Errorf("Expected the TODOs %v in %s, but saw %v", expected, path, todos)
+			}
+			details := repo.LoadTodoDetails(substringsRepository, todoId, lineNumber-1, 0)
+			if details.Context != contents {
+				t.Errorf("Expected the context of %v to be %q, but saw %q", todoId, contents, details.Context)
+			}
+			if err := substringsRepository.ValidateLineNumberInPathAtRevision(revision, path, lineNumber+1); err == nil {
+				t.Errorf("Expected line %d of %s to be rejected", lineNumber+1, path)
+			}
+		}
+		if err := substringsRepository.ValidatePathAtRevision(revision, "ata.go"); err == nil {
+			t.Errorf("Expected a substring of a path to be rejected")
+		}
+	})
+
+	t.Run("Submodules", func(t *testing.T) {
+		libFixture := NewFixture(t)
+		libRevision := libFixture.Commit("master", "Add the library", map[string]string{
+			"lib.go": "// TODO: write the library\n",
+		})
+		superFixture := NewFixture(t)
+		superFixture.Commit("master", "Add the main file", map[string]string{
+			"main.go": "// TODO: use the library\n",
+		})
+		revision := superFixture.AddSubmodule("master", "Add the library", "third_party/lib", libFixture)
+		superRepository, err := newRepository(superFixture.Dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &repo.RevisionContents{
+			Revision:   revision,
+			Paths:      []string{".gitmodules", "main.go"},
+			Submodules: []repo.Submodule{{Path: "third_party/lib", Revision: libRevision}},
+		}
+		if contents := superRepository.ReadRevisionContents(revision); !reflect.DeepEqual(contents, expected) {
+			t.Errorf("Expected the contents %v, but saw %v", expected, contents)
+		}
+		todos := superRepository.LoadRevisionTodos(revision, conformanceTodoRegex, "")
+		if len(todos) != 1 || todos[0].FileName != "main.go" {
+			t.Errorf("Expected only the TODO in main.go, but saw %v", todos)
+		}
+		if err := superRepository.ValidatePathAtRevision(revision, "third_party/lib"); err == nil {
+			t.Errorf("Expected a submodule to be rejected as a path")
+		}
+	})
+
+	t.Run("GetBrowseUrl", func(t *testing.T) {
+		if browseUrl := repository.GetBrowseUrl(master, "main.go", 4); !strings.HasPrefix(browseUrl, "/raw?") {
+			t.Errorf("Expected a raw URL without any remotes, but saw %s", browseUrl)
+		}
+		fixture.Git("remote", "add", "origin", "git@github.com:example/project.git")
+		expected := fmt.Sprintf("https://github.com/example/project/blob/%s/main.go#L4", master)
+		if browseUrl := repository.GetBrowseUrl(master, "main.go", 4); browseUrl != expected {
+			t.Errorf("Expected the URL %s, but saw %s", expected, browseUrl)
+		}
+	})
+}
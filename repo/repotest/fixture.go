@@ -0,0 +1,199 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repotest
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	// The time of the first commit made by a Fixture. Each later commit is made a
+	// minute after the one before it.
+	FixtureStartTime = 1400000000
+	// The author and committer of every commit made by a Fixture.
+	FixtureAuthorName  = "Alice"
+	FixtureAuthorEmail = "alice@example.com"
+)
+
+// A temporary git repository whose history is scripted by a test, one commit at a time.
+// Every commit is made at a fixed time, so its hash is the same each time the test runs.
+// The repository starts out empty, with "master" as its current branch. Any failure
+// ends the test.
+type Fixture struct {
+	// The directory of the repository's working tree.
+	Dir string
+
+	t          testing.TB
+	time       int64
+	timestamps map[repo.Revision]int64
+}
+
+// Create an empty repository in a temporary directory, which is removed once the test
+// finishes. The test is skipped if git is not installed.
+func NewFixture(t testing.TB) *Fixture {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	fixture := &Fixture{
+		Dir:        t.TempDir(),
+		t:          t,
+		time:       FixtureStartTime - 60,
+		timestamps: make(map[repo.Revision]int64),
+	}
+	fixture.Git("init", "-q")
+	fixture.Git("symbolic-ref", "HEAD", "refs/heads/master")
+	return fixture
+}
+
+// Run git in the repository, isolated from the user's configuration, and return its
+// output without any surrounding whitespace.
+func (fixture *Fixture) Git(args ...string) string {
+	fixture.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = fixture.Dir
+	cmd.Env = append(os.Environ(),
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+fixture.Dir,
+		"GIT_AUTHOR_NAME="+FixtureAuthorName, "GIT_AUTHOR_EMAIL="+FixtureAuthorEmail,
+		"GIT_COMMITTER_NAME="+FixtureAuthorName, "GIT_COMMITTER_EMAIL="+FixtureAuthorEmail,
+		fmt.Sprintf("GIT_AUTHOR_DATE=%d +0000", fixture.time),
+		fmt.Sprintf("GIT_COMMITTER_DATE=%d +0000", fixture.time))
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		fixture.t.Fatalf("git %v failed: %v\n%s", args, err, stderr.String())
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Get the time at which a revision made by the fixture was committed.
+func (fixture *Fixture) Timestamp(revision repo.Revision) int64 {
+	fixture.t.Helper()
+	timestamp, ok := fixture.timestamps[revision]
+	if !ok {
+		fixture.t.Fatalf("Revision %s was not made by the fixture", revision)
+	}
+	return timestamp
+}
+
+// Check out the given branch, which must either exist or be the current branch.
+func (fixture *Fixture) checkout(branch string) {
+	fixture.t.Helper()
+	if fixture.Git("symbolic-ref", "--short", "HEAD") != branch {
+		fixture.Git("checkout", "-q", branch)
+	}
+}
+
+// Run a git command that makes a commit, at a minute after the previous one.
+func (fixture *Fixture) commitWith(args ...string) repo.Revision {
+	fixture.t.Helper()
+	fixture.time += 60
+	fixture.Git(args...)
+	revision := repo.Revision(fixture.Git("rev-parse", "HEAD"))
+	fixture.timestamps[revision] = fixture.time
+	return revision
+}
+
+// Commit on the given branch, adding or replacing the given files, keyed by path.
+func (fixture *Fixture) Commit(branch, message string, files map[string]string) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	for path, contents := range files {
+		fullPath := filepath.Join(fixture.Dir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			fixture.t.Fatal(err)
+		}
+		if err := os.WriteFile(fullPath, []byte(contents), 0644); err != nil {
+			fixture.t.Fatal(err)
+		}
+	}
+	fixture.Git("add", "-A")
+	return fixture.commitWith("commit", "-q", "--allow-empty", "-m", message)
+}
+
+// Commit on the given branch, deleting the given files.
+func (fixture *Fixture) Delete(branch, message string, paths ...string) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	fixture.Git(append([]string{"rm", "-q", "--"}, paths...)...)
+	return fixture.commitWith("commit", "-q", "-m", message)
+}
+
+// Commit on the given branch, renaming a file without modifying it.
+func (fixture *Fixture) Rename(branch, message, oldPath, newPath string) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	fullPath := filepath.Join(fixture.Dir, filepath.FromSlash(newPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		fixture.t.Fatal(err)
+	}
+	fixture.Git("mv", "--", oldPath, newPath)
+	return fixture.commitWith("commit", "-q", "-m", message)
+}
+
+// Create a branch pointing at the given branch or revision.
+func (fixture *Fixture) Branch(name, from string) {
+	fixture.t.Helper()
+	fixture.Git("branch", name, from)
+}
+
+// Merge a branch or revision into the given branch, always making a merge commit.
+func (fixture *Fixture) Merge(branch, from, message string) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	return fixture.commitWith("merge", "-q", "--no-ff", "-m", message, from)
+}
+
+// Copy the changes of a revision onto the given branch. The copy keeps the original
+// author date and message.
+func (fixture *Fixture) CherryPick(branch string, revision repo.Revision) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	return fixture.commitWith("cherry-pick", string(revision))
+}
+
+// Commit on the given branch, adding another fixture's repository as a submodule at
+// the given path, pinned at its current revision.
+func (fixture *Fixture) AddSubmodule(branch, message, path string, submodule *Fixture) repo.Revision {
+	fixture.t.Helper()
+	fixture.checkout(branch)
+	fixture.Git("-c", "protocol.file.allow=always", "submodule", "add", "-q", submodule.Dir, path)
+	return fixture.commitWith("commit", "-q", "-m", message)
+}
+
+// Make a bare mirror of the repository, with all of its refs, at the given path.
+func (fixture *Fixture) Mirror(path string) {
+	fixture.t.Helper()
+	fixture.Git("clone", "-q", "--mirror", fixture.Dir, path)
+}
+
+// Tag a revision. The tag is annotated with the given message, unless it is empty.
+func (fixture *Fixture) Tag(name string, revision repo.Revision, message string) {
+	fixture.t.Helper()
+	if message == "" {
+		fixture.Git("tag", name, string(revision))
+	} else {
+		fixture.Git("tag", "-a", "-m", message, name, string(revision))
+	}
+}
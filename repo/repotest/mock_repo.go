@@ -19,6 +19,8 @@ package repotest
 import (
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/google/todo-tracks/repo"
 )
@@ -26,13 +28,36 @@ import (
 type MockRepository struct {
 	Aliases       []repo.Alias
 	RevisionTodos map[string][]repo.Line
+	// Optional pseudo-revisions for uncommitted changes.
+	Uncommitted []repo.Alias
+	// Optional first-parent histories, keyed by the revision they start from.
+	History map[string][]repo.Revision
+	// Optional file contents, keyed by path, shared by every revision.
+	Files map[string]string
+	// Optional revision metadata, keyed by revision.
+	Metadata map[string]repo.RevisionMetadata
+	// Optional names that resolve to revisions, such as branch names or abbreviated hashes.
+	Refs map[string]repo.Revision
+	// Optional submodules, shared by every revision.
+	Submodules []repo.Submodule
+	// Optional path of the repository, in place of "~/repo/path".
+	Path string
+	// Whether the revisions can change, as for plain directories.
+	MutableRevisions bool
 }
 
 func (repository MockRepository) GetRepoId() string {
 	return "repoID"
 }
 
+func (repository MockRepository) RevisionsAreImmutable() bool {
+	return !repository.MutableRevisions
+}
+
 func (repository MockRepository) GetRepoPath() string {
+	if repository.Path != "" {
+		return repository.Path
+	}
 	return "~/repo/path"
 }
 
@@ -40,37 +65,111 @@ func (repository MockRepository) ListBranches() []repo.Alias {
 	return repository.Aliases
 }
 
+func (repository MockRepository) ListUncommitted() []repo.Alias {
+	if repository.Uncommitted == nil {
+		return []repo.Alias{}
+	}
+	return repository.Uncommitted
+}
+
 func (repository MockRepository) IsAncestor(ancestor, descendant repo.Revision) bool {
 	return false
 }
 
+func (repository MockRepository) ReadFirstParentHistory(revision repo.Revision, maxCount int) []repo.Revision {
+	history, ok := repository.History[string(revision)]
+	if !ok {
+		history = []repo.Revision{revision}
+	}
+	if len(history) > maxCount {
+		history = history[:maxCount]
+	}
+	return history
+}
+
 func (repository MockRepository) ReadRevisionContents(revision repo.Revision) *repo.RevisionContents {
+	paths := make([]string, 0)
+	for path := range repository.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
 	return &repo.RevisionContents{
-		Revision: revision,
-		Paths:    make([]string, 0),
+		Revision:   revision,
+		Paths:      paths,
+		Submodules: repository.Submodules,
 	}
 }
 
 func (repository MockRepository) ReadRevisionMetadata(revision repo.Revision) repo.RevisionMetadata {
+	if metadata, ok := repository.Metadata[string(revision)]; ok {
+		return metadata
+	}
 	return repo.RevisionMetadata{
 		Revision: revision,
 	}
 }
 
 func (repository MockRepository) ReadFileSnippetAtRevision(revision repo.Revision, path string, startLine, endLine int) string {
-	return ""
+	lines := strings.SplitAfter(repository.Files[path], "\n")
+	if startLine < 1 {
+		startLine = 1
+	}
+	if endLine < 0 || endLine > len(lines)+1 {
+		endLine = len(lines) + 1
+	}
+	if startLine >= endLine {
+		return ""
+	}
+	return strings.Join(lines[startLine-1:endLine-1], "")
+}
+
+func (repository MockRepository) GrepRevision(revision repo.Revision, words []string) ([]string, error) {
+	lines := make([]string, 0)
+	for _, path := range repository.ReadRevisionContents(revision).Paths {
+		lines = append(lines, repo.GrepLines(repository.Files[path], words)...)
+	}
+	return lines, nil
 }
 
 func (repository MockRepository) LoadRevisionTodos(revision repo.Revision, todoRegex, excludePaths string) []repo.Line {
 	return repository.RevisionTodos[string(revision)]
 }
 
+func (repository MockRepository) LoadCachedRevisionTodos(revision repo.Revision) ([]repo.Line, bool) {
+	todos, ok := repository.RevisionTodos[string(revision)]
+	return todos, ok
+}
+
+// Stream the TODOs of the revision one file at a time, in the order of the files' first TODOs.
+func (repository MockRepository) StreamRevisionTodos(
+	revision repo.Revision, todoRegex, excludePaths string) <-chan repo.ScanProgress {
+	fileNames := make([]string, 0)
+	todosByFile := make(map[string][]repo.Line)
+	for _, todo := range repository.RevisionTodos[string(revision)] {
+		if _, ok := todosByFile[todo.FileName]; !ok {
+			fileNames = append(fileNames, todo.FileName)
+		}
+		todosByFile[todo.FileName] = append(todosByFile[todo.FileName], todo)
+	}
+	progress := make(chan repo.ScanProgress, len(fileNames))
+	for i, fileName := range fileNames {
+		progress <- repo.ScanProgress{
+			FileName:   fileName,
+			Todos:      todosByFile[fileName],
+			FilesDone:  i + 1,
+			FilesTotal: len(fileNames),
+		}
+	}
+	close(progress)
+	return progress
+}
+
 func (repository MockRepository) LoadFileTodos(revision repo.Revision, path string, todoRegex string) []repo.Line {
 	return make([]repo.Line, 0)
 }
 
-func (repository MockRepository) FindClosingRevisions(todoId repo.TodoId) []repo.Revision {
-	return nil
+func (repository MockRepository) FindClosingRevisions(todoId repo.TodoId) ([]repo.Revision, error) {
+	return nil, nil
 }
 
 func (repository MockRepository) GetBrowseUrl(revision repo.Revision, path string, lineNumber int) string {
@@ -78,6 +177,9 @@ func (repository MockRepository) GetBrowseUrl(revision repo.Revision, path strin
 }
 
 func (repository MockRepository) ValidateRevision(revisionString string) (repo.Revision, error) {
+	if revision, ok := repository.Refs[revisionString]; ok {
+		return revision, nil
+	}
 	if _, ok := repository.RevisionTodos[revisionString]; ok {
 		return repo.Revision(revisionString), nil
 	}
@@ -85,6 +187,9 @@ func (repository MockRepository) ValidateRevision(revisionString string) (repo.R
 }
 
 func (repository MockRepository) ValidatePathAtRevision(revision repo.Revision, path string) error {
+	if _, ok := repository.Files[path]; repository.Files != nil && !ok {
+		return errors.New(fmt.Sprintf("Path '%s' not found", path))
+	}
 	return nil
 }
 
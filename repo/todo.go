@@ -0,0 +1,127 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Category used for lines that do not start with one of the known markers.
+	DefaultTodoCategory = "TODO"
+)
+
+// Matches the marker at the start of a TODO, along with an optional parenthesized
+// owner list, e.g. "TODO(alice): ..." or "FIXME: ...".
+var todoMarkerRegexp = regexp.MustCompile(
+	`(?i)(^|[^[:alpha:]])(TODO|FIXME|XXX|HACK|BUG)(\(([^)]*)\))?`)
+
+// Return the category (e.g. "TODO" or "FIXME") of the given TODO line.
+func TodoCategory(contents string) string {
+	match := todoMarkerRegexp.FindStringSubmatch(contents)
+	if match == nil {
+		return DefaultTodoCategory
+	}
+	return strings.ToUpper(match[2])
+}
+
+// Return the text between the parentheses that follow the TODO marker, if any.
+func todoAnnotation(contents string) string {
+	match := todoMarkerRegexp.FindStringSubmatch(contents)
+	if match == nil {
+		return ""
+	}
+	return match[4]
+}
+
+// Return the owner named in the given TODO line (e.g. "alice" for "TODO(alice): ..."),
+// or the empty string if the TODO does not name an owner.
+func TodoOwner(contents string) string {
+	owner := strings.TrimSpace(strings.SplitN(todoAnnotation(contents), ",", 2)[0])
+	if todoDeadlineRegexp.MatchString(owner) {
+		// The annotation only has a due date, e.g. "TODO(2026-12-01): ..."
+		return ""
+	}
+	return owner
+}
+
+// Matches the due date formats recognized in TODOs. In order of the alternatives,
+// these are a day ("2026-12-01"), a quarter ("2027-Q1"), and a month ("2026-12").
+var todoDeadlineRegexp = regexp.MustCompile(
+	`(^|[^[:digit:]])([[:digit:]]{4})-(?:([[:digit:]]{2})-([[:digit:]]{2})|[Qq]([1-4])|([[:digit:]]{2}))($|[^[:digit:]])`)
+
+// Return the date by which the given TODO should be resolved, if it specifies one.
+//
+// The first date found after the TODO marker is used, and it may take any of the forms:
+//
+//	YYYY-MM-DD, e.g. "TODO(alice, 2026-12-01): ...", due on that day.
+//	YYYY-MM, e.g. "TODO: drop this in 2026-12", due on the last day of the month.
+//	YYYY-QN, e.g. "TODO: remove after 2027-Q1", due on the last day of the quarter.
+//
+// The returned time is midnight UTC at the start of the due date.
+func TodoDeadline(contents string) (time.Time, bool) {
+	if loc := todoMarkerRegexp.FindStringSubmatchIndex(contents); loc != nil {
+		// Skip the marker itself, but not the annotation that may follow it.
+		contents = contents[loc[5]:]
+	}
+	for {
+		loc := todoDeadlineRegexp.FindStringSubmatchIndex(contents)
+		if loc == nil {
+			return time.Time{}, false
+		}
+		match := make([]string, len(loc)/2)
+		for i := range match {
+			if loc[2*i] >= 0 {
+				match[i] = contents[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		// Resume after the date, but before the character that terminated it.
+		contents = contents[loc[14]:]
+		year, _ := strconv.Atoi(match[2])
+		if match[3] != "" {
+			month, _ := strconv.Atoi(match[3])
+			day, _ := strconv.Atoi(match[4])
+			deadline := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+			if deadline.Month() != time.Month(month) || deadline.Day() != day {
+				// Not a real date, such as "2026-02-30".
+				continue
+			}
+			return deadline, true
+		}
+		var lastMonth int
+		if match[5] != "" {
+			quarter, _ := strconv.Atoi(match[5])
+			lastMonth = quarter * 3
+		} else {
+			lastMonth, _ = strconv.Atoi(match[6])
+			if lastMonth < 1 || lastMonth > 12 {
+				continue
+			}
+		}
+		// Day 0 of the following month is the last day of lastMonth.
+		return time.Date(year, time.Month(lastMonth+1), 0, 0, 0, 0, 0, time.UTC), true
+	}
+}
+
+// Report whether the given TODO has a due date that ended before the given time.
+func IsTodoOverdue(contents string, now time.Time) bool {
+	deadline, ok := TodoDeadline(contents)
+	return ok && !now.Before(deadline.AddDate(0, 0, 1))
+}
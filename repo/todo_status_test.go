@@ -0,0 +1,90 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func branchNames(aliases []repo.Alias) []string {
+	names := make([]string, 0)
+	for _, alias := range aliases {
+		names = append(names, alias.Branch)
+	}
+	return names
+}
+
+func TestLoadTodoStatus(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	fixture.Commit("master", "Add the readme", map[string]string{
+		"readme.txt": "Nothing to do\n",
+	})
+	fixture.Branch("old", "master")
+	fixture.Branch("backport", "master")
+	addTodo := fixture.Commit("master", "Add main", map[string]string{
+		"main.go": "// TODO: write main\n",
+	})
+	fixture.Branch("fix", "master")
+	fixture.Branch("release", "master")
+	fixTodo := fixture.Commit("fix", "Write main", map[string]string{
+		"main.go": "// Done\n",
+	})
+	backportFix := fixture.CherryPick("release", fixTodo)
+	fixture.CherryPick("backport", addTodo)
+	fixture.Commit("master", "Add the docs", map[string]string{
+		"docs.txt": "Read the code\n",
+	})
+	todoId := repo.TodoId{Revision: addTodo, FileName: "main.go", LineNumber: 1}
+
+	for name, newRepository := range map[string]repotest.RepositoryFactory{
+		"git":    repo.NewGitRepositoryForTest,
+		"go-git": repo.NewGoGitRepositoryForTest,
+	} {
+		repository, err := newRepository(fixture.Dir, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedClosing := []repo.Revision{backportFix, fixTodo}
+		if closing, err := repository.FindClosingRevisions(todoId); err != nil || !reflect.DeepEqual(closing, expectedClosing) {
+			t.Errorf("Expected the %s repository to find the closing revisions %v, but saw %v, %v",
+				name, expectedClosing, closing, err)
+		}
+		status, err := repo.LoadTodoStatus(repository, todoId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			description string
+			aliases     []repo.Alias
+			expected    []string
+		}{
+			{"present", status.BranchesPresent, []string{"master"}},
+			{"removed", status.BranchesRemoved, []string{"fix", "release"}},
+			// The cherry-picked TODO is a different TODO.
+			{"missing", status.BranchesMissing, []string{"backport", "old"}},
+		} {
+			if names := branchNames(test.aliases); !reflect.DeepEqual(names, test.expected) {
+				t.Errorf("Expected the %s repository to find the TODO %s in %v, but saw %v",
+					name, test.description, test.expected, names)
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTodoOwnerAndCategory(t *testing.T) {
+	for contents, expected := range map[string][2]string{
+		"// TODO: no owner":                   {"TODO", ""},
+		"// TODO(alice): owned":               {"TODO", "alice"},
+		"# fixme(bob, 2026-12-01): due":       {"FIXME", "bob"},
+		"/* TODO(2026-12-01): only a date */": {"TODO", ""},
+	} {
+		category := TodoCategory(contents)
+		owner := TodoOwner(contents)
+		if category != expected[0] || owner != expected[1] {
+			t.Errorf("Expected %q to have category %q and owner %q, but saw %q and %q",
+				contents, expected[0], expected[1], category, owner)
+		}
+	}
+}
+
+func TestTodoDeadline(t *testing.T) {
+	for contents, expected := range map[string]string{
+		"// TODO(alice, 2026-12-01): ship it":  "2026-12-01",
+		"// TODO: remove after 2027-Q1":        "2027-03-31",
+		"// TODO: remove after 2027-q4":        "2027-12-31",
+		"// TODO: drop this in 2028-02":        "2028-02-29",
+		"// TODO: invalid 2026-02-30 2026-03":  "2026-03-31",
+		"// TODO: version 12026-12-01 is fine": "",
+		"// TODO: no date":                     "",
+		"2001-01-01 // TODO: before marker":    "",
+	} {
+		deadline, ok := TodoDeadline(contents)
+		if expected == "" {
+			if ok {
+				t.Errorf("Expected %q to have no deadline, but saw %v", contents, deadline)
+			}
+			continue
+		}
+		if !ok || deadline.Format("2006-01-02") != expected {
+			t.Errorf("Expected %q to have a deadline of %s, but saw %v", contents, expected, deadline)
+		}
+	}
+}
+
+func TestIsTodoOverdue(t *testing.T) {
+	contents := "// TODO(alice, 2026-12-01): ship it"
+	if IsTodoOverdue(contents, time.Date(2026, 12, 1, 23, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected %q to not be overdue on its due date", contents)
+	}
+	if !IsTodoOverdue(contents, time.Date(2026, 12, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected %q to be overdue after its due date", contents)
+	}
+}
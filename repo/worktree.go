@@ -0,0 +1,195 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// Pseudo-revisions naming the uncommitted contents of a checkout's working
+	// directory and index. TODOs on lines that are not committed yet are reported
+	// at these revisions, rather than at the revisions that last modified them.
+	WorktreeRevision Revision = "WORKTREE"
+	IndexRevision    Revision = "INDEX"
+
+	// The revision that git blame reports for lines that are not committed yet.
+	notCommittedRevision = "0000000000000000000000000000000000000000"
+)
+
+// Report whether the given revision is one of the pseudo-revisions for uncommitted changes.
+func IsUncommitted(revision Revision) bool {
+	return revision == WorktreeRevision || revision == IndexRevision
+}
+
+func (repository *gitRepository) ListUncommitted() []Alias {
+	out, err := repository.runGitCommand(exec.Command("git", "rev-parse", "--is-inside-work-tree"))
+	if err != nil || out != "true" {
+		// Bare repositories have neither a working directory nor an index.
+		return []Alias{}
+	}
+	aliases := make([]Alias, 0, 2)
+	for _, revision := range []Revision{WorktreeRevision, IndexRevision} {
+		aliases = append(aliases, Alias{
+			Branch:    string(revision),
+			Revision:  revision,
+			Type:      UncommittedRef,
+			TodoCount: -1,
+		})
+	}
+	return aliases
+}
+
+// List the files in the working directory, including untracked files that are not
+// ignored, or the files in the index.
+func (repository *gitRepository) readUncommittedPaths(revision Revision) []string {
+	args := []string{"ls-files", "-z", "--cached", "--others", "--exclude-standard"}
+	if revision == IndexRevision {
+		args = []string{"ls-files", "-z", "--stage"}
+	}
+	out, err := repository.runGitCommandWithoutTrim(exec.Command("git", args...))
+	if err != nil {
+		return []string{}
+	}
+	var candidatePaths []string
+	if revision == IndexRevision {
+		entries, err := parseIndexEntries(out)
+		if err != nil {
+			return []string{}
+		}
+		for _, entry := range entries {
+			// Skip submodules, and the entries for the other sides of unresolved
+			// merge conflicts.
+			if entry.Mode != "160000" && entry.Stage == 0 {
+				candidatePaths = append(candidatePaths, entry.Path)
+			}
+		}
+	} else {
+		for _, path := range splitNulTerminated(out) {
+			// Skip deleted files, symbolic links, and submodules.
+			if info, err := os.Lstat(filepath.Join(repository.DirPath, path)); err == nil &&
+				info.Mode().IsRegular() {
+				candidatePaths = append(candidatePaths, path)
+			}
+		}
+	}
+	seen := make(map[string]bool)
+	paths := make([]string, 0)
+	for _, path := range candidatePaths {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// Read the uncommitted contents of a file. Since these can change at any time,
+// failing to read them is not treated as fatal.
+func (repository *gitRepository) readUncommittedFile(revision Revision, path string) (string, error) {
+	if revision == IndexRevision {
+		// The ":<path>" syntax names the file in the index, and cannot be mistaken for an option.
+		return repository.runGitCommandWithoutTrim(exec.Command("git", "cat-file", "blob", ":"+path))
+	}
+	if strings.HasPrefix(filepath.Clean(path), "..") || filepath.IsAbs(path) {
+		return "", fmt.Errorf("Path '%s' is outside of the repository", path)
+	}
+	contents, err := os.ReadFile(filepath.Join(repository.DirPath, path))
+	return string(contents), err
+}
+
+func (repository *gitRepository) readUncommittedMetadata(revision Revision) RevisionMetadata {
+	authorName, _ := repository.runGitCommand(exec.Command("git", "config", "user.name"))
+	authorEmail, _ := repository.runGitCommand(exec.Command("git", "config", "user.email"))
+	return RevisionMetadata{
+		Revision:    revision,
+		Timestamp:   time.Now().Unix(),
+		Subject:     "Not committed yet",
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+	}
+}
+
+// Find the TODOs in the uncommitted contents of a file. The TODOs on lines that
+// are already committed are reported at the revisions that last modified them, as
+// for any other revision, and the rest are reported at the given pseudo-revision.
+func (repository *gitRepository) loadUncommittedFileTodos(
+	revision Revision, path string, todoRegex string) []Line {
+	contents, err := repository.readUncommittedFile(revision, path)
+	if err != nil {
+		return nil
+	}
+	var todoLines []int
+	blameArgs := []string{"blame", "--root", "--line-porcelain", "--contents", "-"}
+	rawLines := strings.Split(contents, "\n")
+	for lineNumber, lineContents := range rawLines {
+		matched, err := regexp.MatchString(todoRegex, lineContents)
+		if err == nil && matched {
+			todoLines = append(todoLines, lineNumber+1)
+			blameArgs = append(blameArgs, "-L", fmt.Sprintf("%d,+1", lineNumber+1))
+		}
+	}
+	if len(todoLines) == 0 {
+		return nil
+	}
+	// Blame the uncommitted contents against HEAD, which fails for files that are
+	// not in HEAD. In that case, none of the lines have been committed yet.
+	cmd := exec.Command("git", append(blameArgs, "--", path)...)
+	cmd.Stdin = strings.NewReader(contents)
+	out, err := repository.runGitCommandWithoutTrim(cmd)
+	var todos []Line
+	if err == nil {
+		todos = parseBlameOutputOrDie(path, out)
+	} else {
+		for _, lineNumber := range todoLines {
+			todos = append(todos, Line{notCommittedRevision, path, lineNumber, rawLines[lineNumber-1]})
+		}
+	}
+	for i := range todos {
+		if todos[i].Revision == notCommittedRevision {
+			todos[i].Revision = revision
+		}
+	}
+	return todos
+}
+
+// Scan the uncommitted files for TODOs. Unlike the TODOs of commits, these are never
+// cached, since the files can change at any time.
+func (repository *gitRepository) scanUncommittedTodos(
+	revision Revision, todoRegex, excludePaths string, progress chan<- ScanProgress) []Line {
+	revisionPaths := repository.loadRevisionPaths(revision, excludePaths)
+	var todos []Line
+	for i, path := range revisionPaths {
+		fileTodos := repository.loadUncommittedFileTodos(revision, path, todoRegex)
+		todos = append(todos, fileTodos...)
+		if progress != nil {
+			progress <- ScanProgress{
+				FileName:   path,
+				Todos:      fileTodos,
+				FilesDone:  i + 1,
+				FilesTotal: len(revisionPaths),
+			}
+		}
+	}
+	return todos
+}
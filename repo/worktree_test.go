@@ -0,0 +1,95 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package repo_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+)
+
+func sortLines(lines []repo.Line) {
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].FileName != lines[j].FileName {
+			return lines[i].FileName < lines[j].FileName
+		}
+		return lines[i].LineNumber < lines[j].LineNumber
+	})
+}
+
+func TestLoadUncommittedTodos(t *testing.T) {
+	fixture := repotest.NewFixture(t)
+	head := fixture.Commit("master", "Add the files", map[string]string{
+		"main.go": "package main\n\n// TODO: committed\n",
+		"lib.go":  "package lib\n",
+	})
+	writeFile := func(path, contents string) {
+		if err := os.WriteFile(filepath.Join(fixture.Dir, path), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A staged-only edit, and a staged file that is missing from HEAD.
+	writeFile("lib.go", "package lib\n\n// TODO: staged\n")
+	writeFile("staged.go", "// TODO: new file\n")
+	fixture.Git("add", "lib.go", "staged.go")
+	// An unstaged edit, and an untracked file.
+	writeFile("main.go", "package main\n\n// TODO: committed\n// TODO: unstaged\n")
+	writeFile("notes.txt", "TODO: untracked\n")
+
+	repository, err := repo.NewGitRepositoryForTest(fixture.Dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		revision repo.Revision
+		expected []repo.Line
+	}{
+		{repo.WorktreeRevision, []repo.Line{
+			{Revision: repo.WorktreeRevision, FileName: "lib.go", LineNumber: 3, Contents: "// TODO: staged"},
+			{Revision: head, FileName: "main.go", LineNumber: 3, Contents: "// TODO: committed"},
+			{Revision: repo.WorktreeRevision, FileName: "main.go", LineNumber: 4, Contents: "// TODO: unstaged"},
+			{Revision: repo.WorktreeRevision, FileName: "notes.txt", LineNumber: 1, Contents: "TODO: untracked"},
+			{Revision: repo.WorktreeRevision, FileName: "staged.go", LineNumber: 1, Contents: "// TODO: new file"},
+		}},
+		{repo.IndexRevision, []repo.Line{
+			{Revision: repo.IndexRevision, FileName: "lib.go", LineNumber: 3, Contents: "// TODO: staged"},
+			{Revision: head, FileName: "main.go", LineNumber: 3, Contents: "// TODO: committed"},
+			{Revision: repo.IndexRevision, FileName: "staged.go", LineNumber: 1, Contents: "// TODO: new file"},
+		}},
+	} {
+		todos := repository.LoadRevisionTodos(test.revision, "TODO", "")
+		sortLines(todos)
+		if !reflect.DeepEqual(todos, test.expected) {
+			t.Errorf("Expected the %s TODOs %v, but saw %v", test.revision, test.expected, todos)
+		}
+		fileTodos := repository.LoadFileTodos(test.revision, "main.go", "TODO")
+		var expectedFileTodos []repo.Line
+		for _, todo := range test.expected {
+			if todo.FileName == "main.go" {
+				expectedFileTodos = append(expectedFileTodos, todo)
+			}
+		}
+		if !reflect.DeepEqual(fileTodos, expectedFileTodos) {
+			t.Errorf("Expected the %s TODOs %v in main.go, but saw %v", test.revision, expectedFileTodos, fileTodos)
+		}
+	}
+}
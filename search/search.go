@@ -0,0 +1,298 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package search maintains a full-text index of the TODOs in every branch of a
+// set of repositories.
+//
+// The index is keyed by each TODO's location in the revision that last modified it,
+// so a TODO that is present in many branches is indexed once, along with the list
+// of branches that contain it. When a branch moves, only the TODOs of its old and
+// new revisions are re-indexed, and those are normally already in the repository's
+// TODO cache.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+// A TODO that matched a search, along with the branches that contain it.
+type Hit struct {
+	RepoId   string
+	RepoPath string
+	repo.Line
+	Branches []string
+}
+
+// The key of a single indexed TODO.
+type todoKey struct {
+	repoId string
+	todo   repo.Line
+}
+
+// The revision indexed for a branch, along with the keys of the TODOs indexed for it.
+// The keys are kept so that the branch can be removed from the index even when its old
+// revision can no longer be read.
+type indexedBranch struct {
+	revision repo.Revision
+	keys     []todoKey
+}
+
+// An indexed TODO, along with the set of branches that contain it.
+type entry struct {
+	repoPath string
+	branches map[string]bool
+}
+
+// Index is an inverted index from the words in TODOs to the TODOs that contain them.
+type Index struct {
+	Repositories map[string]*repo.Repository
+	TodoRegex    string
+	ExcludePaths string
+
+	// Held while updating the index, so that only one update runs at a time. An
+	// update only holds the mutex below while it applies its changes.
+	updating sync.Mutex
+	mutex    sync.RWMutex
+	updated  bool
+	entries  map[todoKey]*entry
+	// The TODOs containing each word.
+	postings map[string]map[todoKey]bool
+	// The sorted list of words in postings, or nil if it needs to be rebuilt.
+	words []string
+	// The revision and TODOs indexed for each branch, keyed by repo ID and then branch name.
+	branchRevisions map[string]map[string]indexedBranch
+}
+
+func NewIndex(repositories map[string]*repo.Repository, todoRegex, excludePaths string) *Index {
+	return &Index{
+		Repositories:    repositories,
+		TodoRegex:       todoRegex,
+		ExcludePaths:    excludePaths,
+		entries:         make(map[todoKey]*entry),
+		postings:        make(map[string]map[todoKey]bool),
+		branchRevisions: make(map[string]map[string]indexedBranch),
+	}
+}
+
+// Split the given text into lower-case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Index the given TODOs as being in a branch, and return their keys. The caller must
+// hold the index's lock.
+func (index *Index) addBranch(repoId, repoPath, branch string, todos []repo.Line) []todoKey {
+	keys := make([]todoKey, 0, len(todos))
+	for _, todo := range todos {
+		key := todoKey{repoId, todo}
+		keys = append(keys, key)
+		e, ok := index.entries[key]
+		if !ok {
+			e = &entry{repoPath: repoPath, branches: make(map[string]bool)}
+			index.entries[key] = e
+			for _, word := range tokenize(todo.Contents) {
+				if index.postings[word] == nil {
+					index.postings[word] = make(map[todoKey]bool)
+					index.words = nil
+				}
+				index.postings[word][key] = true
+			}
+		}
+		e.branches[branch] = true
+	}
+	return keys
+}
+
+// Stop indexing the given TODOs as being in a branch. The caller must hold the index's lock.
+func (index *Index) removeBranch(branch string, keys []todoKey) {
+	for _, key := range keys {
+		e, ok := index.entries[key]
+		if !ok {
+			continue
+		}
+		delete(e.branches, branch)
+		if len(e.branches) > 0 {
+			continue
+		}
+		// No branch contains the TODO anymore, so drop it from the index.
+		delete(index.entries, key)
+		for _, word := range tokenize(key.todo.Contents) {
+			delete(index.postings[word], key)
+			if len(index.postings[word]) == 0 {
+				delete(index.postings, word)
+				index.words = nil
+			}
+		}
+	}
+}
+
+// The TODOs of a branch's new revision, to be added to the index.
+type branchTodos struct {
+	branch   string
+	revision repo.Revision
+	todos    []repo.Line
+}
+
+// Bring the index up to date with the current branches of every repository.
+//
+// The TODOs of the branches that moved are loaded before taking the index's lock,
+// since scanning a revision can take a long time, and searches would wait on it.
+// The TODOs of their old revisions are not loaded again, but removed by the keys
+// that were indexed for them.
+func (index *Index) Update() {
+	index.updating.Lock()
+	defer index.updating.Unlock()
+	for repoId, repositoryPtr := range index.Repositories {
+		repository := *repositoryPtr
+		index.mutex.RLock()
+		oldBranches := index.branchRevisions[repoId]
+		index.mutex.RUnlock()
+		newBranches := make(map[string]indexedBranch)
+		listed := make(map[string]bool)
+		var removed []string
+		var added []branchTodos
+		for _, alias := range repository.ListBranches() {
+			listed[alias.Branch] = true
+			old, ok := oldBranches[alias.Branch]
+			if ok && old.revision == alias.Revision {
+				newBranches[alias.Branch] = old
+				continue
+			}
+			if ok {
+				removed = append(removed, alias.Branch)
+			}
+			added = append(added, branchTodos{alias.Branch, alias.Revision,
+				repository.LoadRevisionTodos(alias.Revision, index.TodoRegex, index.ExcludePaths)})
+		}
+		for branch := range oldBranches {
+			if !listed[branch] {
+				removed = append(removed, branch)
+			}
+		}
+
+		index.mutex.Lock()
+		for _, branch := range removed {
+			index.removeBranch(branch, oldBranches[branch].keys)
+		}
+		for _, change := range added {
+			keys := index.addBranch(repoId, repository.GetRepoPath(), change.branch, change.todos)
+			newBranches[change.branch] = indexedBranch{change.revision, keys}
+		}
+		index.branchRevisions[repoId] = newBranches
+		index.mutex.Unlock()
+	}
+	index.mutex.Lock()
+	index.updated = true
+	index.mutex.Unlock()
+}
+
+// Update the index at the given interval. This never returns.
+func (index *Index) Watch(interval time.Duration) {
+	for {
+		index.Update()
+		time.Sleep(interval)
+	}
+}
+
+// Return the TODOs containing a word that starts with the given prefix.
+// The caller must hold the index's lock.
+func (index *Index) matchPrefix(prefix string) map[todoKey]bool {
+	matches := make(map[todoKey]bool)
+	for i := sort.SearchStrings(index.words, prefix); i < len(index.words); i++ {
+		if !strings.HasPrefix(index.words[i], prefix) {
+			break
+		}
+		for key := range index.postings[index.words[i]] {
+			matches[key] = true
+		}
+	}
+	return matches
+}
+
+// Search for the TODOs containing every word of the query. Each word of the query
+// matches any word in a TODO that starts with it, ignoring case. At most maxHits
+// hits are returned, ordered by repository, file, and line.
+func (index *Index) Search(query string, maxHits int) []Hit {
+	index.mutex.RLock()
+	updated := index.updated
+	index.mutex.RUnlock()
+	if !updated {
+		index.Update()
+	}
+
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	if index.words == nil {
+		index.words = make([]string, 0, len(index.postings))
+		for word := range index.postings {
+			index.words = append(index.words, word)
+		}
+		sort.Strings(index.words)
+	}
+	var matches map[todoKey]bool
+	for _, word := range tokenize(query) {
+		wordMatches := index.matchPrefix(word)
+		if matches == nil {
+			matches = wordMatches
+			continue
+		}
+		for key := range matches {
+			if !wordMatches[key] {
+				delete(matches, key)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(matches))
+	for key := range matches {
+		e := index.entries[key]
+		branches := make([]string, 0, len(e.branches))
+		for branch := range e.branches {
+			branches = append(branches, branch)
+		}
+		sort.Strings(branches)
+		hits = append(hits, Hit{
+			RepoId:   key.repoId,
+			RepoPath: e.repoPath,
+			Line:     key.todo,
+			Branches: branches,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].RepoPath != hits[j].RepoPath {
+			return hits[i].RepoPath < hits[j].RepoPath
+		}
+		if hits[i].FileName != hits[j].FileName {
+			return hits[i].FileName < hits[j].FileName
+		}
+		if hits[i].LineNumber != hits[j].LineNumber {
+			return hits[i].LineNumber < hits[j].LineNumber
+		}
+		return hits[i].Revision < hits[j].Revision
+	})
+	if len(hits) > maxHits {
+		hits = hits[:maxHits]
+	}
+	return hits
+}
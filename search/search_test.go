@@ -0,0 +1,179 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package search_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+	"github.com/google/todo-tracks/search"
+)
+
+var sharedTodo = repo.Line{
+	Revision:   repo.Revision("base"),
+	FileName:   "parser.go",
+	LineNumber: 10,
+	Contents:   "// TODO: Handle unicode escapes in the parser",
+}
+var featureTodo = repo.Line{
+	Revision:   repo.Revision("feature"),
+	FileName:   "lexer.go",
+	LineNumber: 3,
+	Contents:   "// FIXME: the lexer drops unicode",
+}
+
+// A mock repository whose branches can be moved by the test.
+type movingRepository struct {
+	repotest.MockRepository
+	aliases *[]repo.Alias
+}
+
+func (repository movingRepository) ListBranches() []repo.Alias {
+	return *repository.aliases
+}
+
+func TestSearch(t *testing.T) {
+	aliases := []repo.Alias{
+		{Branch: "master", Revision: "base"},
+		{Branch: "feature", Revision: "feature"},
+	}
+	var repository repo.Repository = movingRepository{
+		MockRepository: repotest.MockRepository{
+			RevisionTodos: map[string][]repo.Line{
+				"base":    {sharedTodo},
+				"feature": {sharedTodo, featureTodo},
+				"fixed":   {},
+			},
+		},
+		aliases: &aliases,
+	}
+	index := search.NewIndex(
+		map[string]*repo.Repository{repository.GetRepoId(): &repository}, "", "")
+
+	hits := index.Search("UNICODE", 10)
+	expected := []search.Hit{
+		{RepoId: "repoID", RepoPath: "~/repo/path", Line: featureTodo, Branches: []string{"feature"}},
+		{RepoId: "repoID", RepoPath: "~/repo/path", Line: sharedTodo,
+			Branches: []string{"feature", "master"}},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Errorf("Expected %v, but saw %v", expected, hits)
+	}
+	if hits := index.Search("unic pars", 10); len(hits) != 1 || hits[0].Line != sharedTodo {
+		t.Errorf("Expected prefixes of every word to match %v, but saw %v", sharedTodo, hits)
+	}
+	if hits := index.Search("unicode", 1); len(hits) != 1 {
+		t.Errorf("Expected the hits to be limited to one, but saw %v", hits)
+	}
+
+	// Once the TODOs are fixed in every branch, they should no longer be found.
+	aliases = []repo.Alias{{Branch: "master", Revision: "fixed"}}
+	index.Update()
+	if hits := index.Search("unicode", 10); len(hits) != 0 {
+		t.Errorf("Expected no hits after the TODOs were removed, but saw %v", hits)
+	}
+}
+
+func TestSearchAfterOldRevisionIsPruned(t *testing.T) {
+	aliases := []repo.Alias{{Branch: "feature", Revision: "feature"}}
+	revisionTodos := map[string][]repo.Line{
+		"feature":   {sharedTodo, featureTodo},
+		"rewritten": {},
+	}
+	var repository repo.Repository = movingRepository{
+		MockRepository: repotest.MockRepository{RevisionTodos: revisionTodos},
+		aliases:        &aliases,
+	}
+	index := search.NewIndex(
+		map[string]*repo.Repository{repository.GetRepoId(): &repository}, "", "")
+	index.Update()
+
+	// The branch is force-pushed, and its old revision is garbage collected.
+	aliases = []repo.Alias{{Branch: "feature", Revision: "rewritten"}}
+	delete(revisionTodos, "feature")
+	index.Update()
+	if hits := index.Search("unicode", 10); len(hits) != 0 {
+		t.Errorf("Expected no hits after the branch was rewritten, but saw %v", hits)
+	}
+}
+
+// A mock repository that waits to be released before loading the TODOs of a revision.
+type slowRepository struct {
+	movingRepository
+	slowRevision repo.Revision
+	loading      chan bool
+	release      chan bool
+}
+
+func (repository slowRepository) LoadRevisionTodos(
+	revision repo.Revision, todoRegex, excludePaths string) []repo.Line {
+	if revision == repository.slowRevision {
+		repository.loading <- true
+		<-repository.release
+	}
+	return repository.MockRepository.LoadRevisionTodos(revision, todoRegex, excludePaths)
+}
+
+func TestSearchDuringUpdate(t *testing.T) {
+	aliases := []repo.Alias{{Branch: "master", Revision: "base"}}
+	slow := slowRepository{
+		movingRepository: movingRepository{
+			MockRepository: repotest.MockRepository{
+				RevisionTodos: map[string][]repo.Line{
+					"base":    {sharedTodo},
+					"feature": {sharedTodo, featureTodo},
+				},
+			},
+			aliases: &aliases,
+		},
+		slowRevision: "feature",
+		loading:      make(chan bool),
+		release:      make(chan bool),
+	}
+	var repository repo.Repository = slow
+	index := search.NewIndex(
+		map[string]*repo.Repository{repository.GetRepoId(): &repository}, "", "")
+	index.Update()
+
+	aliases = []repo.Alias{{Branch: "master", Revision: "feature"}}
+	done := make(chan bool)
+	go func() {
+		index.Update()
+		done <- true
+	}()
+	<-slow.loading
+	searched := make(chan []search.Hit)
+	go func() {
+		searched <- index.Search("unicode", 10)
+	}()
+	select {
+	case hits := <-searched:
+		if len(hits) != 1 || hits[0].Line != sharedTodo {
+			t.Errorf("Expected the TODOs from before the update, but saw %v", hits)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected searches not to wait for the TODOs of a moved branch to load")
+	}
+	close(slow.release)
+	<-done
+	if hits := index.Search("unicode", 10); len(hits) != 2 {
+		t.Errorf("Expected the TODOs from after the update, but saw %v", hits)
+	}
+}
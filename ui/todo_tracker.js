@@ -24,21 +24,85 @@ todoTrackerApp.controller("listRepos", function($scope,$http) {
 
   function processRepoListResponse(response) {
     var repos = []
+    var repoPaths = {}
+    for (var i in response) {
+      repoPaths[response[i].RepoId] = response[i].Path
+    }
     for (var i in response) {
       var repoRaw = response[i]
-      repos.push(new Repo(repoRaw.Path, repoRaw.RepoId))
+      var superproject = null
+      if (repoRaw.Superproject) {
+        superproject = new Superproject(repoRaw.Superproject.RepoId,
+            repoPaths[repoRaw.Superproject.RepoId], repoRaw.Superproject.Revision)
+      }
+      repos.push(new Repo(repoRaw.Path, repoRaw.RepoId, superproject))
     }
     return repos;
   }
 
-  function Repo(path, id) {
+  function Repo(path, id, superproject) {
     this.path = path;
     this.id = id;
+    this.superproject = superproject;
+  }
+
+  function Superproject(id, path, revision) {
+    this.id = id;
+    this.path = path;
+    this.revision = revision;
   }
 });
 
+todoTrackerApp.controller("searchTodos", function($scope,$http,$location) {
+  $scope.query = $location.search()['q'];
+
+  $scope.search = function() {
+    if (!$scope.query) {
+      return;
+    }
+    $location.search('q', $scope.query);
+    $http.get(window.location.protocol + "//" + window.location.host +
+        "/search?q=" + encodeURIComponent($scope.query))
+      .success(function(response) {$scope.hits = response;});
+  };
+  $scope.search();
+});
+
+todoTrackerApp.controller("compareRefs", function($scope,$http,$location) {
+  var repo = $location.search()['repo'];
+  $scope.repo = repo;
+  $scope.from = $location.search()['from'];
+  $scope.to = $location.search()['to'];
+  $http.get(window.location.protocol + "//" + window.location.host + "/aliases?repo=" + repo)
+    .success(function(response) {$scope.aliases = response;});
+
+  $scope.compare = function() {
+    if (!$scope.from || !$scope.to) {
+      return;
+    }
+    $location.search({'repo': repo, 'from': $scope.from, 'to': $scope.to});
+    $http.get(window.location.protocol + "//" + window.location.host +
+        "/compare?repo=" + repo + "&from=" + $scope.from + "&to=" + $scope.to)
+      .success(function(response) {
+        var moved = [];
+        for (var i = 0; i < response.Moved.length; i++) {
+          var todo = angular.copy(response.Moved[i].To);
+          todo.From = response.Moved[i].From;
+          moved.push(todo);
+        }
+        $scope.diff = response;
+        $scope.sections = [
+          {title: "Added", todos: response.Added},
+          {title: "Removed", todos: response.Removed},
+          {title: "Moved", todos: moved}];
+      });
+  };
+  $scope.compare();
+});
+
 todoTrackerApp.controller("listBranches", function($scope,$http,$location) {
   var repo = $location.search()['repo'];
+  $scope.repo = repo;
   $http.get(window.location.protocol + "//" + window.location.host + "/aliases?repo=" + repo)
     .success(function(response) {$scope.remotes = processBranchListResponse(response);});
 
@@ -48,23 +112,31 @@ todoTrackerApp.controller("listBranches", function($scope,$http,$location) {
     for (var i in response) {
       var oneBranchRaw = response[i];
       console.log("branch = " + oneBranchRaw.Branch);
-      var result = parseBranchName(oneBranchRaw.Branch);
-      // TODO: add lastModified and lastModifiedBy fields
-      var branch = new Branch(result[1], oneBranchRaw.Revision, "", "");
-      if (!(result[0] in remotesRaw)) {
-	remotesRaw[result[0]] = [];
+      var result = parseBranchName(oneBranchRaw);
+      var lastModified = "";
+      if (oneBranchRaw.LastModified) {
+        lastModified = new Date(oneBranchRaw.LastModified * 1000).toString();
       }
-      remotesRaw[result[0]].push(branch);
+      var branch = new Branch(result[2], oneBranchRaw.Revision, lastModified,
+          oneBranchRaw.LastModifiedBy);
+      branch.ahead = oneBranchRaw.Ahead;
+      branch.behind = oneBranchRaw.Behind;
+      branch.base = oneBranchRaw.Base;
+      branch.todoCount = oneBranchRaw.TodoCount;
+      var key = result[0] + ":" + result[1];
+      if (!(key in remotesRaw)) {
+	remotesRaw[key] = new Remote(result[0], result[1]);
+      }
+      remotesRaw[key].branches.push(branch);
     }
 
     var remotes = [];
     for (var r in remotesRaw) {
-      var remote = new Remote(r);
-      remote.branches = remotesRaw[r];
-      remotes.push(remote);
+      remotes.push(remotesRaw[r]);
     }
 
-    function Remote(name) {
+    function Remote(label, name) {
+      this.label = label;
       this.name = name;
       this.branches = [];
     }
@@ -77,12 +149,20 @@ todoTrackerApp.controller("listBranches", function($scope,$http,$location) {
       this.lastModifiedBy = lastModifiedBy;
     }
 
-    function parseBranchName(branchName) {
-      var result = branchName.split("/");
-      if (result.length >= 3 && result[0] == "remotes") {
-        return [result[1], result.slice(2).join('/')];
+    // Split an alias into the label and name of the group it is listed under, and its
+    // name within that group.
+    function parseBranchName(alias) {
+      var result = alias.Branch.split("/");
+      if (alias.Type == "tag") {
+        return ["Tags", "", result.slice(1).join('/')];
+      } else if (alias.Type == "ref") {
+        return ["Other refs", "", alias.Branch];
+      } else if (alias.Type == "uncommitted") {
+        return ["Uncommitted", "", alias.Branch];
+      } else if (result.length >= 3 && result[0] == "remotes") {
+        return ["Remote:", result[1], result.slice(2).join('/')];
       } else {
-        return ["", result.join('/')];
+        return ["Local:", "", result.join('/')];
       }
     }
 
@@ -91,12 +171,72 @@ todoTrackerApp.controller("listBranches", function($scope,$http,$location) {
   }
 });
 
+// Load the expired TODOs for a revision into $scope.expired, which maps
+// "fileName:lineNumber" to the explanations of why that TODO has expired.
+function loadExpiredTodos($scope, $http, repo, revision) {
+  $scope.expired = {};
+  $http.get(window.location.protocol + "//" + window.location.host +
+      "/expired?repo=" + repo + "&revision=" + revision)
+    .success(function(response) {
+      for (var i = 0; i < response.length; i++) {
+        var key = response[i].FileName + ":" + response[i].LineNumber;
+        if (!(key in $scope.expired)) {
+          $scope.expired[key] = [];
+        }
+        $scope.expired[key].push(response[i].Explanation);
+      }
+    });
+}
+
+// Load the TODOs for a revision, calling onTodos with all of the TODOs loaded so far
+// each time more of them arrive. If the browser supports it, the TODOs are streamed
+// while the revision is being scanned, and the scan's progress is kept in
+// $scope.progress until it finishes. If the stream fails, such as when a proxy does
+// not pass it through, every TODO is loaded at once instead.
+function streamRevisionTodos($scope, $http, repo, revision, onTodos) {
+  var loadAll = function() {
+    $http.get(window.location.protocol + "//" + window.location.host +
+        "/revision?repo=" + repo + "&revision=" + revision)
+      .success(onTodos);
+  };
+  if (!window.EventSource) {
+    loadAll();
+    return;
+  }
+  var url = window.location.protocol + "//" + window.location.host +
+      "/revisionStream?repo=" + repo + "&revision=" + revision;
+  var todos = [];
+  var source = new EventSource(url);
+  $scope.progress = {done: 0, total: 0};
+  source.addEventListener("progress", function(event) {
+    var progress = JSON.parse(event.data);
+    $scope.$apply(function() {
+      todos = todos.concat(progress.Todos);
+      $scope.progress = {done: progress.FilesDone, total: progress.FilesTotal};
+      onTodos(todos);
+    });
+  });
+  source.addEventListener("done", function(event) {
+    source.close();
+    $scope.$apply(function() {
+      $scope.progress = null;
+      onTodos(todos);
+    });
+  });
+  source.onerror = function() {
+    source.close();
+    $scope.$apply(function() {$scope.progress = null;});
+    loadAll();
+  };
+}
+
 todoTrackerApp.controller("listTodos", function($scope,$http,$location) {
   var repo = $location.search()['repo'];
   var revision = $location.search()['revision'];
-  $http.get(window.location.protocol + "//" + window.location.host +
-      "/revision?repo="+ repo + "&revision=" + revision)
-    .success(function(response) {$scope.revisions= processTodoListResponse(response);});
+  loadExpiredTodos($scope, $http, repo, revision);
+  streamRevisionTodos($scope, $http, repo, revision, function(todos) {
+    $scope.revisions = processTodoListResponse(todos);
+  });
 
    function processTodoListResponse(response) {
     var todosObj = response;
@@ -140,9 +280,10 @@ todoTrackerApp.controller("listTodos", function($scope,$http,$location) {
 todoTrackerApp.controller("listTodosPaths", function($scope,$http,$location) {
   var repo = $location.search()['repo'];
   var revision = $location.search()['revision'];
-  $http.get(window.location.protocol + "//" + window.location.host +
-      "/revision?repo="+ repo + "&revision=" + revision)
-    .success(function(response) {$scope.filenames = processTodoListPathsResponse(response);});
+  loadExpiredTodos($scope, $http, repo, revision);
+  streamRevisionTodos($scope, $http, repo, revision, function(todos) {
+    $scope.filenames = processTodoListPathsResponse(todos);
+  });
 
   function processTodoListPathsResponse(response) {
     var todosObj = response;
@@ -202,7 +343,24 @@ todoTrackerApp.controller("todoDetails", function($scope,$http,$location) {
   $http.get(window.location.protocol + "//" + window.location.host +
       "/todo?repo=" + repo + "&revision=" + revision +
       "&fileName=" + fileName + "&lineNumber=" + lineNumber)
-    .success(function(response) {$scope.todoDetails = processTodoDetailsResponse(response);});
+    .success(function(response) {
+      $scope.todoDetails = processTodoDetailsResponse(response);
+      $scope.canFileIssue = response.CanFileIssue;
+    });
+
+  $scope.fileIssue = function() {
+    $scope.canFileIssue = false;
+    $http.post(window.location.protocol + "//" + window.location.host +
+        "/fileIssue?repo=" + repo + "&revision=" + revision +
+        "&fileName=" + fileName + "&lineNumber=" + lineNumber,
+        null, {headers: {"X-Requested-With": "XMLHttpRequest"}})
+      .success(function(issue) {
+        $scope.todoDetails.push({key: "Issue", value: issue.Text, hasLink: true, link: issue.Url});
+      })
+      .error(function(response) {
+        $scope.fileIssueError = response;
+      });
+  };
 
   function processTodoDetailsResponse(response) {
     var detailsObj = response;
@@ -223,6 +381,16 @@ todoTrackerApp.controller("todoDetails", function($scope,$http,$location) {
           detailsObj.RevisionMetadata.Timestamp + ")",
           false, ""));
     todoDetails.push(new TodoDetail("Subject", detailsObj.RevisionMetadata.Subject, false, ""));
+    for (var i in detailsObj.Issues) {
+      var issue = detailsObj.Issues[i];
+      var issueText = issue.Text;
+      if (issue.State == "closed") {
+        issueText += " (closed: this TODO may be obsolete)";
+      } else if (issue.State) {
+        issueText += " (" + issue.State + ")";
+      }
+      todoDetails.push(new TodoDetail("Issue", issueText, true, issue.Url));
+    }
     // TODO: Display this with syntax highlighting and the TODO line highlighted.
     todoDetails.push(new TodoDetail("Context", detailsObj.Context, false, "", true));
 
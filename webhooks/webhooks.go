@@ -0,0 +1,317 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package webhooks notifies external services when the TODOs in a branch change.
+//
+// Each notification is a JSON-encoded Payload that is POSTed to every configured URL.
+// If a secret is configured, the body is signed using HMAC-SHA256, and the hex-encoded
+// signature is sent in the "X-Todos-Signature" header as "sha256=<signature>".
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+)
+
+const (
+	SignatureHeader = "X-Todos-Signature"
+	DeliveryHeader  = "X-Todos-Delivery"
+
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = time.Second
+	// Number of deliveries kept in the log served by ServeDeliveriesJson.
+	maxLoggedDeliveries = 100
+)
+
+// A TODO along with the metadata of the revision that last modified it.
+type Todo struct {
+	repo.Line
+	AuthorName  string
+	AuthorEmail string
+	Timestamp   int64
+	BrowseUrl   string
+}
+
+type TodoMove struct {
+	From Todo
+	To   Todo
+}
+
+// The body of a webhook notification.
+type Payload struct {
+	RepoId      string
+	RepoPath    string
+	Branch      string
+	OldRevision repo.Revision
+	NewRevision repo.Revision
+	Added       []Todo
+	Removed     []Todo
+	Moved       []TodoMove
+}
+
+// The record of sending a single payload to a single URL.
+type Delivery struct {
+	Id          int
+	Url         string
+	RepoId      string
+	Branch      string
+	NewRevision repo.Revision
+	Attempts    int
+	StatusCode  int
+	Error       string
+	Delivered   bool
+	LastAttempt time.Time
+}
+
+// Dispatcher sends payloads to a fixed set of URLs, retrying failed deliveries
+// with exponential backoff.
+type Dispatcher struct {
+	Urls           []string
+	Secret         string
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	Client         *http.Client
+
+	mutex          sync.Mutex
+	nextDeliveryId int
+	deliveries     []*Delivery
+	pending        sync.WaitGroup
+}
+
+func NewDispatcher(urls []string, secret string) *Dispatcher {
+	return &Dispatcher{
+		Urls:           urls,
+		Secret:         secret,
+		MaxAttempts:    defaultMaxAttempts,
+		InitialBackoff: defaultInitialBackoff,
+		Client:         &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Compute the value of the signature header for the given body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send the given payload to every URL. Deliveries happen asynchronously.
+func (dispatcher *Dispatcher) Dispatch(payload Payload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode the webhook payload: %v", err)
+		return
+	}
+	for _, url := range dispatcher.Urls {
+		delivery := dispatcher.newDelivery(url, payload)
+		dispatcher.pending.Add(1)
+		go func() {
+			defer dispatcher.pending.Done()
+			dispatcher.deliver(delivery, body)
+		}()
+	}
+}
+
+// Wait for all of the in-progress deliveries to finish.
+func (dispatcher *Dispatcher) Wait() {
+	dispatcher.pending.Wait()
+}
+
+func (dispatcher *Dispatcher) newDelivery(url string, payload Payload) *Delivery {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	dispatcher.nextDeliveryId++
+	delivery := &Delivery{
+		Id:          dispatcher.nextDeliveryId,
+		Url:         url,
+		RepoId:      payload.RepoId,
+		Branch:      payload.Branch,
+		NewRevision: payload.NewRevision,
+	}
+	dispatcher.deliveries = append(dispatcher.deliveries, delivery)
+	if len(dispatcher.deliveries) > maxLoggedDeliveries {
+		dispatcher.deliveries = dispatcher.deliveries[len(dispatcher.deliveries)-maxLoggedDeliveries:]
+	}
+	return delivery
+}
+
+// Make a single attempt at a delivery, and return whether or not it succeeded.
+func (dispatcher *Dispatcher) attempt(delivery *Delivery, body []byte) bool {
+	request, err := http.NewRequest("POST", delivery.Url, bytes.NewReader(body))
+	statusCode := 0
+	if err == nil {
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set(DeliveryHeader, fmt.Sprintf("%d", delivery.Id))
+		if dispatcher.Secret != "" {
+			request.Header.Set(SignatureHeader, Sign(dispatcher.Secret, body))
+		}
+		var response *http.Response
+		response, err = dispatcher.Client.Do(request)
+		if err == nil {
+			response.Body.Close()
+			statusCode = response.StatusCode
+			if statusCode < 200 || statusCode > 299 {
+				err = fmt.Errorf("Unexpected response status: %s", response.Status)
+			}
+		}
+	}
+
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	delivery.Attempts++
+	delivery.LastAttempt = time.Now()
+	delivery.StatusCode = statusCode
+	if err != nil {
+		delivery.Error = err.Error()
+		return false
+	}
+	delivery.Error = ""
+	delivery.Delivered = true
+	return true
+}
+
+func (dispatcher *Dispatcher) deliver(delivery *Delivery, body []byte) {
+	backoff := dispatcher.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		if dispatcher.attempt(delivery, body) {
+			return
+		}
+		if attempt >= dispatcher.MaxAttempts {
+			log.Printf("Giving up on webhook delivery %d to %s", delivery.Id, delivery.Url)
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Return a snapshot of the most recent deliveries, newest first.
+func (dispatcher *Dispatcher) Deliveries() []Delivery {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+	deliveries := make([]Delivery, 0, len(dispatcher.deliveries))
+	for i := len(dispatcher.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *dispatcher.deliveries[i])
+	}
+	return deliveries
+}
+
+// Serve the JSON log of the most recent deliveries.
+func (dispatcher *Dispatcher) ServeDeliveriesJson(w http.ResponseWriter, r *http.Request) {
+	deliveriesJson, err := json.Marshal(dispatcher.Deliveries())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Server error \"%s\"", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(deliveriesJson)
+}
+
+func loadTodo(repository repo.Repository, line repo.Line) Todo {
+	metadata := repository.ReadRevisionMetadata(line.Revision)
+	return Todo{
+		Line:        line,
+		AuthorName:  metadata.AuthorName,
+		AuthorEmail: metadata.AuthorEmail,
+		Timestamp:   metadata.Timestamp,
+		BrowseUrl:   repository.GetBrowseUrl(line.Revision, line.FileName, line.LineNumber),
+	}
+}
+
+// Build the payload describing how the TODOs changed when a branch moved.
+func NewPayload(repository repo.Repository, branch string, oldRevision, newRevision repo.Revision,
+	todoRegex, excludePaths string) Payload {
+	diff := repo.DiffTodos(
+		repository.LoadRevisionTodos(oldRevision, todoRegex, excludePaths),
+		repository.LoadRevisionTodos(newRevision, todoRegex, excludePaths))
+	payload := Payload{
+		RepoId:      repository.GetRepoId(),
+		RepoPath:    repository.GetRepoPath(),
+		Branch:      branch,
+		OldRevision: oldRevision,
+		NewRevision: newRevision,
+		Added:       make([]Todo, 0),
+		Removed:     make([]Todo, 0),
+		Moved:       make([]TodoMove, 0),
+	}
+	for _, line := range diff.Added {
+		payload.Added = append(payload.Added, loadTodo(repository, line))
+	}
+	for _, line := range diff.Removed {
+		payload.Removed = append(payload.Removed, loadTodo(repository, line))
+	}
+	for _, move := range diff.Moved {
+		payload.Moved = append(payload.Moved, TodoMove{
+			From: loadTodo(repository, move.From),
+			To:   loadTodo(repository, move.To),
+		})
+	}
+	return payload
+}
+
+func (payload Payload) isEmpty() bool {
+	return len(payload.Added) == 0 && len(payload.Removed) == 0 && len(payload.Moved) == 0
+}
+
+// Watcher polls a repository's branches, and dispatches a payload whenever a
+// branch moves and its TODOs change. Branches that are created or deleted
+// are not reported.
+type Watcher struct {
+	Repository   repo.Repository
+	TodoRegex    string
+	ExcludePaths string
+	Dispatcher   *Dispatcher
+
+	branchRevisions map[string]repo.Revision
+}
+
+// Compare the repository's branches to those seen in the previous check, and
+// dispatch a payload for each branch whose TODOs changed.
+func (watcher *Watcher) Check() {
+	branchRevisions := make(map[string]repo.Revision)
+	for _, alias := range watcher.Repository.ListBranches() {
+		branchRevisions[alias.Branch] = alias.Revision
+		oldRevision, ok := watcher.branchRevisions[alias.Branch]
+		if watcher.branchRevisions == nil || !ok || oldRevision == alias.Revision {
+			continue
+		}
+		payload := NewPayload(watcher.Repository, alias.Branch, oldRevision, alias.Revision,
+			watcher.TodoRegex, watcher.ExcludePaths)
+		if !payload.isEmpty() {
+			watcher.Dispatcher.Dispatch(payload)
+		}
+	}
+	watcher.branchRevisions = branchRevisions
+}
+
+// Check the repository for changes at the given interval. This never returns.
+func (watcher *Watcher) Watch(interval time.Duration) {
+	for {
+		watcher.Check()
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2014 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/todo-tracks/repo"
+	"github.com/google/todo-tracks/repo/repotest"
+	"github.com/google/todo-tracks/webhooks"
+)
+
+const (
+	TestSecret = "testSecret"
+)
+
+var oldTodo = repo.Line{
+	Revision:   repo.Revision("oldRevision"),
+	FileName:   "oldFile",
+	LineNumber: 1,
+	Contents:   "TODO: old",
+}
+var newTodo = repo.Line{
+	Revision:   repo.Revision("newRevision"),
+	FileName:   "newFile",
+	LineNumber: 2,
+	Contents:   "TODO: new",
+}
+
+// A local HTTP receiver that fails the first few requests it receives.
+type receiver struct {
+	mutex         sync.Mutex
+	failuresLeft  int
+	payloads      []webhooks.Payload
+	badSignatures int
+}
+
+func (rcv *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rcv.mutex.Lock()
+	defer rcv.mutex.Unlock()
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get(webhooks.SignatureHeader) != webhooks.Sign(TestSecret, body) {
+		rcv.badSignatures++
+	}
+	if rcv.failuresLeft > 0 {
+		rcv.failuresLeft--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var payload webhooks.Payload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rcv.payloads = append(rcv.payloads, payload)
+}
+
+// A mock repository whose branch can be moved by the test.
+type movingRepository struct {
+	repotest.MockRepository
+	revision *repo.Revision
+}
+
+func (repository movingRepository) ListBranches() []repo.Alias {
+	return []repo.Alias{{Branch: "master", Revision: *repository.revision}}
+}
+
+func TestWatcherDeliversChanges(t *testing.T) {
+	rcv := &receiver{failuresLeft: 2}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	revision := repo.Revision("oldRevision")
+	repository := movingRepository{
+		MockRepository: repotest.MockRepository{
+			RevisionTodos: map[string][]repo.Line{
+				"oldRevision": {oldTodo},
+				"newRevision": {newTodo},
+			},
+		},
+		revision: &revision,
+	}
+	dispatcher := webhooks.NewDispatcher([]string{server.URL}, TestSecret)
+	dispatcher.InitialBackoff = time.Millisecond
+	watcher := &webhooks.Watcher{Repository: repository, Dispatcher: dispatcher}
+
+	watcher.Check()
+	revision = repo.Revision("newRevision")
+	watcher.Check()
+	watcher.Check()
+	dispatcher.Wait()
+
+	if rcv.badSignatures != 0 {
+		t.Errorf("Expected every request to be signed, but saw %d bad signatures", rcv.badSignatures)
+	}
+	if len(rcv.payloads) != 1 {
+		t.Fatalf("Expected a single payload, but saw %v", rcv.payloads)
+	}
+	payload := rcv.payloads[0]
+	if payload.Branch != "master" || payload.OldRevision != "oldRevision" ||
+		payload.NewRevision != "newRevision" ||
+		len(payload.Added) != 1 || payload.Added[0].Line != newTodo ||
+		len(payload.Removed) != 1 || payload.Removed[0].Line != oldTodo {
+		t.Errorf("Unexpected payload %v", payload)
+	}
+	deliveries := dispatcher.Deliveries()
+	if len(deliveries) != 1 || !deliveries[0].Delivered || deliveries[0].Attempts != 3 {
+		t.Errorf("Expected a delivery that succeeded on the third attempt, but saw %v", deliveries)
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	rcv := &receiver{failuresLeft: 10}
+	server := httptest.NewServer(rcv)
+	defer server.Close()
+
+	dispatcher := webhooks.NewDispatcher([]string{server.URL}, TestSecret)
+	dispatcher.InitialBackoff = time.Millisecond
+	dispatcher.MaxAttempts = 2
+	dispatcher.Dispatch(webhooks.Payload{Branch: "master"})
+	dispatcher.Wait()
+
+	deliveries := dispatcher.Deliveries()
+	if len(deliveries) != 1 || deliveries[0].Delivered || deliveries[0].Attempts != 2 ||
+		deliveries[0].StatusCode != http.StatusServiceUnavailable || deliveries[0].Error == "" {
+		t.Errorf("Expected a failed delivery after two attempts, but saw %v", deliveries)
+	}
+}